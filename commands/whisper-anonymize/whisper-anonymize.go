@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"log"
+	"os"
+)
+
+func main() {
+	var opts whisper.AnonymizeOptions
+	flag.BoolVar(&opts.PreserveScale, "preserveScale", false, "keep the sign and power of ten of every value")
+	flag.BoolVar(&opts.PreserveShape, "preserveShape", false, "multiply every value by the same random factor")
+	flag.Int64Var(&opts.Seed, "seed", 0, "seed for the random values, random if zero")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... SOURCE DESTINATION\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		log.Fatal("error: you must specify a source and a destination file")
+	}
+
+	err := whisper.Anonymize(flag.Arg(0), flag.Arg(1), opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -6,16 +6,27 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 )
 
 var aggregationMethod whisper.AggregationMethod = whisper.AGGREGATION_AVERAGE
 var xFilesFactor float64
+var overwrite bool
+var sync bool
+var sparse bool
+var preallocate bool
+var mkdir bool
 
 func main() {
 	flag.Var(&aggregationMethod, "aggregationMethod", "aggregation method to use")
 	flag.Float64Var(&xFilesFactor, "xFilesFactor", 0.5, "x-files factor")
+	flag.BoolVar(&overwrite, "overwrite", false, "replace the file if it already exists")
+	flag.BoolVar(&sync, "sync", false, "flush the new file to disk before exiting")
+	flag.BoolVar(&sparse, "sparse", false, "create a sparse file, allocating the archives as they are written")
+	flag.BoolVar(&mkdir, "mkdir", false, "create any missing parent directories of the file")
+	flag.BoolVar(&preallocate, "preallocate", false, "allocate the archives with fallocate where supported instead of writing zeroes")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... [FILE] [PRECISION:RETENTION]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... FILE PRECISION:RETENTION...\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
@@ -41,16 +52,20 @@ func main() {
 	path := args[0]
 	archiveStrings := args[1:]
 
-	var archives []whisper.ArchiveInfo
-	for _, s := range archiveStrings {
-		archive, err := whisper.ParseArchiveInfo(s)
-		if err != nil {
-			log.Fatal(fmt.Sprintf("error: %s", err))
+	// Archives may also be given as a single space or comma separated argument, eg: "10s:6h 1m:7d"
+	archives, err := whisper.ParseRetentionDefs(strings.Join(strings.Fields(strings.Join(archiveStrings, " ")), ","))
+	if err != nil {
+		log.Fatal(fmt.Sprintf("error: %s", err))
+	}
+
+	if overwrite {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
 		}
-		archives = append(archives, archive)
 	}
 
-	err := whisper.Create(path, archives, float32(xFilesFactor), aggregationMethod, false)
+	err = whisper.CreateWithOptions(path, archives, float32(xFilesFactor), aggregationMethod, whisper.CreateOptions{Sparse: sparse, Preallocate: preallocate, Sync: sync, MkdirAll: mkdir})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"log"
+	"os"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... ROOT_A ROOT_B\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		log.Fatal("error: you must specify two directories to compare")
+	}
+
+	rootA, rootB := flag.Arg(0), flag.Arg(1)
+	diff, err := whisper.DiffTrees(rootA, rootB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, path := range diff.OnlyInA {
+		fmt.Printf("only in %s: %s\n", rootA, path)
+	}
+	for _, path := range diff.OnlyInB {
+		fmt.Printf("only in %s: %s\n", rootB, path)
+	}
+	for _, m := range diff.Mismatched {
+		fmt.Printf("headers differ: %s\n", m.Path)
+		fmt.Printf("\t%s: %s\n", rootA, describe(m.A))
+		fmt.Printf("\t%s: %s\n", rootB, describe(m.B))
+	}
+	for _, u := range diff.Unreadable {
+		fmt.Printf("unreadable in %s: %s: %s\n", u.Root, u.Path, u.Err)
+	}
+
+	if !diff.Empty() {
+		os.Exit(1)
+	}
+}
+
+// Summarize the retention, aggregation method and xFilesFactor of a header
+func describe(h whisper.Header) (s string) {
+	for i, archive := range h.Archives {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf("%d:%d", archive.SecondsPerPoint, archive.Points)
+	}
+	return fmt.Sprintf("%s aggregationMethod=%s xFilesFactor=%g", s, h.Metadata.AggregationMethod.String(), h.Metadata.XFilesFactor)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"log"
+	"os"
+)
+
+var canonical = flag.Bool("canonical", false, "Print the canonical dump format, for golden files and diffs")
+var stream = flag.Bool("stream", false, "Write the binary backup stream of the whole database, which whisper-restore reads")
+var compress = flag.Bool("gzip", false, "Compress the backup stream written by -stream")
+var since = flag.Uint("since", 0, "With -canonical, print only the points of each archive with a timestamp after this one, for incremental backups")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		log.Fatal("error: you must specify a filename")
+	}
+
+	w, err := whisper.OpenReadOnly(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+
+	if *stream {
+		format := whisper.DUMP_RAW
+		if *compress {
+			format = whisper.DUMP_GZIP
+		}
+		if err := w.DumpTo(os.Stdout, format); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *canonical {
+		var dump whisper.Dump
+		if *since > 0 {
+			dump, err = w.ChangedSince(uint32(*since))
+		} else {
+			dump, err = w.Dump()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := dump.WriteTo(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	// Mirror the output of whisper-dump.py
+	metadata := w.Header.Metadata
+	fmt.Fprintf(out, "Meta data:\n")
+	fmt.Fprintf(out, "  aggregation method: %s\n", metadata.AggregationMethod.String())
+	fmt.Fprintf(out, "  max retention: %d\n", metadata.MaxRetention)
+	fmt.Fprintf(out, "  xFilesFactor: %g\n", metadata.XFilesFactor)
+
+	for i, archive := range w.Header.Archives {
+		fmt.Fprintf(out, "\nArchive %d info:\n", i)
+		fmt.Fprintf(out, "  offset: %d\n", archive.Offset)
+		fmt.Fprintf(out, "  seconds per point: %d\n", archive.SecondsPerPoint)
+		fmt.Fprintf(out, "  points: %d\n", archive.Points)
+		fmt.Fprintf(out, "  retention: %d\n", archive.Retention())
+		fmt.Fprintf(out, "  size: %d\n", archive.Points*uint32(binary.Size(whisper.Point{})))
+	}
+
+	for i, archive := range w.Header.Archives {
+		points, err := w.ReadSlots(archive)
+		if err != nil {
+			out.Flush()
+			log.Fatal(err)
+		}
+		fmt.Fprintf(out, "\nArchive %d data:\n", i)
+		for slot, point := range points {
+			fmt.Fprintf(out, "%d: %d, %10.35g\n", slot, point.Timestamp, point.Value)
+		}
+	}
+}
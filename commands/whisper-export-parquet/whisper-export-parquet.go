@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisperparquet"
+	"log"
+	"os"
+)
+
+var output = flag.String("o", "", "write the Parquet file here instead of to standard output")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... ROOT\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		log.Fatal("error: you must specify the directory to export")
+	}
+
+	file := os.Stdout
+	if *output != "" {
+		var err error
+		file, err = os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	out := bufio.NewWriter(file)
+	if err := whisperparquet.ExportTree(flag.Arg(0), out); err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
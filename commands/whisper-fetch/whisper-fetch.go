@@ -2,16 +2,36 @@ package main
 
 import (
 	"github.com/kisielk/whisper-go/whisper"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 )
 
 var from, until uint
+var pretty, jsonOutput bool
+var target string
+var measurement string
+var tags = tagFlag{}
 
-func usage() {
-	log.Fatal("Wrong number of arguments")
+// A flag setting tags of the form KEY=VALUE, which can be given several times
+type tagFlag map[string]string
+
+func (t tagFlag) String() string {
+	return fmt.Sprint(map[string]string(t))
+}
+
+func (t tagFlag) Set(tag string) error {
+	key, value, found := strings.Cut(tag, "=")
+	if !found {
+		return errors.New(fmt.Sprintf("tag %q isn't of the form KEY=VALUE", tag))
+	}
+	t[key] = value
+	return nil
 }
 
 func main() {
@@ -19,26 +39,72 @@ func main() {
 	yesterday := uint(time.Now().Add(-24 * time.Hour).Unix())
 	flag.UintVar(&from, "from", yesterday, "Unix epoch time of the beginning of the requested interval. (default: 24 hours ago)")
 	flag.UintVar(&until, "until", now, "Unix epoch time of the end of the requested interval. (default: now)")
+	flag.BoolVar(&pretty, "pretty", false, "show human-readable timestamps instead of unix times")
+	flag.BoolVar(&jsonOutput, "json", false, "print the interval and values as JSON")
+	flag.StringVar(&target, "render", "", "print the values as the JSON of graphite-web's render API, naming the series TARGET")
+	flag.StringVar(&measurement, "line-protocol", "", "print the values as InfluxDB line protocol, naming the measurement MEASUREMENT")
+	flag.Var(tags, "tag", "add the tag KEY=VALUE to every line printed by -line-protocol; may be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	log.SetFlags(0)
+
 	if flag.NArg() != 1 {
-		usage()
+		flag.Usage()
+		log.Fatal("error: you must specify a filename")
 	}
 
 	path := flag.Args()[0]
 	fromTime := uint32(from)
 	untilTime := uint32(until)
 
-	w, err := whisper.Open(path)
+	w, err := whisper.OpenReadOnly(path)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer w.Close()
+
+	if measurement != "" {
+		if err := w.ExportLineProtocol(os.Stdout, measurement, tags, fromTime, untilTime); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	interval, points, err := w.FetchUntil(fromTime, untilTime)
+	series, err := w.FetchSeries(fromTime, untilTime)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if target != "" {
+		err = json.NewEncoder(os.Stdout).Encode([]whisper.RenderSeries{{Target: target, Series: series}})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if jsonOutput {
+		err = json.NewEncoder(os.Stdout).Encode(series)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	fmt.Printf("Values in interval %+v\n", interval)
-	for i, p := range points {
-		fmt.Printf("%d %v\n", i, p)
+	for i, value := range series.Values {
+		timestamp := series.From + uint32(i)*series.Step
+		timeString := fmt.Sprint(timestamp)
+		if pretty {
+			timeString = time.Unix(int64(timestamp), 0).Format(time.ANSIC)
+		}
+		if value == nil {
+			fmt.Printf("%s\tNone\n", timeString)
+		} else {
+			fmt.Printf("%s\t%f\n", timeString, *value)
+		}
 	}
-	return
 }
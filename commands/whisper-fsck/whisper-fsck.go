@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"log"
+	"os"
+)
+
+var (
+	repair   = flag.Bool("repair", false, "Repair the damage that was found")
+	dryRun   = flag.Bool("dryRun", false, "With -repair, print the repairs without making them")
+	noBackup = flag.Bool("nobackup", false, "With -repair, don't keep a copy of damaged files at FILE.bak")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s FILE...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		log.Fatal("error: you must specify at least one file")
+	}
+
+	// Exit with 1 if any file is damaged, and 2 if any file can't be read or repaired
+	status := 0
+	for _, path := range flag.Args() {
+		var anomalies []whisper.Anomaly
+		var err error
+		if *repair {
+			anomalies, err = whisper.Repair(path, whisper.RepairOptions{DryRun: *dryRun, NoBackup: *noBackup})
+		} else {
+			anomalies, err = whisper.CheckIntegrity(path)
+		}
+		if err != nil {
+			log.Print(err)
+			status = 2
+			continue
+		}
+		for _, anomaly := range anomalies {
+			fmt.Printf("%s: %s\n", path, anomaly)
+		}
+		if len(anomalies) > 0 && status == 0 {
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
@@ -2,39 +2,120 @@ package main
 
 import (
 	"github.com/kisielk/whisper-go/whisper"
+	"encoding/binary"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 )
 
-func usage() {
-	log.Fatal("Wrong number of arguments")
+var jsonOutput = flag.Bool("json", false, "print the information as JSON")
+
+type archiveInfo struct {
+	Offset          uint32 `json:"offset"`
+	SecondsPerPoint uint32 `json:"secondsPerPoint"`
+	Points          uint32 `json:"points"`
+	Retention       uint32 `json:"retention"`
+	Size            uint32 `json:"size"`
+}
+
+type info struct {
+	Name              string        `json:"name,omitempty"`
+	Unit              string        `json:"unit,omitempty"`
+	Description       string        `json:"description,omitempty"`
+	AggregationMethod string        `json:"aggregationMethod"`
+	MaxRetention      uint32        `json:"maxRetention"`
+	XFilesFactor      float32       `json:"xFilesFactor"`
+	FileSize          int64         `json:"fileSize"`
+	Archives          []archiveInfo `json:"archives"`
 }
 
 func main() {
-	flag.Parse()	
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
 	if flag.NArg() != 1 {
-		usage()
+		flag.Usage()
+		log.Fatal("error: you must specify a filename")
 	}
 
 	path := flag.Args()[0]
 
-	w, err := whisper.Open(path)
+	w, err := whisper.OpenReadOnly(path)
 	if err != nil {
 		log.Fatal(err)
 	}
-	
+	defer w.Close()
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	series, err := w.SeriesInfo()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pointSize := uint32(binary.Size(whisper.Point{}))
+	i := info{
+		Name:              series.Name,
+		Unit:              series.Unit,
+		Description:       series.Description,
+		AggregationMethod: w.Header.Metadata.AggregationMethod.String(),
+		MaxRetention:      w.Header.Metadata.MaxRetention,
+		XFilesFactor:      w.Header.Metadata.XFilesFactor,
+		FileSize:          stat.Size(),
+	}
+	for _, archive := range w.Header.Archives {
+		i.Archives = append(i.Archives, archiveInfo{
+			Offset:          archive.Offset,
+			SecondsPerPoint: archive.SecondsPerPoint,
+			Points:          archive.Points,
+			Retention:       archive.Retention(),
+			Size:            archive.Points * pointSize,
+		})
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(i)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fmt.Printf("%s:\n", path)
-	fmt.Printf("Maximum retention:\t%d\n", w.Header.Metadata.MaxRetention)
-	fmt.Printf("X-Files factor:\t\t%f\n", w.Header.Metadata.XFilesFactor)
-	fmt.Printf("Number of archives:\t%d\n", w.Header.Metadata.ArchiveCount)
-	fmt.Printf("Aggregation method:\t%s\n", w.Header.Metadata.AggregationMethod.String())
+	if i.Name != "" {
+		fmt.Printf("Name:\t\t\t%s\n", i.Name)
+	}
+	if i.Unit != "" {
+		fmt.Printf("Unit:\t\t\t%s\n", i.Unit)
+	}
+	if i.Description != "" {
+		fmt.Printf("Description:\t\t%s\n", i.Description)
+	}
+	fmt.Printf("Maximum retention:\t%d\n", i.MaxRetention)
+	fmt.Printf("X-Files factor:\t\t%f\n", i.XFilesFactor)
+	fmt.Printf("Number of archives:\t%d\n", len(i.Archives))
+	fmt.Printf("Aggregation method:\t%s\n", i.AggregationMethod)
+	fmt.Printf("File size:\t\t%d\n", i.FileSize)
 	fmt.Printf("\n")
 
-	for i, archive := range w.Header.Archives {
-		fmt.Printf("Archive %d:\n", i)
+	for n, archive := range i.Archives {
+		fmt.Printf("Archive %d:\n", n)
+		fmt.Printf("Offset:\t\t\t%d\n", archive.Offset)
 		fmt.Printf("Seconds per point:\t%d\n", archive.SecondsPerPoint)
 		fmt.Printf("Points:\t\t\t%d\n", archive.Points)
-		fmt.Printf("Retention:\t\t%d\n", archive.SecondsPerPoint * archive.Points)
+		fmt.Printf("Retention:\t\t%d\n", archive.Retention)
+		fmt.Printf("Size:\t\t\t%d\n", archive.Size)
 	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/manifest"
+	"log"
+	"os"
+)
+
+var depth = flag.Int("depth", 1, "number of directories in the prefixes growth is summed over")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... OLD_MANIFEST NEW_MANIFEST\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		log.Fatal("error: you must specify two manifests to compare")
+	}
+
+	old, err := read(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	new, err := read(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	changes := manifest.Diff(old, new, *depth)
+	for _, path := range changes.Added {
+		fmt.Printf("added: %s\n", path)
+	}
+	for _, path := range changes.Deleted {
+		fmt.Printf("deleted: %s\n", path)
+	}
+	for _, change := range changes.Retention {
+		fmt.Printf("retention changed: %s: %s -> %s\n", change.Path, change.Old, change.New)
+	}
+	for _, g := range changes.Growth {
+		fmt.Printf("growth: %s: %+d metrics (%d -> %d), %+d bytes (%d -> %d)\n", g.Prefix,
+			g.NewMetrics-g.OldMetrics, g.OldMetrics, g.NewMetrics, g.NewBytes-g.OldBytes, g.OldBytes, g.NewBytes)
+	}
+}
+
+// Read the manifest at path
+func read(path string) (m manifest.Manifest, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	m, err = manifest.Read(file)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/manifest"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var workers = flag.Int("workers", 4, "number of databases to read concurrently")
+var verify = flag.String("verify", "", "verify ROOT against this manifest instead of writing one")
+var snapshot = flag.String("snapshot", "", "write the manifest to a file in this directory named after the current time, for comparing with whisper-manifest-diff")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... ROOT\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		log.Fatal("error: you must specify the directory to read")
+	}
+	root := flag.Arg(0)
+
+	if *verify == "" {
+		m, err := manifest.Generate(root, *workers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *snapshot != "" {
+			err = writeSnapshot(m, *snapshot)
+		} else {
+			err = m.Write(os.Stdout)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	file, err := os.Open(*verify)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := manifest.Read(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("%s: %s", *verify, err)
+	}
+
+	mismatches, err := manifest.Verify(root, m, *workers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, mismatch := range mismatches {
+		fmt.Printf("%s: %s\n", mismatch.Path, mismatch.Reason)
+	}
+	if len(mismatches) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Write a manifest to dir, named after the current time so snapshots sort in the order they were taken
+func writeSnapshot(m manifest.Manifest, dir string) error {
+	path := filepath.Join(dir, time.Now().UTC().Format("20060102T150405Z")+".manifest")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	err = m.Write(file)
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
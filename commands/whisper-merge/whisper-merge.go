@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"log"
+	"os"
+)
+
+var from = flag.Uint("from", 0, "only copy points at or after this unix time")
+var until = flag.Uint("until", 0, "only copy points before this unix time (default: no limit)")
+var dryRun = flag.Bool("dry-run", false, "report how many points would be copied without changing DST")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... SRC DST\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Copy the points of SRC into DST, keeping the points DST already has. Both must have the same archives.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		log.Fatal("error: you must specify a source and a destination")
+	}
+
+	src, err := whisper.OpenReadOnly(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer src.Close()
+
+	dst, err := whisper.Open(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dst.Close()
+
+	opts := whisper.MergeOptions{From: uint32(*from), Until: uint32(*until), DryRun: *dryRun}
+	copied, err := whisper.MergeWithOptions(src, dst, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *dryRun {
+		fmt.Printf("would copy %d points\n", copied)
+	} else {
+		fmt.Printf("copied %d points\n", copied)
+	}
+}
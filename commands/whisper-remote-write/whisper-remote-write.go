@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/remotewrite"
+	"github.com/kisielk/whisper-go/schemas"
+	"github.com/kisielk/whisper-go/whisper"
+	"github.com/kisielk/whisper-go/whisperwalk"
+	"log"
+	"net/http"
+	"os"
+)
+
+var addr = flag.String("addr", "localhost:9201", "address to accept remote_write requests on")
+var schemasPath = flag.String("schemas", "", "storage-schemas.conf to create new databases with (required)")
+var overridesPath = flag.String("overrides", "", "overrides of the settings of single metrics")
+var maxOpen = flag.Int("max-open", 1000, "number of databases to keep open")
+var xFilesFactor = flag.Float64("xFilesFactor", 0.5, "x-files factor of new databases")
+var aggregationMethod whisper.AggregationMethod = whisper.AGGREGATION_AVERAGE
+var templates templateFlag
+
+// A flag adding a template, which can be given several times
+type templateFlag []*remotewrite.Template
+
+func (t *templateFlag) String() string {
+	return fmt.Sprint(*t)
+}
+
+func (t *templateFlag) Set(text string) error {
+	template, err := remotewrite.ParseTemplate(text)
+	if err != nil {
+		return err
+	}
+	*t = append(*t, template)
+	return nil
+}
+
+func main() {
+	flag.Var(&aggregationMethod, "aggregationMethod", "aggregation method of new databases")
+	flag.Var(&templates, "template", "name series matching TEMPLATE after their labels, eg: prometheus.{job}.{instance}.{__name__}; may be repeated, the first that applies is used")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... ROOT\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		log.Fatal("error: you must specify the directory to write to")
+	}
+	if *schemasPath == "" {
+		flag.Usage()
+		log.Fatal("error: you must specify a schemas file")
+	}
+	root := flag.Arg(0)
+
+	s, err := schemas.ReadFile(*schemasPath)
+	if err != nil {
+		log.Fatalf("%s: %s", *schemasPath, err)
+	}
+	var overrides schemas.Overrides
+	if *overridesPath != "" {
+		overrides, err = schemas.ReadOverridesFile(*overridesPath)
+		if err != nil {
+			log.Fatalf("%s: %s", *overridesPath, err)
+		}
+	}
+	layout, err := whisperwalk.ReadLayout(root)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pool := whisper.NewPool(*maxOpen)
+	defer pool.Close()
+	handler := remotewrite.NewHandler(root, s, pool, remotewrite.Options{
+		Templates:         templates,
+		Layout:            layout,
+		Overrides:         overrides,
+		XFilesFactor:      float32(*xFilesFactor),
+		AggregationMethod: aggregationMethod,
+	})
+	http.Handle("/write", handler)
+
+	log.Printf("accepting remote_write requests for %s on http://%s/write", root, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"log"
+	"os"
+	"strings"
+)
+
+var aggregationMethod whisper.AggregationMethod = whisper.AGGREGATION_UNKNOWN
+var xFilesFactor = flag.Float64("xFilesFactor", -1, "change the x-files factor (default: keep the current value)")
+var aggregate = flag.Bool("aggregate", false, "aggregate high precision data when moving it into lower precision archives, instead of keeping the last value")
+var noBackup = flag.Bool("nobackup", false, "don't keep a copy of the original file at FILE.bak")
+
+func main() {
+	flag.Var(&aggregationMethod, "aggregationMethod", "change the aggregation method (default: keep the current method)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... FILE PRECISION:RETENTION...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		log.Fatal("error: you must specify a filename and at least one PRECISION:RETENTION pair")
+	}
+
+	args := flag.Args()
+	path := args[0]
+
+	// Archives may also be given as a single space or comma separated argument, eg: "10s:6h 1m:7d"
+	archives, err := whisper.ParseRetentionDefs(strings.Join(strings.Fields(strings.Join(args[1:], " ")), ","))
+	if err != nil {
+		log.Fatalf("error: %s", err)
+	}
+
+	opts := whisper.ResizeOptions{
+		AggregationMethod: aggregationMethod,
+		Aggregate:         *aggregate,
+		NoBackup:          *noBackup,
+	}
+	if *xFilesFactor >= 0 {
+		xff := float32(*xFilesFactor)
+		opts.XFilesFactor = &xff
+	}
+
+	err = whisper.Resize(path, archives, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*noBackup {
+		fmt.Printf("Original file kept at %s.bak\n", path)
+	}
+}
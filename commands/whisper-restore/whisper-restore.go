@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+var input = flag.String("input", "", "read the stream from this file instead of standard input")
+var aggregate = flag.Bool("aggregate", false, "aggregate high precision data when migrating it to the given archives, instead of keeping the last value")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... FILE [PRECISION:RETENTION]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Recreates FILE from a stream written by whisper-dump -stream, migrating its data to the given archives if there are any.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		log.Fatal("error: you must specify a filename")
+	}
+
+	args := flag.Args()
+	path := args[0]
+
+	// Archives may also be given as a single space or comma separated argument, eg: "10s:6h 1m:7d"
+	var archives []whisper.ArchiveInfo
+	for _, arg := range args[1:] {
+		for _, s := range strings.FieldsFunc(arg, func(r rune) bool { return r == ' ' || r == ',' }) {
+			archive, err := whisper.ParseArchiveInfo(s)
+			if err != nil {
+				log.Fatalf("error: %s", err)
+			}
+			archives = append(archives, archive)
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	opts := whisper.LoadOptions{Archives: archives, Resize: whisper.ResizeOptions{Aggregate: *aggregate}}
+	err := whisper.LoadFrom(path, r, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"log"
+	"os"
+	"strings"
+)
+
+var methodList string
+
+func main() {
+	flag.StringVar(&methodList, "methods", "min,max,average", "comma separated aggregation methods to keep rollups for")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... PRECISION:RETENTION FILE...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		log.Fatal("error: you must specify the rollup archive and at least one file")
+	}
+
+	archive, err := whisper.ParseArchiveInfo(flag.Arg(0))
+	if err != nil {
+		log.Fatal(fmt.Sprintf("error: %s", err))
+	}
+
+	var methods []whisper.AggregationMethod
+	for _, name := range strings.Split(methodList, ",") {
+		method, err := whisper.ParseAggregationMethod(strings.TrimSpace(name))
+		if err != nil {
+			log.Fatal(fmt.Sprintf("error: %s", err))
+		}
+		methods = append(methods, method)
+	}
+
+	failed := false
+	for _, path := range flag.Args()[1:] {
+		err := whisper.UpdateRollups(path, archive, methods)
+		if err != nil {
+			log.Print(err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s XFILESFACTOR [FILE]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "FILE may be a glob pattern. Files are read from standard input, one per line, if none are given.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		log.Fatal("error: you must specify an xFilesFactor")
+	}
+
+	xFilesFactor, err := strconv.ParseFloat(flag.Arg(0), 32)
+	if err != nil || xFilesFactor < 0 || xFilesFactor > 1 {
+		log.Fatalf("error: invalid xFilesFactor \"%s\", must be between 0 and 1", flag.Arg(0))
+	}
+
+	failed := false
+	for _, path := range paths(flag.Args()[1:]) {
+		err := set(path, float32(xFilesFactor))
+		if err != nil {
+			log.Printf("%s: %s", path, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func set(path string, xFilesFactor float32) error {
+	w, err := whisper.Open(path)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return w.SetXFilesFactor(xFilesFactor)
+}
+
+// Expand the glob patterns given as arguments, or read paths from standard input if there are none
+func paths(args []string) (paths []string) {
+	if len(args) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				paths = append(paths, scanner.Text())
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			log.Fatalf("error: %s: %s", arg, err)
+		}
+		if matches == nil {
+			// Not a pattern, or nothing matched; report the missing file when it is opened
+			matches = []string{arg}
+		}
+		paths = append(paths, matches...)
+	}
+	return
+}
@@ -2,23 +2,32 @@ package main
 
 import (
 	"github.com/kisielk/whisper-go/whisper"
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"time"
-	"strings"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
-func usage() {
-	log.Fatal("Wrong number of arguments")
-}
+var importJSON = flag.Bool("json", false, "read a series in the JSON of graphite-web's render API from standard input, as printed by whisper-fetch -render")
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s FILE [TIMESTAMP:VALUE]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Use N as the timestamp for the current time. Points are read from standard input, one per line, if none are given.\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	if flag.NArg() < 2 {
-		usage()
+	log.SetFlags(0)
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		log.Fatal("error: you must specify a filename")
 	}
 
 	now := uint32(time.Now().Unix())
@@ -30,44 +39,73 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer w.Close()
 
-	// Parse all the points
-	var points = make([]whisper.Point, len(args) - 1)
-	for i, p := range args[1:] {
-		splitP := strings.Split(p, ":")
-
-		if len(splitP) != 2 {
-			log.Fatalf("invalid point %s: %s", p, err)
+	if *importJSON {
+		report, err := w.ImportJSON(os.Stdin)
+		if err != nil {
+			log.Fatalf("failed to update database: %s", err)
 		}
+		logDropped(report)
+		return
+	}
 
-
-		// Parse the timestamp
-		var timestamp uint32
-		timestampString := splitP[0]
-		if timestampString == "N" {
-			timestamp = now
-		} else {
-			timestamp64, err := strconv.ParseUint(timestampString, 10, 32)
-			if err != nil {
-				log.Fatalf("invalid timestamp %s: %s", timestampString, err)
+	pointStrings := args[1:]
+	if len(pointStrings) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				pointStrings = append(pointStrings, line)
 			}
-			timestamp = uint32(timestamp64)
 		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-		// Parse the value
-		value, err := strconv.ParseFloat(splitP[1], 64)
+	// Parse all the points
+	var points = make([]whisper.Point, len(pointStrings))
+	for i, p := range pointStrings {
+		points[i], err = parsePoint(p, now)
 		if err != nil {
-			log.Fatalf("invalid value: %s", splitP[1])
+			log.Fatalf("invalid point %s: %s", p, err)
 		}
+	}
 
-		points[i] = whisper.Point{timestamp, value}
+	report, err := w.UpdateManyWithReport(points, whisper.UpdateOptions{})
+	if err != nil {
+		log.Fatalf("failed to update database: %s", err)
 	}
+	logDropped(report)
+}
 
-	fmt.Printf("Updating with points: %v\n", points)
+// Log the points an update dropped
+func logDropped(report whisper.UpdateReport) {
+	for _, point := range report.Dropped {
+		log.Printf("dropped point %d:%v, outside the retention of the database", point.Timestamp, point.Value)
+	}
+}
 
-	err = w.UpdateMany(points)
-	if err != nil {
-		log.Fatal("failed to update database: %s", err)
+// Parse a point given as TIMESTAMP:VALUE, where a timestamp of N means now
+func parsePoint(s string, now uint32) (point whisper.Point, err error) {
+	splitP := strings.Split(s, ":")
+	if len(splitP) != 2 {
+		return point, errors.New("expected TIMESTAMP:VALUE")
+	}
+
+	// Parse the timestamp
+	timestampString := splitP[0]
+	if timestampString == "N" {
+		point.Timestamp = now
+	} else {
+		timestamp64, err := strconv.ParseUint(timestampString, 10, 32)
+		if err != nil {
+			return point, err
+		}
+		point.Timestamp = uint32(timestamp64)
 	}
 
+	// Parse the value
+	point.Value, err = strconv.ParseFloat(splitP[1], 64)
+	return
 }
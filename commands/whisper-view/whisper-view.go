@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var addr = flag.String("addr", "localhost:8080", "address to serve the page on")
+var width = flag.Int("width", 900, "width of the chart in pixels, and the most points plotted")
+
+const chartHeight = 300
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+svg { border: 1px solid #ccc; }
+table { border-collapse: collapse; margin-top: 1em; }
+td, th { padding: 0.2em 1em; text-align: left; }
+nav a { margin-right: 1em; }
+</style>
+</head>
+<body>
+<h1>{{.Path}}</h1>
+<p>{{.From}} to {{.Until}}, one point every {{.Step}} seconds, aggregated using {{.Method}}.
+{{if .Empty}}There are no points in this range.{{else}}Values range from {{.Min}} to {{.Max}}.{{end}}</p>
+<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+{{range .Lines}}<polyline fill="none" stroke="#1f77b4" stroke-width="1.5" points="{{.}}"/>
+{{end}}</svg>
+<nav>
+<a href="{{.Earlier}}">&larr; earlier</a>
+<a href="{{.ZoomIn}}">zoom in</a>
+<a href="{{.ZoomOut}}">zoom out</a>
+<a href="{{.Later}}">later &rarr;</a>
+</nav>
+<table>
+<tr><th>Archive</th><th>Seconds per point</th><th>Points</th><th>Retention</th></tr>
+{{range .Archives}}<tr><td><a href="{{.Link}}">{{.Index}}</a></td><td>{{.SecondsPerPoint}}</td><td>{{.Points}}</td><td>{{.Retention}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type archiveRow struct {
+	Index           int
+	SecondsPerPoint uint32
+	Points          uint32
+	Retention       time.Duration
+	Link            string
+}
+
+type view struct {
+	Path                            string
+	From, Until                     time.Time
+	Step                            uint32
+	Method                          string
+	Empty                           bool
+	Min, Max                        float64
+	Width, Height                   int
+	Lines                           []string
+	Earlier, Later, ZoomIn, ZoomOut string
+	Archives                        []archiveRow
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [OPTION]... FILE\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		log.Fatal("error: you must specify a filename")
+	}
+	path := flag.Arg(0)
+
+	w, err := whisper.OpenReadOnly(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+
+	http.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		// Pick up a file that was resized since the last request
+		if _, err := w.Refresh(); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		v, err := render(w, path, r)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := page.Execute(rw, v); err != nil {
+			log.Print(err)
+		}
+	})
+
+	log.Printf("serving %s on http://%s/", path, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// Build the view of the range requested by the from and until query parameters, which default
+// to the retention of the highest precision archive
+func render(w *whisper.Whisper, path string, r *http.Request) (v view, err error) {
+	now := uint32(time.Now().Unix())
+	until, err := queryTimestamp(r, "until", now)
+	if err != nil {
+		return
+	}
+	from, err := queryTimestamp(r, "from", until-w.Header.Archives[0].Retention())
+	if err != nil {
+		return
+	}
+	if from >= until {
+		return v, errors.New(fmt.Sprintf("from %d isn't before until %d", from, until))
+	}
+
+	method := w.Header.Metadata.AggregationMethod
+	series, err := w.FetchConsolidated(from, until, *width, method)
+	if err != nil {
+		return
+	}
+
+	v = view{
+		Path:   path,
+		From:   time.Unix(int64(series.From), 0).UTC(),
+		Until:  time.Unix(int64(series.Until), 0).UTC(),
+		Step:   series.Step,
+		Method: method.String(),
+		Width:  *width,
+		Height: chartHeight,
+	}
+	v.Lines, v.Min, v.Max, v.Empty = plot(series, *width, chartHeight)
+
+	span := until - from
+	back := span / 2
+	if back > from {
+		back = from
+	}
+	v.Earlier = link(from-back, until-back)
+	v.Later = link(from+span/2, until+span/2)
+	v.ZoomIn = link(from+span/4, until-span/4)
+	v.ZoomOut = link(from-back, until+span/2)
+	for i, archive := range w.Header.Archives {
+		v.Archives = append(v.Archives, archiveRow{
+			Index:           i,
+			SecondsPerPoint: archive.SecondsPerPoint,
+			Points:          archive.Points,
+			Retention:       time.Duration(archive.Retention()) * time.Second,
+			Link:            link(now-archive.Retention(), now),
+		})
+	}
+	return
+}
+
+// Scale the values of a series to polylines fitting a chart, breaking the line at missing values
+func plot(series whisper.Series, width, height int) (lines []string, min, max float64, empty bool) {
+	min, max = math.Inf(1), math.Inf(-1)
+	for _, value := range series.Values {
+		if value != nil && !math.IsNaN(*value) && !math.IsInf(*value, 0) {
+			min = math.Min(min, *value)
+			max = math.Max(max, *value)
+		}
+	}
+	if min > max {
+		return nil, 0, 0, true
+	}
+	low, high := min, max
+	if low == high {
+		// Draw a flat series across the middle of the chart
+		low, high = low-1, high+1
+	}
+
+	var line []string
+	for i, value := range series.Values {
+		if value == nil || math.IsNaN(*value) || math.IsInf(*value, 0) {
+			if len(line) > 0 {
+				lines = append(lines, strings.Join(line, " "))
+				line = nil
+			}
+			continue
+		}
+		// Each value is drawn across its whole interval, so a lone value is still visible
+		dx := float64(width) / float64(len(series.Values))
+		x := float64(i) * dx
+		y := float64(height) - (*value-low)/(high-low)*float64(height)
+		line = append(line, fmt.Sprintf("%.1f,%.1f %.1f,%.1f", x, y, x+dx, y))
+	}
+	if len(line) > 0 {
+		lines = append(lines, strings.Join(line, " "))
+	}
+	return
+}
+
+// Returns a unix timestamp query parameter, or def if it isn't set
+func queryTimestamp(r *http.Request, name string, def uint32) (uint32, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	timestamp, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid %s timestamp %q", name, s))
+	}
+	return uint32(timestamp), nil
+}
+
+// Returns the link to the page showing a range
+func link(from, until uint32) string {
+	return fmt.Sprintf("/?from=%d&until=%d", from, until)
+}
@@ -0,0 +1,97 @@
+package manifest
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Changes describes how a tree changed between two of its manifests
+type Changes struct {
+	Added     []string          // Databases only in the newer manifest
+	Deleted   []string          // Databases only in the older manifest
+	Retention []RetentionChange // Databases whose archives changed
+	Growth    []PrefixGrowth    // Prefixes whose databases were added, deleted or changed size, sorted by prefix
+}
+
+// RetentionChange describes a database that was resized between two manifests
+type RetentionChange struct {
+	Path     string
+	Old, New string // Retention of the database in each manifest, see Entry.Retention
+}
+
+// PrefixGrowth describes how the databases under a prefix of the tree grew between two manifests
+type PrefixGrowth struct {
+	Prefix                 string // Directory of the databases relative to the root of the tree
+	OldMetrics, NewMetrics int    // Number of databases
+	OldBytes, NewBytes     int64  // Total size of the databases
+}
+
+/*
+Diff compares two manifests of the same tree taken at different times, for tracking how a tree
+changes over time. Growth is summed per prefix, the first depth directories of each database's
+path, so a depth of 1 reports each top level directory. Databases closer to the root than depth are
+counted under their own directory.
+
+Retention changes are only reported for entries of manifests that recorded their retention.
+*/
+func Diff(old, new Manifest, depth int) (changes Changes) {
+	oldEntries := make(map[string]Entry)
+	for _, e := range old {
+		oldEntries[e.Path] = e
+	}
+	growth := make(map[string]*PrefixGrowth)
+	prefixGrowth := func(path string) *PrefixGrowth {
+		p := prefix(path, depth)
+		if growth[p] == nil {
+			growth[p] = &PrefixGrowth{Prefix: p}
+		}
+		return growth[p]
+	}
+
+	for _, e := range old {
+		g := prefixGrowth(e.Path)
+		g.OldMetrics++
+		g.OldBytes += e.Size
+	}
+	for _, e := range new {
+		g := prefixGrowth(e.Path)
+		g.NewMetrics++
+		g.NewBytes += e.Size
+
+		o, ok := oldEntries[e.Path]
+		delete(oldEntries, e.Path)
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, e.Path)
+		case o.Retention != "" && e.Retention != "" && o.Retention != e.Retention:
+			changes.Retention = append(changes.Retention, RetentionChange{e.Path, o.Retention, e.Retention})
+		}
+	}
+	for path := range oldEntries {
+		changes.Deleted = append(changes.Deleted, path)
+	}
+
+	for _, g := range growth {
+		if g.OldMetrics != g.NewMetrics || g.OldBytes != g.NewBytes {
+			changes.Growth = append(changes.Growth, *g)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Deleted)
+	sort.Slice(changes.Retention, func(i, j int) bool { return changes.Retention[i].Path < changes.Retention[j].Path })
+	sort.Slice(changes.Growth, func(i, j int) bool { return changes.Growth[i].Prefix < changes.Growth[j].Prefix })
+	return
+}
+
+// Returns the first depth directories of a path relative to the root of the tree
+func prefix(path string, depth int) string {
+	dirs := strings.Split(filepath.Dir(path), string(filepath.Separator))
+	if dirs[0] == "." {
+		return "."
+	}
+	if len(dirs) > depth {
+		dirs = dirs[:depth]
+	}
+	return filepath.Join(dirs...)
+}
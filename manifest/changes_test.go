@@ -0,0 +1,44 @@
+package manifest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := Manifest{
+		{Path: "a.wsp", Size: 100, Retention: "60:10"},
+		{Path: "servers/web1/cpu.wsp", Size: 100, Retention: "60:10"},
+		{Path: "servers/web1/mem.wsp", Size: 100, Retention: "60:10"},
+		{Path: "servers/web2/cpu.wsp", Size: 100},
+	}
+	new := Manifest{
+		{Path: "a.wsp", Size: 100, Retention: "60:10"},
+		{Path: "apps/api/latency.wsp", Size: 50, Retention: "10:5"},
+		{Path: "servers/web1/cpu.wsp", Size: 200, Retention: "60:20"},
+		{Path: "servers/web2/cpu.wsp", Size: 100, Retention: "60:10"},
+	}
+
+	changes := Diff(old, new, 1)
+	expected := Changes{
+		Added:     []string{"apps/api/latency.wsp"},
+		Deleted:   []string{"servers/web1/mem.wsp"},
+		Retention: []RetentionChange{{"servers/web1/cpu.wsp", "60:10", "60:20"}},
+		Growth: []PrefixGrowth{
+			{"apps", 0, 1, 0, 50},
+			{"servers", 3, 2, 300, 300},
+		},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Diff = %+v, want %+v", changes, expected)
+	}
+
+	changes = Diff(old, new, 2)
+	growth := []PrefixGrowth{
+		{"apps/api", 0, 1, 0, 50},
+		{"servers/web1", 2, 1, 200, 200},
+	}
+	if !reflect.DeepEqual(changes.Growth, growth) {
+		t.Errorf("Growth at depth 2 = %+v, want %+v", changes.Growth, growth)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Package manifest generates and verifies checksum manifests of trees of whisper databases, for
+checking that backups and copies of a tree are complete and intact.
+*/
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"github.com/kisielk/whisper-go/whisperwalk"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Entry describes a single database in a manifest
+type Entry struct {
+	Path       string        // Path of the database relative to the root of the tree
+	Size       int64         // Size of the file in bytes
+	HeaderHash string        // SHA-256 of the header, in hex
+	DataHash   string        // SHA-256 of the archives following the header, in hex
+	LastPoint  whisper.Point // The newest point of the highest precision archive
+	Retention  string        // Seconds per point and points of each archive, eg: 60:1440,3600:168
+}
+
+// Manifest is a list of entries sorted by path
+type Manifest []Entry
+
+// Mismatch describes a database that doesn't match its manifest entry
+type Mismatch struct {
+	Path   string // Path of the database relative to the root of the tree
+	Reason string // What doesn't match
+}
+
+// Generate a manifest of every .wsp file under root, reading up to workers files concurrently
+func Generate(root string, workers int) (manifest Manifest, err error) {
+	var mutex sync.Mutex
+	err = whisperwalk.Walk(root, workers, func(file whisperwalk.File) error {
+		entry, err := newEntry(file)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		manifest = append(manifest, entry)
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(manifest, func(i, j int) bool { return manifest[i].Path < manifest[j].Path })
+	return
+}
+
+// Build the manifest entry of a single database
+func newEntry(file whisperwalk.File) (entry Entry, err error) {
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		return
+	}
+	header, err := whisper.ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		return entry, errors.New(fmt.Sprintf("%s: %s", file.Path, err))
+	}
+
+	entry.Path = file.Rel
+	entry.Size = int64(len(data))
+
+	headerSize := binary.Size(header.Metadata) + binary.Size(header.Archives)
+	headerHash := sha256.Sum256(data[:headerSize])
+	dataHash := sha256.Sum256(data[headerSize:])
+	entry.HeaderHash = hex.EncodeToString(headerHash[:])
+	entry.DataHash = hex.EncodeToString(dataHash[:])
+	entry.Retention = retention(header)
+
+	if len(header.Archives) > 0 {
+		archive := header.Archives[0]
+		points := make([]whisper.Point, archive.Points)
+		end := int(archive.Offset) + binary.Size(points)
+		if end > len(data) {
+			return entry, errors.New(fmt.Sprintf("%s: file is truncated", file.Path))
+		}
+		_, err = binary.Decode(data[archive.Offset:end], binary.BigEndian, points)
+		if err != nil {
+			return
+		}
+		for _, point := range points {
+			if point.Timestamp > entry.LastPoint.Timestamp {
+				entry.LastPoint = point
+			}
+		}
+	}
+	return
+}
+
+// Describe the archives of a header as seconds per point and points pairs
+func retention(header whisper.Header) string {
+	archives := make([]string, len(header.Archives))
+	for i, archive := range header.Archives {
+		archives[i] = fmt.Sprintf("%d:%d", archive.SecondsPerPoint, archive.Points)
+	}
+	return strings.Join(archives, ",")
+}
+
+// Write the manifest as one tab separated line per entry
+func (m Manifest) Write(w io.Writer) error {
+	buf := bufio.NewWriter(w)
+	for _, e := range m {
+		_, err := fmt.Fprintf(buf, "%s\t%d\t%s\t%s\t%d\t%s\t%s\n", e.Path, e.Size, e.HeaderHash, e.DataHash,
+			e.LastPoint.Timestamp, strconv.FormatFloat(e.LastPoint.Value, 'g', -1, 64), e.Retention)
+		if err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// Read a manifest written by Manifest.Write. Manifests written before entries recorded their
+// retention are accepted, leaving Retention empty.
+func Read(r io.Reader) (manifest Manifest, err error) {
+	scanner := bufio.NewScanner(r)
+	var line int
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 6 && len(fields) != 7 {
+			return nil, errors.New(fmt.Sprintf("line %d: expected 7 fields, got %d", line, len(fields)))
+		}
+
+		e := Entry{Path: fields[0], HeaderHash: fields[2], DataHash: fields[3]}
+		e.Size, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: invalid size: %s", line, err))
+		}
+		timestamp, err := strconv.ParseUint(fields[4], 10, 32)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: invalid timestamp: %s", line, err))
+		}
+		e.LastPoint.Timestamp = uint32(timestamp)
+		e.LastPoint.Value, err = strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: invalid value: %s", line, err))
+		}
+		if len(fields) == 7 {
+			e.Retention = fields[6]
+		}
+		manifest = append(manifest, e)
+	}
+	err = scanner.Err()
+	return
+}
+
+// Verify the tree under root against a manifest, returning every database that is missing,
+// unexpected or differs from its entry
+func Verify(root string, m Manifest, workers int) (mismatches []Mismatch, err error) {
+	current, err := Generate(root, workers)
+	if err != nil {
+		return
+	}
+	return Compare(m, current), nil
+}
+
+// Compare two manifests, returning every entry of want that is missing from or differs in got,
+// and every entry of got that isn't in want
+func Compare(want, got Manifest) (mismatches []Mismatch) {
+	entries := make(map[string]Entry)
+	for _, e := range got {
+		entries[e.Path] = e
+	}
+
+	for _, w := range want {
+		g, ok := entries[w.Path]
+		delete(entries, w.Path)
+		switch {
+		case !ok:
+			mismatches = append(mismatches, Mismatch{w.Path, "missing"})
+		case g.Size != w.Size:
+			mismatches = append(mismatches, Mismatch{w.Path, fmt.Sprintf("size is %d, want %d", g.Size, w.Size)})
+		case g.HeaderHash != w.HeaderHash:
+			mismatches = append(mismatches, Mismatch{w.Path, "header differs"})
+		case g.DataHash != w.DataHash:
+			mismatches = append(mismatches, Mismatch{w.Path, fmt.Sprintf("data differs, last point is %v, want %v", g.LastPoint, w.LastPoint)})
+		}
+	}
+	for path := range entries {
+		mismatches = append(mismatches, Mismatch{path, "not in manifest"})
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Path < mismatches[j].Path })
+	return
+}
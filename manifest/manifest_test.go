@@ -0,0 +1,120 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/kisielk/whisper-go/whisper"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Create a database at root/name holding a single point in its first archive
+func create(t *testing.T, root, name string, point whisper.Point) {
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := whisper.Create(path, []whisper.ArchiveInfo{{SecondsPerPoint: 60, Points: 60}}, 0.5, whisper.AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	writePoint(t, path, point)
+}
+
+// Overwrite the first slot of the first archive of the database at path
+func writePoint(t *testing.T, path string, point whisper.Point) {
+	w, err := whisper.OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := w.Header.Archives[0].Offset
+	w.Close()
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, _ := binary.Append(nil, binary.BigEndian, point)
+	if _, err := file.WriteAt(data, int64(offset)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateAndVerify(t *testing.T) {
+	root := t.TempDir()
+	create(t, root, "a.wsp", whisper.Point{Timestamp: 1200, Value: 1.5})
+	create(t, root, "servers/b.wsp", whisper.Point{Timestamp: 600, Value: 2})
+	create(t, root, "servers/c.wsp", whisper.Point{Timestamp: 60, Value: 3})
+
+	m, err := Generate(root, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 3 || m[0].Path != "a.wsp" || m[1].Path != filepath.Join("servers", "b.wsp") {
+		t.Fatalf("unexpected manifest: %v", m)
+	}
+	if m[0].LastPoint != (whisper.Point{Timestamp: 1200, Value: 1.5}) {
+		t.Errorf("LastPoint = %v", m[0].LastPoint)
+	}
+	if m[0].HeaderHash != m[1].HeaderHash || m[0].DataHash == m[1].DataHash {
+		t.Errorf("expected equal headers and different data")
+	}
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, m) {
+		t.Errorf("Read = %v, want %v", read, m)
+	}
+
+	mismatches, err := Verify(root, m, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("unexpected mismatches for an unchanged tree: %v", mismatches)
+	}
+
+	writePoint(t, filepath.Join(root, "a.wsp"), whisper.Point{Timestamp: 1260, Value: 4})
+	if err := os.Remove(filepath.Join(root, "servers", "b.wsp")); err != nil {
+		t.Fatal(err)
+	}
+	create(t, root, "d.wsp", whisper.Point{})
+
+	mismatches, err = Verify(root, m, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.wsp", "d.wsp", filepath.Join("servers", "b.wsp")}
+	if len(mismatches) != len(want) {
+		t.Fatalf("mismatches = %v", mismatches)
+	}
+	for i, path := range want {
+		if mismatches[i].Path != path {
+			t.Errorf("mismatch %d is %v, want %s", i, mismatches[i], path)
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	if _, err := Read(bytes.NewBufferString("a.wsp\t12\n")); err == nil {
+		t.Error("expected an error for a short line")
+	}
+}
+
+func TestReadWithoutRetention(t *testing.T) {
+	m, err := Read(bytes.NewBufferString("a.wsp\t12\tabc\tdef\t60\t1.5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 1 || m[0].Retention != "" || m[0].LastPoint.Value != 1.5 {
+		t.Errorf("Read = %+v", m)
+	}
+}
@@ -0,0 +1,167 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Wire types of protocol buffers
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// A sample of a time series, as sent by remote_write
+type sample struct {
+	value     float64
+	timestamp int64 // Milliseconds since the epoch
+}
+
+// A time series of a remote_write request
+type timeSeries struct {
+	labels  map[string]string
+	samples []sample
+}
+
+// Reads the fields of a protocol buffer message
+type protoReader struct {
+	buf []byte
+}
+
+var errTruncated = errors.New("truncated protobuf message")
+
+func (r *protoReader) varint() (value uint64, err error) {
+	value, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.buf = r.buf[n:]
+	return
+}
+
+// Read the header of the next field, returning its number and wire type
+func (r *protoReader) field() (number int, wireType int, err error) {
+	key, err := r.varint()
+	if err != nil {
+		return
+	}
+	return int(key >> 3), int(key & 7), nil
+}
+
+// Read the value of a field of a wire type. Varints and fixed width values are returned in value,
+// length delimited values in data.
+func (r *protoReader) value(wireType int) (value uint64, data []byte, err error) {
+	switch wireType {
+	case wireVarint:
+		value, err = r.varint()
+	case wireFixed64:
+		if len(r.buf) < 8 {
+			return 0, nil, errTruncated
+		}
+		value = binary.LittleEndian.Uint64(r.buf)
+		r.buf = r.buf[8:]
+	case wireFixed32:
+		if len(r.buf) < 4 {
+			return 0, nil, errTruncated
+		}
+		value = uint64(binary.LittleEndian.Uint32(r.buf))
+		r.buf = r.buf[4:]
+	case wireBytes:
+		var size uint64
+		size, err = r.varint()
+		if err != nil {
+			return
+		}
+		if size > uint64(len(r.buf)) {
+			return 0, nil, errTruncated
+		}
+		data = r.buf[:size]
+		r.buf = r.buf[size:]
+	default:
+		err = errors.New(fmt.Sprintf("unsupported protobuf wire type %d", wireType))
+	}
+	return
+}
+
+// Call fn with every field of a message. Fields are passed to fn after their value is read.
+func readMessage(data []byte, fn func(number, wireType int, value uint64, data []byte) error) error {
+	r := &protoReader{data}
+	for len(r.buf) > 0 {
+		number, wireType, err := r.field()
+		if err != nil {
+			return err
+		}
+		value, data, err := r.value(wireType)
+		if err != nil {
+			return err
+		}
+		err = fn(number, wireType, value, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Parse the time series of a prometheus.WriteRequest:
+
+	message WriteRequest { repeated TimeSeries timeseries = 1; ... }
+	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; ... }
+	message Label { string name = 1; string value = 2; }
+	message Sample { double value = 1; int64 timestamp = 2; }
+
+Metadata, exemplars and histograms are skipped.
+*/
+func parseWriteRequest(data []byte) (series []timeSeries, err error) {
+	err = readMessage(data, func(number, wireType int, _ uint64, data []byte) error {
+		if number != 1 || wireType != wireBytes {
+			return nil
+		}
+		s, err := parseTimeSeries(data)
+		series = append(series, s)
+		return err
+	})
+	return
+}
+
+func parseTimeSeries(data []byte) (series timeSeries, err error) {
+	series.labels = make(map[string]string)
+	err = readMessage(data, func(number, wireType int, _ uint64, data []byte) error {
+		if wireType != wireBytes {
+			return nil
+		}
+		switch number {
+		case 1:
+			var name, value string
+			err := readMessage(data, func(number, wireType int, _ uint64, data []byte) error {
+				if wireType == wireBytes && number == 1 {
+					name = string(data)
+				} else if wireType == wireBytes && number == 2 {
+					value = string(data)
+				}
+				return nil
+			})
+			series.labels[name] = value
+			return err
+		case 2:
+			var s sample
+			err := readMessage(data, func(number, wireType int, value uint64, _ []byte) error {
+				if wireType == wireFixed64 && number == 1 {
+					s.value = math.Float64frombits(value)
+				} else if wireType == wireVarint && number == 2 {
+					s.timestamp = int64(value)
+				}
+				return nil
+			})
+			series.samples = append(series.samples, s)
+			return err
+		}
+		return nil
+	})
+	return
+}
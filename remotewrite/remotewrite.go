@@ -0,0 +1,180 @@
+/*
+Package remotewrite receives samples sent by Prometheus' remote_write and stores them in a tree of
+whisper databases, so whisper can remain the long-term storage of metrics collected by Prometheus.
+
+Each time series is named after its labels by the first template that applies to it, see Template.
+Databases that don't exist yet are created with the archives of the first matching schema of a
+storage-schemas.conf, like carbon does.
+*/
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kisielk/whisper-go/schemas"
+	"github.com/kisielk/whisper-go/whisper"
+	"github.com/kisielk/whisper-go/whisperwalk"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Largest request body read
+const maxRequestSize = 32 << 20
+
+// The NaN Prometheus sends to mark a series as stale, which isn't a sample
+const staleNaN = 0x7ff0000000000002
+
+// Options of a Handler
+type Options struct {
+	Templates         []*Template               // Tried in order to name each series. Series none apply to are named by their name and every other label.
+	Layout            whisperwalk.Layout        // Layout of the tree, the FlatLayout if nil
+	Overrides         schemas.Overrides         // Overrides of the settings new databases are created with
+	XFilesFactor      float32                   // xFilesFactor new databases are created with
+	AggregationMethod whisper.AggregationMethod // Aggregation method new databases are created with, AGGREGATION_AVERAGE if unknown
+}
+
+// A Handler is an http.Handler accepting remote_write requests, writing their samples to the
+// databases of a tree
+type Handler struct {
+	root    string
+	schemas schemas.Schemas
+	pool    *whisper.Pool
+	opts    Options
+}
+
+// Create a handler writing to the tree under root through pool, creating new databases with the archives
+// of their schema
+func NewHandler(root string, s schemas.Schemas, pool *whisper.Pool, opts Options) *Handler {
+	if opts.Layout == nil {
+		opts.Layout = whisperwalk.FlatLayout{}
+	}
+	if opts.AggregationMethod == whisper.AGGREGATION_UNKNOWN {
+		opts.AggregationMethod = whisper.AGGREGATION_AVERAGE
+	}
+	return &Handler{root, s, pool, opts}
+}
+
+/*
+Serve a remote_write request, a snappy compressed prometheus.WriteRequest. The response is:
+
+	204 when every sample was written
+	400 when the request can't be decoded, or some series had no metric name or no matching schema; the
+	    other series are still written, as retrying wouldn't help
+	500 when writing a database failed, so Prometheus retries the request
+*/
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "remote_write requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Version 2 of remote_write sends a different message
+	if strings.Contains(req.Header.Get("Content-Type"), "io.prometheus.write.v2") {
+		http.Error(rw, "only version 1 of remote_write is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(rw, req.Body, maxRequestSize))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := decodeSnappy(body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := parseWriteRequest(data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rejected, err := h.write(series)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(rejected) > 0 {
+		http.Error(rw, fmt.Sprintf("rejected %d series: %s", len(rejected), strings.Join(rejected, ", ")), http.StatusBadRequest)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Write the samples of the series to their databases. Returns the series that couldn't be stored
+// because they couldn't be named or had no schema, and the first error writing a database.
+func (h *Handler) write(series []timeSeries) (rejected []string, err error) {
+	points := make(map[string][]whisper.Point)
+	for _, s := range series {
+		metric, ok := h.metric(s.labels)
+		if !ok {
+			rejected = append(rejected, fmt.Sprintf("%v: no metric name", s.labels))
+			continue
+		}
+		for _, sample := range s.samples {
+			seconds := sample.timestamp / 1000
+			if math.Float64bits(sample.value) == staleNaN || seconds < 0 || seconds > math.MaxUint32 {
+				continue
+			}
+			points[metric] = append(points[metric], whisper.Point{Timestamp: uint32(seconds), Value: sample.value})
+		}
+	}
+
+	metrics := make([]string, 0, len(points))
+	for metric := range points {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		stored, e := h.update(metric, points[metric])
+		if !stored {
+			rejected = append(rejected, metric+": no matching schema")
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Returns the metric a series is named
+func (h *Handler) metric(labels map[string]string) (string, bool) {
+	for _, t := range h.opts.Templates {
+		if metric, ok := t.Metric(labels); ok {
+			return metric, true
+		}
+	}
+	return defaultMetric(labels)
+}
+
+// Write points to the database of a metric, creating it if it doesn't exist. Returns false if the
+// database doesn't exist and no schema matches the metric.
+func (h *Handler) update(metric string, points []whisper.Point) (stored bool, err error) {
+	path := filepath.Join(h.root, h.opts.Layout.Path(metric))
+	err = h.pool.UpdateMany(path, points)
+	if !os.IsNotExist(err) {
+		return true, err
+	}
+
+	archives := h.schemas.Match(metric)
+	if archives == nil {
+		return false, nil
+	}
+	xFilesFactor, aggregationMethod := h.opts.Overrides.Apply(metric, h.opts.XFilesFactor, h.opts.AggregationMethod)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return true, err
+	}
+	w, _, err := whisper.CreateIfMissing(path, archives, xFilesFactor, aggregationMethod)
+	if err != nil {
+		return true, errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+	w.Close()
+	return true, h.pool.UpdateMany(path, points)
+}
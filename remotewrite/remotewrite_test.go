@@ -0,0 +1,148 @@
+package remotewrite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/kisielk/whisper-go/schemas"
+	"github.com/kisielk/whisper-go/whisper"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Helpers encoding protocol buffer fields
+func protoBytes(buf []byte, number int, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(number<<3|wireBytes))
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func encodeWriteRequest(series []timeSeries) (request []byte) {
+	for _, s := range series {
+		var message []byte
+		for name, value := range s.labels {
+			label := protoBytes(protoBytes(nil, 1, []byte(name)), 2, []byte(value))
+			message = protoBytes(message, 1, label)
+		}
+		for _, sample := range s.samples {
+			encoded := binary.AppendUvarint(nil, 1<<3|wireFixed64)
+			encoded = binary.LittleEndian.AppendUint64(encoded, math.Float64bits(sample.value))
+			encoded = binary.AppendUvarint(encoded, 2<<3|wireVarint)
+			encoded = binary.AppendUvarint(encoded, uint64(sample.timestamp))
+			message = protoBytes(message, 2, encoded)
+		}
+		// An exemplar, which is skipped
+		message = protoBytes(message, 3, []byte{})
+		request = protoBytes(request, 1, message)
+	}
+	return
+}
+
+func TestParseWriteRequest(t *testing.T) {
+	series := []timeSeries{
+		{map[string]string{"__name__": "up", "job": "api"}, []sample{{1, 1000}, {-2.5, 2000}}},
+		{map[string]string{"__name__": "down"}, nil},
+	}
+	parsed, err := parseWriteRequest(encodeWriteRequest(series))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 2 || len(parsed[0].labels) != 2 || parsed[0].labels["job"] != "api" || parsed[1].labels["__name__"] != "down" {
+		t.Fatalf("parsed %v", parsed)
+	}
+	if len(parsed[0].samples) != 2 || parsed[0].samples[1] != (sample{-2.5, 2000}) || len(parsed[1].samples) != 0 {
+		t.Errorf("parsed samples %v", parsed[0].samples)
+	}
+
+	truncated := encodeWriteRequest(series)
+	if _, err := parseWriteRequest(truncated[:len(truncated)-3]); err == nil {
+		t.Error("parsed a truncated request")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	root := t.TempDir()
+	s, err := schemas.Parse(strings.NewReader("[api]\npattern = ^prometheus\\.api\\.\nretentions = 60s:1h\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := ParseTemplate("prometheus.{job}.{instance}.{__name__}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := whisper.NewPool(10)
+	defer pool.Close()
+	server := httptest.NewServer(NewHandler(root, s, pool, Options{Templates: []*Template{template}, AggregationMethod: whisper.AGGREGATION_MAX}))
+	defer server.Close()
+
+	post := func(series []timeSeries) *http.Response {
+		response, err := http.Post(server.URL, "application/x-protobuf", bytes.NewReader(encodeSnappy(encodeWriteRequest(series))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response
+	}
+
+	now := time.Now().Unix()
+	now -= now % 60
+	labels := map[string]string{"__name__": "up", "job": "api", "instance": "web1:9090"}
+	response := post([]timeSeries{{labels, []sample{
+		{1, (now - 120) * 1000},
+		{2, (now-60)*1000 + 500},
+		{math.Float64frombits(staleNaN), now * 1000},
+	}}})
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("status %d, want 204", response.StatusCode)
+	}
+
+	path := filepath.Join(root, "prometheus", "api", "web1:9090", "up.wsp")
+	w, err := whisper.OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Header.Metadata.AggregationMethod != whisper.AGGREGATION_MAX || len(w.Header.Archives) != 1 || w.Header.Archives[0].Points != 60 {
+		t.Errorf("created with %+v", w.Header)
+	}
+	series, err := w.FetchSeries(uint32(now-180), uint32(now))
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []float64
+	for _, value := range series.Values {
+		if value != nil {
+			values = append(values, *value)
+		}
+	}
+	if len(values) != 2 || values[0] != 1 || values[1] != 2 {
+		t.Errorf("fetched %v, want [1 2]", values)
+	}
+
+	// Series without a schema are rejected, while the others are still written
+	response = post([]timeSeries{
+		{map[string]string{"__name__": "up", "job": "db", "instance": "db1"}, []sample{{1, now * 1000}}},
+		{map[string]string{"__name__": "up", "job": "api", "instance": "web2"}, []sample{{1, now * 1000}}},
+	})
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.StatusCode)
+	}
+	if w, err := whisper.OpenReadOnly(filepath.Join(root, "prometheus", "api", "web2", "up.wsp")); err != nil {
+		t.Error(err)
+	} else {
+		w.Close()
+	}
+
+	response, err = http.Post(server.URL, "application/x-protobuf", strings.NewReader("not snappy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %d for a corrupt request, want 400", response.StatusCode)
+	}
+}
@@ -0,0 +1,99 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Largest body decompressed, bounding the memory a request can make the handler allocate
+const maxDecodedSize = 64 << 20
+
+// Tags of the elements of a snappy block
+const (
+	snappyLiteral = 0
+	snappyCopy1   = 1
+	snappyCopy2   = 2
+	snappyCopy4   = 3
+)
+
+var errCorruptSnappy = errors.New("corrupt snappy block")
+
+/*
+Decode a block in snappy's block format, which remote_write compresses requests with. The block
+starts with the decoded length as a varint, followed by elements that are either literals or copies of
+earlier decoded bytes.
+*/
+func decodeSnappy(src []byte) (dst []byte, err error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errCorruptSnappy
+	}
+	if length > maxDecodedSize {
+		return nil, errors.New(fmt.Sprintf("decoded snappy block of %d bytes is larger than %d", length, maxDecodedSize))
+	}
+	src = src[n:]
+	dst = make([]byte, 0, length)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var size, offset int
+		switch tag & 3 {
+		case snappyLiteral:
+			size = int(tag >> 2)
+			src = src[1:]
+			// Long literals store their size - 1 in the following 1 to 4 bytes
+			if size >= 60 {
+				bytes := size - 59
+				if len(src) < bytes {
+					return nil, errCorruptSnappy
+				}
+				size = 0
+				for i := bytes - 1; i >= 0; i-- {
+					size = size<<8 | int(src[i])
+				}
+				src = src[bytes:]
+			}
+			size++
+			if size > len(src) || uint64(len(dst)+size) > length {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+			continue
+		case snappyCopy1:
+			if len(src) < 2 {
+				return nil, errCorruptSnappy
+			}
+			size = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case snappyCopy2:
+			if len(src) < 3 {
+				return nil, errCorruptSnappy
+			}
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case snappyCopy4:
+			if len(src) < 5 {
+				return nil, errCorruptSnappy
+			}
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+size) > length {
+			return nil, errCorruptSnappy
+		}
+		// Copies may overlap the bytes they produce, repeating the last offset bytes
+		start := len(dst) - offset
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, errCorruptSnappy
+	}
+	return
+}
@@ -0,0 +1,61 @@
+package remotewrite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Encode data as a snappy block of literals, each at most 256 bytes so long literal sizes are used
+func encodeSnappy(data []byte) []byte {
+	block := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		size := len(data)
+		if size > 256 {
+			size = 256
+		}
+		if size <= 60 {
+			block = append(block, byte(size-1)<<2|snappyLiteral)
+		} else {
+			block = append(block, 60<<2|snappyLiteral, byte(size-1))
+		}
+		block = append(block, data[:size]...)
+		data = data[size:]
+	}
+	return block
+}
+
+func TestDecodeSnappy(t *testing.T) {
+	for _, test := range []struct {
+		block    []byte
+		expected string
+	}{
+		// A literal followed by copies overlapping the bytes they produce
+		{[]byte{12, 2 << 2, 'a', 'b', 'c', 5<<2 | snappyCopy1, 3}, "abcabcabcabc"},
+		{[]byte{8, 1 << 2, 'x', 'y', 5<<2 | snappyCopy2, 2, 0}, "xyxyxyxy"},
+		{[]byte{5, 0, 'z', 3<<2 | snappyCopy4, 1, 0, 0, 0}, "zzzzz"},
+		{encodeSnappy(bytes.Repeat([]byte("remote_write "), 40)), string(bytes.Repeat([]byte("remote_write "), 40))},
+		{[]byte{0}, ""},
+	} {
+		decoded, err := decodeSnappy(test.block)
+		if err != nil {
+			t.Errorf("decoding %v: %s", test.block, err)
+		} else if string(decoded) != test.expected {
+			t.Errorf("decoded %v to %q, want %q", test.block, decoded, test.expected)
+		}
+	}
+
+	for _, block := range [][]byte{
+		{},
+		{4, 2 << 2, 'a', 'b'},                  // Truncated literal
+		{4, 0, 'a', 3<<2 | snappyCopy1, 2},     // Copy from before the start
+		{3, 0, 'a', 3<<2 | snappyCopy1, 1},     // Longer than the decoded length
+		{5, 0, 'a', 0, 'b'},                    // Shorter than the decoded length
+		{4, 0, 'a', 3<<2 | snappyCopy2, 1},     // Truncated copy
+		{0xff, 0xff, 0xff, 0xff, 0x0f, 0, 'a'}, // Too large
+	} {
+		if decoded, err := decodeSnappy(block); err == nil {
+			t.Errorf("decoded corrupt block %v to %q", block, decoded)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The label holding the name of a Prometheus metric
+const nameLabel = "__name__"
+
+// A part of a template, either literal text or the value of a label
+type templatePart struct {
+	literal string
+	label   string // Name of the label substituted, empty for literal text
+}
+
+/*
+A Template names the whisper metric of a Prometheus time series after its labels. Labels are
+referenced by name between braces, with anything else copied as is, eg: the template
+"prometheus.{job}.{instance}.{__name__}" names the series
+
+	http_requests_total{job="api", instance="web1:9090"}
+
+prometheus.api.web1:9090.http_requests_total. Characters of label values that would split the
+metric name or its path, such as dots, slashes and spaces, are replaced by underscores.
+*/
+type Template struct {
+	text  string
+	parts []templatePart
+}
+
+// Parse a template
+func ParseTemplate(text string) (t *Template, err error) {
+	t = &Template{text: text}
+	for rest := text; rest != ""; {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return nil, errors.New(fmt.Sprintf("template %q: unexpected }", text))
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] != '}' {
+			return nil, errors.New(fmt.Sprintf("template %q: unclosed {", text))
+		}
+		label := rest[open+1 : open+1+end]
+		if label == "" {
+			return nil, errors.New(fmt.Sprintf("template %q: empty label name", text))
+		}
+		t.parts = append(t.parts, templatePart{label: label})
+		rest = rest[open+1+end+1:]
+	}
+	return
+}
+
+func (t *Template) String() string {
+	return t.text
+}
+
+// Returns the metric a series with the labels is named, or false if the series is missing a label the
+// template references or the name would have an empty component
+func (t *Template) Metric(labels map[string]string) (metric string, ok bool) {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.label == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		value, ok := labels[part.label]
+		if !ok || value == "" {
+			return "", false
+		}
+		b.WriteString(sanitize(value))
+	}
+	metric = b.String()
+	for _, component := range strings.Split(metric, ".") {
+		if component == "" {
+			return "", false
+		}
+	}
+	return metric, true
+}
+
+// Returns the metric a series is named when no template applies: its name followed by the name and value
+// of each other label in order of name, eg: http_requests_total.instance.web1:9090.job.api
+func defaultMetric(labels map[string]string) (metric string, ok bool) {
+	name, ok := labels[nameLabel]
+	if !ok || name == "" {
+		return "", false
+	}
+	var names []string
+	for label, value := range labels {
+		if label != nameLabel && value != "" {
+			names = append(names, label)
+		}
+	}
+	sort.Strings(names)
+
+	components := []string{sanitize(name)}
+	for _, label := range names {
+		components = append(components, sanitize(label), sanitize(labels[label]))
+	}
+	return strings.Join(components, "."), true
+}
+
+// Replace the characters of a label value that would split a metric name or its path
+func sanitize(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '/' || r == '\\' || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, value)
+}
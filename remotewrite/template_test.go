@@ -0,0 +1,45 @@
+package remotewrite
+
+import "testing"
+
+func TestTemplate(t *testing.T) {
+	labels := map[string]string{"__name__": "http_requests_total", "job": "api", "instance": "web1.example.com:9090", "path": "/a b"}
+	for _, test := range []struct {
+		template string
+		expected string
+		ok       bool
+	}{
+		{"prometheus.{job}.{instance}.{__name__}", "prometheus.api.web1_example_com:9090.http_requests_total", true},
+		{"{job}_{__name__}.{path}", "api_http_requests_total._a_b", true},
+		{"constant", "constant", true},
+		{"{job}.{missing}", "", false},
+		{"{job}..{__name__}", "", false},
+		{"{job}.", "", false},
+	} {
+		template, err := ParseTemplate(test.template)
+		if err != nil {
+			t.Errorf("parsing %q: %s", test.template, err)
+			continue
+		}
+		if template.String() != test.template {
+			t.Errorf("template %q prints as %q", test.template, template)
+		}
+		metric, ok := template.Metric(labels)
+		if metric != test.expected || ok != test.ok {
+			t.Errorf("template %q named %q, %v, want %q, %v", test.template, metric, ok, test.expected, test.ok)
+		}
+	}
+
+	for _, text := range []string{"{job", "job}", "{}", "{a{b}}"} {
+		if _, err := ParseTemplate(text); err == nil {
+			t.Errorf("parsed invalid template %q", text)
+		}
+	}
+
+	if metric, ok := defaultMetric(labels); !ok || metric != "http_requests_total.instance.web1_example_com:9090.job.api.path._a_b" {
+		t.Errorf("default metric is %q, %v", metric, ok)
+	}
+	if _, ok := defaultMetric(map[string]string{"job": "api"}); ok {
+		t.Error("named a series without a name")
+	}
+}
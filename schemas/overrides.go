@@ -0,0 +1,132 @@
+package schemas
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"github.com/kisielk/whisper-go/whisperwalk"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Override holds the creation settings of a single metric that differ from what the schemas give it
+type Override struct {
+	XFilesFactor      *float32                  // xFilesFactor to create the metric with, if set
+	AggregationMethod whisper.AggregationMethod // Aggregation method to create the metric with, unless AGGREGATION_UNKNOWN
+}
+
+// Overrides maps exact metric names to their overrides. They cover one-off exceptions which would
+// otherwise need a dedicated pattern in a schema file.
+type Overrides map[string]Override
+
+// Read and parse an overrides file
+func ReadOverridesFile(path string) (overrides Overrides, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return ParseOverrides(file)
+}
+
+/*
+ParseOverrides parses an overrides file. Each line holds a metric name followed by one or more
+key=value settings, for example:
+
+	# keep every sample of the deploy counter
+	servers.web01.deploys xFilesFactor=0 aggregationMethod=sum
+
+The valid keys are xFilesFactor and aggregationMethod. Blank lines and lines starting with # are ignored.
+*/
+func ParseOverrides(r io.Reader) (overrides Overrides, err error) {
+	overrides = make(Overrides)
+	var line int
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, errors.New(fmt.Sprintf("line %d: no settings for %s", line, fields[0]))
+		}
+
+		override := overrides[fields[0]]
+		for _, setting := range fields[1:] {
+			kv := strings.SplitN(setting, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.New(fmt.Sprintf("line %d: expected key=value, got %s", line, setting))
+			}
+
+			switch kv[0] {
+			case "xFilesFactor":
+				xFilesFactor, e := strconv.ParseFloat(kv[1], 32)
+				if e != nil || xFilesFactor < 0 || xFilesFactor > 1 {
+					return nil, errors.New(fmt.Sprintf("line %d: invalid xFilesFactor: %s", line, kv[1]))
+				}
+				x := float32(xFilesFactor)
+				override.XFilesFactor = &x
+			case "aggregationMethod":
+				method, e := whisper.ParseAggregationMethod(kv[1])
+				if e != nil {
+					return nil, errors.New(fmt.Sprintf("line %d: invalid aggregationMethod: %s", line, kv[1]))
+				}
+				override.AggregationMethod = method
+			default:
+				return nil, errors.New(fmt.Sprintf("line %d: unknown setting: %s", line, kv[0]))
+			}
+		}
+		overrides[fields[0]] = override
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Apply the overrides of a metric, if it has any, to the settings it would otherwise be created with
+func (o Overrides) Apply(metric string, xFilesFactor float32, aggregationMethod whisper.AggregationMethod) (float32, whisper.AggregationMethod) {
+	override, ok := o[metric]
+	if !ok {
+		return xFilesFactor, aggregationMethod
+	}
+	if override.XFilesFactor != nil {
+		xFilesFactor = *override.XFilesFactor
+	}
+	if override.AggregationMethod != whisper.AGGREGATION_UNKNOWN {
+		aggregationMethod = override.AggregationMethod
+	}
+	return xFilesFactor, aggregationMethod
+}
+
+/*
+Creator returns a whisper.Creator for a Pool writing to the tree under root, which has the given
+layout, or the FlatLayout if nil. Missing databases are created with the archives of the first
+schema matching their metric and with xFilesFactor and aggregationMethod, as changed by the
+metric's overrides. Databases of metrics no schema matches, or outside root, aren't created.
+*/
+func (s Schemas) Creator(root string, layout whisperwalk.Layout, overrides Overrides, xFilesFactor float32, aggregationMethod whisper.AggregationMethod) whisper.Creator {
+	if layout == nil {
+		layout = whisperwalk.FlatLayout{}
+	}
+	return func(path string) (archives []whisper.ArchiveInfo, x float32, method whisper.AggregationMethod, ok bool) {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return
+		}
+		metric := layout.Metric(rel)
+		archives = s.Match(metric)
+		if archives == nil {
+			return
+		}
+		x, method = overrides.Apply(metric, xFilesFactor, aggregationMethod)
+		return archives, x, method, true
+	}
+}
@@ -0,0 +1,75 @@
+package schemas
+
+import (
+	"github.com/kisielk/whisper-go/whisper"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseOverrides(t *testing.T) {
+	overrides, err := ParseOverrides(strings.NewReader(`
+# one-off exceptions
+servers.web01.deploys xFilesFactor=0 aggregationMethod=sum
+servers.web01.load    aggregationMethod=max
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		metric       string
+		xFilesFactor float32
+		method       whisper.AggregationMethod
+	}{
+		{"servers.web01.deploys", 0, whisper.AGGREGATION_SUM},
+		{"servers.web01.load", 0.5, whisper.AGGREGATION_MAX},
+		{"servers.web01.cpu", 0.5, whisper.AGGREGATION_AVERAGE},
+	}
+	for _, tt := range tests {
+		xFilesFactor, method := overrides.Apply(tt.metric, 0.5, whisper.AGGREGATION_AVERAGE)
+		if xFilesFactor != tt.xFilesFactor || method != tt.method {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.metric, xFilesFactor, method, tt.xFilesFactor, tt.method)
+		}
+	}
+
+	for _, bad := range []string{"a.b\n", "a.b xFilesFactor=2\n", "a.b aggregationMethod=median\n", "a.b color=red\n", "a.b sum\n"} {
+		if _, err := ParseOverrides(strings.NewReader(bad)); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestCreator(t *testing.T) {
+	schemas, err := Parse(strings.NewReader("[servers]\npattern = ^servers\\.\nretentions = 60:1d\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := ParseOverrides(strings.NewReader("servers.web01.deploys xFilesFactor=0 aggregationMethod=sum\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join("data", "whisper")
+	creator := schemas.Creator(root, nil, overrides, 0.5, whisper.AGGREGATION_AVERAGE)
+
+	tests := []struct {
+		path         string
+		ok           bool
+		xFilesFactor float32
+		method       whisper.AggregationMethod
+	}{
+		{filepath.Join(root, "servers", "web01", "deploys.wsp"), true, 0, whisper.AGGREGATION_SUM},
+		{filepath.Join(root, "servers", "web01", "load.wsp"), true, 0.5, whisper.AGGREGATION_AVERAGE},
+		{filepath.Join(root, "network", "eth0.wsp"), false, 0, whisper.AGGREGATION_UNKNOWN},
+		{filepath.Join("data", "servers", "web01.wsp"), false, 0, whisper.AGGREGATION_UNKNOWN},
+	}
+	for _, tt := range tests {
+		archives, xFilesFactor, method, ok := creator(tt.path)
+		if ok != tt.ok || xFilesFactor != tt.xFilesFactor || method != tt.method {
+			t.Errorf("%s: got %v, %v, %v, want %v, %v, %v", tt.path, xFilesFactor, method, ok, tt.xFilesFactor, tt.method, tt.ok)
+		}
+		if ok && (len(archives) != 1 || archives[0].SecondsPerPoint != 60) {
+			t.Errorf("%s: got archives %v", tt.path, archives)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+
+Package schemas parses Graphite's storage-schemas.conf and matches metric names against it to find the
+archives a new whisper database should be created with.
+
+*/
+package schemas
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Schema is a single section of storage-schemas.conf
+type Schema struct {
+	Name       string                // Name of the section
+	Pattern    *regexp.Regexp        // Metric names matching this pattern use the schema
+	Retentions string                // The retention definitions as written in the file
+	Archives   []whisper.ArchiveInfo // The archives described by Retentions
+}
+
+// Schemas is a list of schemas in the order they appear in the file
+type Schemas []Schema
+
+// Read and parse a storage-schemas.conf file
+func ReadFile(path string) (schemas Schemas, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Parse the contents of a storage-schemas.conf file. Every section must have a pattern and retentions;
+// other keys are ignored.
+func Parse(r io.Reader) (schemas Schemas, err error) {
+	var current *Schema
+	var line int
+
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		if current.Pattern == nil {
+			return errors.New(fmt.Sprintf("section [%s] has no pattern", current.Name))
+		}
+		if current.Archives == nil {
+			return errors.New(fmt.Sprintf("section [%s] has no retentions", current.Name))
+		}
+		schemas = append(schemas, *current)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			err = finish()
+			if err != nil {
+				return nil, err
+			}
+			current = &Schema{Name: strings.TrimSpace(text[1 : len(text)-1])}
+			continue
+		}
+
+		if current == nil {
+			return nil, errors.New(fmt.Sprintf("line %d: entry outside of a section", line))
+		}
+
+		i := strings.IndexAny(text, "=:")
+		if i < 0 {
+			return nil, errors.New(fmt.Sprintf("line %d: expected key = value", line))
+		}
+		key := strings.ToLower(strings.TrimSpace(text[:i]))
+		value := strings.TrimSpace(text[i+1:])
+
+		switch key {
+		case "pattern":
+			current.Pattern, err = regexp.Compile(value)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("line %d: invalid pattern: %s", line, err))
+			}
+		case "retentions":
+			current.Retentions = value
+			current.Archives, err = whisper.ParseRetentionDefs(value)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("line %d: invalid retentions: %s", line, err))
+			}
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = finish()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Find the first schema whose pattern matches the metric name
+func (s Schemas) Find(metric string) (schema Schema, ok bool) {
+	for _, schema := range s {
+		if schema.Pattern.MatchString(metric) {
+			return schema, true
+		}
+	}
+	return
+}
+
+// Match returns the archives of the first schema whose pattern matches the metric name, or nil if none match
+func (s Schemas) Match(metric string) []whisper.ArchiveInfo {
+	schema, ok := s.Find(metric)
+	if !ok {
+		return nil
+	}
+	return append([]whisper.ArchiveInfo(nil), schema.Archives...)
+}
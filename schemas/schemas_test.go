@@ -0,0 +1,79 @@
+package schemas
+
+import (
+	"strings"
+	"testing"
+)
+
+const config = `
+# Schema definitions for Whisper files. Entries are scanned in order,
+# and first match wins.
+[carbon]
+pattern = ^carbon\.
+retentions = 60:90d
+
+[collectd]
+priority = 10
+pattern = ^collectd\.
+retentions = 10s:6h,1m:7d,10m:5y
+
+[default_1min_for_1day]
+pattern = .*
+retentions = 60s:1d
+`
+
+func TestParse(t *testing.T) {
+	schemas, err := Parse(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) != 3 {
+		t.Fatalf("got %d schemas, want 3", len(schemas))
+	}
+
+	tests := []struct {
+		metric string
+		schema string
+		points uint32
+	}{
+		{"carbon.agents.a.cpuUsage", "carbon", 129600},
+		{"collectd.host.load", "collectd", 2160},
+		{"servers.web01.load", "default_1min_for_1day", 1440},
+	}
+
+	for _, tt := range tests {
+		schema, ok := schemas.Find(tt.metric)
+		if !ok || schema.Name != tt.schema {
+			t.Errorf("%s: matched %q, want %q", tt.metric, schema.Name, tt.schema)
+			continue
+		}
+		archives := schemas.Match(tt.metric)
+		if archives[0].Points != tt.points {
+			t.Errorf("%s: first archive has %d points, want %d", tt.metric, archives[0].Points, tt.points)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	configs := []string{
+		"pattern = .*\n",
+		"[a]\nretentions = 60:1d\n",
+		"[a]\npattern = .*\n",
+		"[a]\npattern = (\nretentions = 60:1d\n",
+		"[a]\npattern = .*\nretentions = 60:1d,60:2d\n",
+		"[a]\npattern\n",
+	}
+	for _, c := range configs {
+		if _, err := Parse(strings.NewReader(c)); err == nil {
+			t.Errorf("no error for %q", c)
+		}
+	}
+
+	schemas, err := Parse(strings.NewReader("[a]\npattern = ^a\\.\nretentions = 60:1d\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archives := schemas.Match("b.c"); archives != nil {
+		t.Errorf("Match(b.c) = %v, want nil", archives)
+	}
+}
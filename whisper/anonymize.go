@@ -0,0 +1,121 @@
+package whisper
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// AnonymizeOptions controls how Anonymize scrambles the values of a database
+type AnonymizeOptions struct {
+	PreserveScale bool  // Keep the sign and power of ten of every value
+	PreserveShape bool  // Multiply every value by the same random factor, so trends and relative changes survive
+	Seed          int64 // Seed of the random values, so a run can be repeated. A random seed is used if zero
+}
+
+/*
+Anonymize copies the whisper database at src to a new file at dst with its values scrambled, so a
+problem database can be shared without leaking the data it holds.
+
+The header and the timestamp of every slot are copied unchanged, as they are usually what the problem
+is about. Slots that have never been written stay empty and NaN or infinite values, including stale
+markers, are kept. By default every other value is replaced by a random number between 0 and 1;
+opts.PreserveScale and opts.PreserveShape keep some of the character of the data instead. When both
+are set every value is multiplied by a single random factor between 0.5 and 2.
+
+Sidecar files such as the series info, frozen archives and interval filter are not copied. dst must
+not exist.
+*/
+func Anonymize(src, dst string, opts AnonymizeOptions) (err error) {
+	old, err := OpenReadOnly(src)
+	if err != nil {
+		return
+	}
+	defer old.Close()
+
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	scrambler := newScrambler(rand.New(rand.NewSource(seed)), opts)
+
+	file, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	// The header is copied as is, so even a malformed layout can be reproduced
+	w := &Whisper{Header: old.Header, storage: file}
+	defer w.Close()
+
+	err = file.Truncate(int64(w.Header.end()))
+	if err != nil {
+		return
+	}
+	err = w.writeAt(0, w.Header.Metadata)
+	if err != nil {
+		return
+	}
+	err = w.writeAt(metadataSize, w.Header.Archives)
+	if err != nil {
+		return
+	}
+
+	for _, info := range old.Header.Archives {
+		slots, e := old.readArchive(info)
+		if e != nil {
+			return e
+		}
+		for i, point := range slots {
+			if point.Timestamp != 0 {
+				slots[i].Value = scrambler.scramble(point.Value)
+			}
+		}
+		err = w.writeAt(info.Offset, slots)
+		if err != nil {
+			return
+		}
+	}
+	return w.sync()
+}
+
+// Replaces values following a set of AnonymizeOptions
+type scrambler struct {
+	rand   *rand.Rand
+	opts   AnonymizeOptions
+	factor float64 // Multiplies every value when the shape is preserved
+}
+
+func newScrambler(r *rand.Rand, opts AnonymizeOptions) *scrambler {
+	s := &scrambler{rand: r, opts: opts}
+	if opts.PreserveScale {
+		// Between 0.5 and 2
+		s.factor = math.Pow(2, 2*r.Float64()-1)
+	} else {
+		// Between 0.001 and 1000
+		s.factor = math.Pow(10, 6*r.Float64()-3)
+	}
+	return s
+}
+
+func (s *scrambler) scramble(value float64) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	switch {
+	case s.opts.PreserveShape:
+		return value * s.factor
+	case s.opts.PreserveScale:
+		if value == 0 {
+			return 0
+		}
+		magnitude := math.Pow(10, math.Floor(math.Log10(math.Abs(value))))
+		return math.Copysign(magnitude*(1+9*s.rand.Float64()), value)
+	}
+	return s.rand.Float64()
+}
@@ -0,0 +1,82 @@
+package whisper
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnonymize(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.wsp")
+	original := archive{{100, 12.5}, {110, -340}, {120, 0}, {130, math.NaN()}, {140, 0.02}}
+	createWithPoints(t, src, ArchiveInfo{0, 10, 8}, original)
+
+	tests := []struct {
+		name  string
+		opts  AnonymizeOptions
+		check func(before, after float64) bool
+	}{
+		{"random", AnonymizeOptions{}, func(before, after float64) bool {
+			return after >= 0 && after < 1
+		}},
+		{"scale", AnonymizeOptions{PreserveScale: true}, func(before, after float64) bool {
+			if before == 0 {
+				return after == 0
+			}
+			return math.Signbit(before) == math.Signbit(after) &&
+				math.Floor(math.Log10(math.Abs(before))) == math.Floor(math.Log10(math.Abs(after)))
+		}},
+		{"shape", AnonymizeOptions{PreserveShape: true}, nil},
+	}
+	for _, test := range tests {
+		dst := filepath.Join(dir, test.name+".wsp")
+		test.opts.Seed = 42
+		if err := Anonymize(src, dst, test.opts); err != nil {
+			t.Fatal(err)
+		}
+		w, err := OpenReadOnly(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slots, err := w.readArchive(w.Header.Archives[0])
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var factor float64
+		for i, point := range slots {
+			if i >= len(original) {
+				if point != (Point{}) {
+					t.Errorf("%s: empty slot %d became %v", test.name, i, point)
+				}
+				continue
+			}
+			before := original[i]
+			if point.Timestamp != before.Timestamp {
+				t.Errorf("%s: slot %d timestamp = %d, want %d", test.name, i, point.Timestamp, before.Timestamp)
+			}
+			if math.IsNaN(before.Value) {
+				if !math.IsNaN(point.Value) {
+					t.Errorf("%s: NaN became %g", test.name, point.Value)
+				}
+				continue
+			}
+			if test.check != nil && !test.check(before.Value, point.Value) {
+				t.Errorf("%s: %g became %g", test.name, before.Value, point.Value)
+			}
+			if test.check == nil && before.Value != 0 {
+				if factor == 0 {
+					factor = point.Value / before.Value
+				} else if math.Abs(point.Value/before.Value-factor) > 1e-9*factor {
+					t.Errorf("%s: %g became %g, not scaled by %g", test.name, before.Value, point.Value, factor)
+				}
+			}
+		}
+	}
+
+	if err := Anonymize(src, filepath.Join(dir, "random.wsp"), AnonymizeOptions{}); err == nil {
+		t.Error("overwrote an existing file")
+	}
+}
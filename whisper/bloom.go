@@ -0,0 +1,220 @@
+package whisper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+)
+
+/*
+An IntervalFilter is a bloom filter of the time buckets a database has been written to. Fetches on
+a handle with a filter return empty results without reading the database when the filter shows
+that nothing was written in the fetched range, which saves most disk reads for very sparse metrics.
+
+The filter only knows about writes made through handles it is attached to, and points written
+before it was created by BuildIntervalFilter. Merge and Fill add the points they copy to the filter
+attached to the destination handle. They, Stitch and Resize also remove the saved filter of the
+database they change, so it has to be rebuilt. Writes made by another process must be followed by
+rebuilding the filter. An IntervalFilter is safe for concurrent use.
+*/
+type IntervalFilter struct {
+	mutex  sync.RWMutex
+	bucket uint32   // Seconds of time covered by each entry
+	hashes uint32   // Number of bits set per entry
+	words  []uint64 // The bits of the filter
+}
+
+// Header of the sidecar file an IntervalFilter is saved in
+type intervalFilterHeader struct {
+	Bucket uint32
+	Hashes uint32
+	Words  uint32
+}
+
+// Create an empty filter of the given size in bits, tracking writes in buckets of the given number of seconds
+func NewIntervalFilter(bits, bucket uint32) *IntervalFilter {
+	words := (bits + 63) / 64
+	if words == 0 {
+		words = 1
+	}
+	return &IntervalFilter{bucket: bucket, hashes: 4, words: make([]uint64, words)}
+}
+
+// Create a filter holding every point currently stored in any archive of a database
+func BuildIntervalFilter(w *Whisper, bits, bucket uint32) (filter *IntervalFilter, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	filter = NewIntervalFilter(bits, bucket)
+	for _, info := range w.Header.Archives {
+		points, e := w.readArchive(info)
+		if e != nil {
+			return nil, e
+		}
+		for _, point := range points {
+			if point.Timestamp == 0 {
+				// Never written
+				continue
+			}
+			filter.addRange(point.Timestamp, point.Timestamp+info.SecondsPerPoint)
+		}
+	}
+	return
+}
+
+// Returns the path of the interval filter sidecar for the database at path
+func IntervalFilterPath(path string) string {
+	return path + ".bloom"
+}
+
+// Load the interval filter saved for the database at path
+func LoadIntervalFilter(path string) (filter *IntervalFilter, err error) {
+	data, err := os.ReadFile(IntervalFilterPath(path))
+	if err != nil {
+		return
+	}
+
+	var header intervalFilterHeader
+	n, err := binary.Decode(data, binary.BigEndian, &header)
+	if err != nil {
+		return
+	}
+	if header.Bucket == 0 || header.Hashes == 0 || uint64(len(data)-n) != uint64(header.Words)*8 {
+		return nil, errors.New(fmt.Sprintf("invalid interval filter: %s", IntervalFilterPath(path)))
+	}
+	filter = &IntervalFilter{bucket: header.Bucket, hashes: header.Hashes, words: make([]uint64, header.Words)}
+	_, err = binary.Decode(data[n:], binary.BigEndian, filter.words)
+	return
+}
+
+// Save the filter as the interval filter sidecar of the database at path
+func (f *IntervalFilter) Save(path string) (err error) {
+	f.mutex.RLock()
+	header := intervalFilterHeader{f.bucket, f.hashes, uint32(len(f.words))}
+	data, err := binary.Append(nil, binary.BigEndian, header)
+	if err == nil {
+		data, err = binary.Append(data, binary.BigEndian, f.words)
+	}
+	f.mutex.RUnlock()
+	if err != nil {
+		return
+	}
+
+	tmpPath := IntervalFilterPath(path) + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0666)
+	if err != nil {
+		return
+	}
+	return os.Rename(tmpPath, IntervalFilterPath(path))
+}
+
+// Record that a point was written at a timestamp
+func (f *IntervalFilter) Add(timestamp uint32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.set(timestamp / f.bucket)
+}
+
+// Returns false if nothing can have been written between from (inclusive) and until (exclusive)
+func (f *IntervalFilter) MayContain(from, until uint32) bool {
+	if until <= from {
+		return false
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	first, last := from/f.bucket, (until-1)/f.bucket
+	if uint64(last-first) >= uint64(len(f.words))*64 {
+		// The range covers more buckets than the filter has bits, it can't rule anything out
+		return true
+	}
+	for bucket := first; bucket <= last; bucket++ {
+		if f.test(bucket) {
+			return true
+		}
+	}
+	return false
+}
+
+// Record every bucket overlapping the range between from and until
+func (f *IntervalFilter) addRange(from, until uint32) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for bucket := from / f.bucket; bucket <= (until-1)/f.bucket; bucket++ {
+		f.set(bucket)
+	}
+}
+
+func (f *IntervalFilter) set(bucket uint32) {
+	for _, bit := range f.bits(bucket) {
+		f.words[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *IntervalFilter) test(bucket uint32) bool {
+	for _, bit := range f.bits(bucket) {
+		if f.words[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Calculate the bits representing a bucket, using double hashing of a single 64 bit hash
+func (f *IntervalFilter) bits(bucket uint32) []uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, bucket)
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	size := uint64(len(f.words)) * 64
+
+	bits := make([]uint64, f.hashes)
+	for i := range bits {
+		bits[i] = (h1 + uint64(i)*h2) % size
+	}
+	return bits
+}
+
+// Attach an interval filter to the handle, or detach it if filter is nil. Points written through
+// the handle are added to the filter, and fetches consult it before reading the database.
+func (w *Whisper) SetIntervalFilter(filter *IntervalFilter) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.intervalFilter = filter
+}
+
+// Add written points to the handle's interval filter, if it has one
+func (w *Whisper) filterPoints(points []Point) {
+	if w.intervalFilter == nil {
+		return
+	}
+	for _, point := range points {
+		w.intervalFilter.Add(point.Timestamp)
+	}
+}
+
+// Add the points written to an archive by a bulk copy such as Merge to the handle's interval filter,
+// if it has one
+func (w *Whisper) filterArchive(info ArchiveInfo, points archive) {
+	if w.intervalFilter == nil {
+		return
+	}
+	for _, point := range points {
+		if point.Timestamp != 0 {
+			w.intervalFilter.addRange(point.Timestamp, point.Timestamp+info.SecondsPerPoint)
+		}
+	}
+}
+
+// Remove the interval filter saved for the database at path, which no longer covers its points
+func removeIntervalFilter(path string) (err error) {
+	err = os.Remove(IntervalFilterPath(path))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,162 @@
+package whisper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A Storage that counts reads
+type countingStorage struct {
+	Storage
+	reads int
+}
+
+func (s *countingStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.reads++
+	return s.Storage.ReadAt(p, off)
+}
+
+func TestIntervalFilter(t *testing.T) {
+	filter := NewIntervalFilter(1024, 3600)
+	filter.Add(7200)
+	filter.Add(36000)
+
+	for _, tt := range []struct {
+		from, until uint32
+		expected    bool
+	}{
+		{7200, 7260, true},
+		{0, 7200, false},
+		{0, 7201, true},
+		{10800, 36000, false},
+		{10800, 36060, true},
+	} {
+		if filter.MayContain(tt.from, tt.until) != tt.expected {
+			t.Errorf("MayContain(%d, %d) = %v, want %v", tt.from, tt.until, !tt.expected, tt.expected)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "metric.wsp")
+	if err := filter.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIntervalFilter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.MayContain(36000, 36001) || loaded.MayContain(10800, 36000) {
+		t.Error("loaded filter doesn't match the saved one")
+	}
+}
+
+func TestFetchWithIntervalFilter(t *testing.T) {
+	memory, err := NewMemory([]ArchiveInfo{{0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	written := quantizeTimestamp(now-3600, 60)
+	info := memory.Header.Archives[0]
+	if err := memory.writeArchive(info, archive{{written, 42}}); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &countingStorage{Storage: memory.storage}
+	w, err := OpenStorage(storage, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := BuildIntervalFilter(w, 4096, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetIntervalFilter(filter)
+
+	storage.reads = 0
+	interval, points, err := w.FetchUntil(now-20000, now-10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storage.reads != 0 {
+		t.Errorf("fetch of an empty range read the database %d times", storage.reads)
+	}
+	if uint32(len(points)) != (interval.UntilTimestamp-interval.FromTimestamp)/interval.Step {
+		t.Errorf("got %d points for %+v", len(points), interval)
+	}
+
+	_, points, err = w.FetchUntil(now-7200, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, point := range points {
+		if point.Timestamp == written && point.Value == 42 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("written point missing from fetch: %v", points)
+	}
+}
+
+func TestIntervalFilterAfterBulkWrites(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 10, 60}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	srcPath := filepath.Join(dir, "src.wsp")
+	dstPath := filepath.Join(dir, "dst.wsp")
+	createWithPoints(t, srcPath, info, archive{{now - 300, 1}})
+	createWithPoints(t, dstPath, info, archive{{now - 100, 2}})
+
+	src, err := OpenReadOnly(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := Open(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	filter, err := BuildIntervalFilter(dst, 1024, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.Save(dstPath); err != nil {
+		t.Fatal(err)
+	}
+	dst.SetIntervalFilter(filter)
+
+	if err := Merge(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if !filter.MayContain(now-300, now-290) {
+		t.Error("the attached filter doesn't know about the merged point")
+	}
+	if _, err := os.Stat(IntervalFilterPath(dstPath)); !os.IsNotExist(err) {
+		t.Errorf("saved filter kept after Merge: %v", err)
+	}
+
+	if err := filter.Save(dstPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := Resize(dstPath, []ArchiveInfo{{0, 60, 10}}, ResizeOptions{NoBackup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(IntervalFilterPath(dstPath)); !os.IsNotExist(err) {
+		t.Errorf("saved filter kept after Resize: %v", err)
+	}
+
+	stitched := filepath.Join(dir, "stitched.wsp")
+	if err := filter.Save(stitched); err != nil {
+		t.Fatal(err)
+	}
+	if err := Stitch([]string{srcPath}, stitched, StitchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(IntervalFilterPath(stitched)); !os.IsNotExist(err) {
+		t.Errorf("saved filter kept after Stitch: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package whisper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// A Bootstrapper creates missing databases with history fetched from a remote Graphite render API,
+// so metrics moved to a new storage node keep their history instead of starting empty.
+type Bootstrapper struct {
+	URL    string       // Base URL of the remote graphite-web, eg: http://graphite.example.com
+	Client *http.Client // Client used for requests, http.DefaultClient if nil
+}
+
+/*
+Bootstrap creates the database for metric at path, if it doesn't already exist, and fills every
+archive with the history the remote Graphite holds for the metric over the archive's retention.
+Returns true if the database was created.
+
+The database is written next to path and only moved into place once it is complete, so readers
+never see a partially bootstrapped file. If the remote has no data for the metric, the database is
+created empty.
+*/
+func (b *Bootstrapper) Bootstrap(metric, path string, archives []ArchiveInfo, xFilesFactor float32, aggregationMethod AggregationMethod) (created bool, err error) {
+	if _, err = os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return false, err
+	}
+
+	// Every bootstrap gets its own temporary file, so concurrent bootstraps of the same metric
+	// can't disturb each other and the link below picks the one that lands
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.bootstrap")
+	if err != nil {
+		return
+	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath)
+
+	// Temporary files are only readable by their owner, databases are shared with graphite-web
+	err = file.Chmod(0644)
+	if err != nil {
+		file.Close()
+		return
+	}
+	w, err := CreateStorage(file, archives, xFilesFactor, aggregationMethod)
+	if err != nil {
+		file.Close()
+		return
+	}
+	err = b.fill(metric, w)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
+
+	// Link rather than rename so a database created at path in the meantime is never replaced
+	err = os.Link(tmpPath, path)
+	if os.IsExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Fill every archive of w with the remote history of metric
+func (b *Bootstrapper) fill(metric string, w *Whisper) (err error) {
+	now := uint32(time.Now().Unix())
+	for _, info := range w.Header.Archives {
+		points, e := b.render(metric, info.StartTime(now), now)
+		if e != nil {
+			return e
+		}
+		buckets := lastValueBuckets([]archive{points}, info)
+		err = w.writeArchive(info, livePoints(info, sortedPoints(buckets), now))
+		if err != nil {
+			return
+		}
+	}
+	return w.sync()
+}
+
+// Fetch the known points of metric between from and until from the render API
+func (b *Bootstrapper) render(metric string, from, until uint32) (points archive, err error) {
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	query := url.Values{}
+	query.Set("target", metric)
+	query.Set("format", "json")
+	query.Set("from", strconv.FormatUint(uint64(from), 10))
+	query.Set("until", strconv.FormatUint(uint64(until), 10))
+	response, err := client.Get(b.URL + "/render?" + query.Encode())
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("render %s: %s", metric, response.Status))
+	}
+
+	// Decoded leniently, without requiring the datapoints to be evenly spaced as RenderSeries does
+	var series []renderSeries
+	err = json.NewDecoder(response.Body).Decode(&series)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("render %s: %s", metric, err))
+	}
+	for _, s := range series {
+		for _, datapoint := range s.Datapoints {
+			if datapoint[0] == nil || datapoint[1] == nil {
+				// No value for the interval
+				continue
+			}
+			points = append(points, Point{uint32(*datapoint[1]), *datapoint[0]})
+		}
+	}
+	return
+}
@@ -0,0 +1,114 @@
+package whisper
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBootstrap(t *testing.T) {
+	now := uint32(time.Now().Unix())
+	recent := quantizeTimestamp(now-120, 60)
+	old := quantizeTimestamp(now-86400, 3600)
+
+	var targets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.FormValue("target"))
+		from, _ := strconv.ParseUint(r.FormValue("from"), 10, 32)
+		if uint32(from) < old {
+			// Only the low precision archive's range reaches back this far
+			fmt.Fprintf(w, `[{"target": "servers.a.load", "datapoints": [[null, %d], [4, %d], [1, %d]]}]`, old-3600, old, recent)
+		} else {
+			fmt.Fprintf(w, `[{"target": "servers.a.load", "datapoints": [[2, %d], [null, %d]]}]`, recent, recent+60)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "load.wsp")
+	b := &Bootstrapper{URL: server.URL}
+	archives := []ArchiveInfo{{0, 60, 60}, {0, 3600, 48}}
+	created, err := b.Bootstrap("servers.a.load", path, archives, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || len(targets) != 2 || targets[0] != "servers.a.load" {
+		t.Fatalf("created = %v, requested %v", created, targets)
+	}
+
+	w, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i, expected := range []Point{{recent, 2}, {old, 4}} {
+		points, err := w.readArchive(w.Header.Archives[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, point := range points {
+			if point == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("archive %d is missing %v: %v", i, expected, livePoints(w.Header.Archives[i], points, now))
+		}
+	}
+
+	created, err = b.Bootstrap("servers.a.load", path, archives, 0.5, AGGREGATION_AVERAGE)
+	if err != nil || created || len(targets) != 2 {
+		t.Errorf("expected an existing database to be left alone, created = %v, err = %v", created, err)
+	}
+}
+
+func TestConcurrentBootstrap(t *testing.T) {
+	recent := quantizeTimestamp(uint32(time.Now().Unix())-120, 60)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"target": "servers.a.load", "datapoints": [[2, %d]]}]`, recent)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "load.wsp")
+	b := &Bootstrapper{URL: server.URL}
+	results := make(chan bool, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			created, err := b.Bootstrap("servers.a.load", path, []ArchiveInfo{{0, 60, 60}}, 0.5, AGGREGATION_AVERAGE)
+			if err != nil {
+				t.Error(err)
+			}
+			results <- created
+		}()
+	}
+	created := 0
+	for i := 0; i < 4; i++ {
+		if <-results {
+			created++
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d bootstraps created the database, want 1", created)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("database mode %v, want 0644", info.Mode().Perm())
+	}
+}
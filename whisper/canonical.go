@@ -0,0 +1,104 @@
+package whisper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+/*
+Canonicalize rewrites the whisper database at path in a canonical form, so databases holding the
+same data are byte for byte identical and deduplicate well in backup systems.
+
+The header is validated and rebuilt: archives are sorted by precision, offsets and the maximum
+retention are recomputed. Slots that have never been written, hold a timestamp that isn't aligned
+to the archive's precision, or fall outside the archive's retention counting back from its newest
+point are cleared to zero. The remaining points are laid out with the oldest in the first slot.
+
+The database is rewritten to a temporary file which is renamed over the original. Writes to the
+original fail with ErrMaintenanceInProgress while it is being canonicalized.
+*/
+func Canonicalize(path string) (err error) {
+	lock, err := LockForMaintenance(path)
+	if err != nil {
+		return
+	}
+	defer lock.Unlock()
+
+	old, err := OpenReadOnly(path)
+	if err != nil {
+		return
+	}
+	defer old.Close()
+
+	metadata := old.Header.Metadata
+	err = validateMetadata(metadata.XFilesFactor, metadata.AggregationMethod)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+
+	// Sort the old archives by precision, remembering where each one came from
+	sorted := append([]ArchiveInfo(nil), old.Header.Archives...)
+	sort.Stable(bySecondsPerPoint(sorted))
+	archives := append([]ArchiveInfo(nil), sorted...)
+	err = ValidateArchiveList(archives)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+	err = Create(tmpPath, archives, metadata.XFilesFactor, metadata.AggregationMethod, false)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	w, err := Open(tmpPath)
+	if err != nil {
+		return
+	}
+	defer w.Close()
+
+	for i, info := range w.Header.Archives {
+		points, e := old.readArchive(sorted[i])
+		if e != nil {
+			return e
+		}
+
+		aligned := make(map[uint32]Point)
+		for _, point := range points {
+			if point.Timestamp != 0 && point.Timestamp%info.SecondsPerPoint == 0 {
+				aligned[point.Timestamp] = point
+			}
+		}
+		err = w.writeArchive(info, newestPoints(sortedPoints(aligned), info))
+		if err != nil {
+			return
+		}
+		if old.frozen[sorted[i].Offset] {
+			if w.frozen == nil {
+				w.frozen = make(map[uint32]bool)
+			}
+			w.frozen[info.Offset] = true
+		}
+	}
+
+	err = w.sync()
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return
+	}
+
+	// Archives may have moved, so the frozen archives are listed again by their new index
+	w.path = path
+	return w.saveFrozen()
+}
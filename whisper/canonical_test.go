@@ -0,0 +1,62 @@
+package whisper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 10, 5}
+	a := filepath.Join(dir, "a.wsp")
+	b := filepath.Join(dir, "b.wsp")
+	createWithPoints(t, a, info, archive{{100, 1}, {110, 2}, {120, 3}})
+	createWithPoints(t, b, info, archive{})
+
+	// The same points in b, rotated in the ring buffer, with a misaligned point and one that is
+	// too old to still be in the archive
+	w, err := Open(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slots := archive{{120, 3}, {55, 9}, {40, 7}, {100, 1}, {110, 2}}
+	if err := w.writeAt(w.Header.Archives[0].Offset, slots); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	for _, path := range []string{a, b} {
+		if err := Canonicalize(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dataA, err := os.ReadFile(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataB, err := os.ReadFile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dataA, dataB) {
+		t.Error("canonical files differ")
+	}
+
+	w, err = OpenReadOnly(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	points, err := w.readArchive(w.Header.Archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := archive{{100, 1}, {110, 2}, {120, 3}, {}, {}}
+	for i := range expected {
+		if points[i] != expected[i] {
+			t.Errorf("slot %d = %v, want %v", i, points[i], expected[i])
+		}
+	}
+}
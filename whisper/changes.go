@@ -0,0 +1,70 @@
+package whisper
+
+import (
+	"sort"
+)
+
+/*
+ChangesSince returns the points of the highest precision archive with a timestamp after since, oldest
+first, so incremental exporters can process only the points written since their last run by passing
+the newest timestamp they have already exported.
+
+Whisper doesn't record when a point was written, so changes are found by their timestamps: a point
+backfilled with a timestamp before since isn't returned. Slots left over from earlier passes of the
+ring buffer are ignored.
+*/
+func (w *Whisper) ChangesSince(since uint32) (points []Point, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return w.slotsSince(w.Header.Archives[0], since)
+}
+
+/*
+ChangedSince returns a Dump holding, for every archive, only the slots with a timestamp after since,
+for incremental backups that ship just the points added since the previous backup instead of whole
+files. Changes are found by their timestamps as in ChangesSince.
+*/
+func (w *Whisper) ChangedSince(since uint32) (changes Dump, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	changes.AggregationMethod = w.Header.Metadata.AggregationMethod
+	changes.XFilesFactor = w.Header.Metadata.XFilesFactor
+	for _, info := range w.Header.Archives {
+		points, e := w.slotsSince(info, since)
+		if e != nil {
+			return changes, e
+		}
+		changes.Archives = append(changes.Archives, DumpArchive{info.SecondsPerPoint, info.Points, points})
+	}
+	return
+}
+
+// Returns the slots of an archive with a timestamp after since, oldest first, ignoring slots left over
+// from earlier passes of the ring buffer
+func (w *Whisper) slotsSince(info ArchiveInfo, since uint32) (points []Point, err error) {
+	slots, err := w.readArchive(info)
+	if err != nil {
+		return
+	}
+	var newest uint32
+	for _, slot := range slots {
+		if slot.Timestamp > newest {
+			newest = slot.Timestamp
+		}
+	}
+
+	// Slots holding timestamps a full retention or more before the newest are from earlier passes
+	var oldest uint32
+	if newest > info.Retention() {
+		oldest = newest - info.Retention()
+	}
+	for _, slot := range slots {
+		if slot.Timestamp > since && slot.Timestamp > oldest {
+			points = append(points, slot)
+		}
+	}
+	sort.Sort(archive(points))
+	return
+}
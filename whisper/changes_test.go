@@ -0,0 +1,78 @@
+package whisper
+
+import (
+	"testing"
+)
+
+func TestChangesSince(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 4}, {0, 240, 4}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The slot holding 1200 is left over from the previous pass of the ring buffer
+	slots := []Point{{1440, 5}, {1500, 6}, {1200, 1}, {1380, 4}}
+	if err := w.writeAt(w.Header.Archives[0].Offset, slots); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		since    uint32
+		expected []Point
+	}{
+		{0, []Point{{1380, 4}, {1440, 5}, {1500, 6}}},
+		{1380, []Point{{1440, 5}, {1500, 6}}},
+		{1500, nil},
+	} {
+		points, err := w.ChangesSince(tt.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != len(tt.expected) {
+			t.Errorf("ChangesSince(%d) = %v, want %v", tt.since, points, tt.expected)
+			continue
+		}
+		for i := range points {
+			if points[i] != tt.expected[i] {
+				t.Errorf("ChangesSince(%d) = %v, want %v", tt.since, points, tt.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 4}, {0, 240, 4}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeAt(w.Header.Archives[0].Offset, []Point{{1440, 5}, {1500, 6}, {1200, 1}, {1380, 4}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeAt(w.Header.Archives[1].Offset, []Point{{960, 2}, {1200, 3}, {1440, 5.5}}); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := w.ChangedSince(1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes.AggregationMethod != AGGREGATION_AVERAGE || changes.XFilesFactor != 0.5 || len(changes.Archives) != 2 {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	expected := [][]Point{{{1380, 4}, {1440, 5}, {1500, 6}}, {{1440, 5.5}}}
+	for i, archive := range changes.Archives {
+		if archive.SecondsPerPoint != w.Header.Archives[i].SecondsPerPoint || archive.Points != 4 {
+			t.Errorf("archive %d has layout %d:%d", i, archive.SecondsPerPoint, archive.Points)
+		}
+		if len(archive.Data) != len(expected[i]) {
+			t.Errorf("archive %d changed %v, want %v", i, archive.Data, expected[i])
+			continue
+		}
+		for j := range archive.Data {
+			if archive.Data[j] != expected[i][j] {
+				t.Errorf("archive %d changed %v, want %v", i, archive.Data, expected[i])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package whisper
+
+import (
+	"io"
+	"os"
+)
+
+/*
+CloneFile copies the file at src to a new file at dst, which must not exist. Where the filesystem
+supports it the copy is a reflink sharing the data blocks of the original until either is written,
+which makes copying large databases nearly free. Otherwise the data is copied, in the kernel where
+the platform allows it.
+*/
+func CloneFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return
+	}
+	defer func() {
+		e := out.Close()
+		if err == nil {
+			err = e
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	cloned, err := cloneFile(out, in)
+	if err != nil || cloned {
+		return
+	}
+	// io.Copy between files uses copy_file_range or sendfile where available
+	_, err = io.Copy(out, in)
+	return
+}
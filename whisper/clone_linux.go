@@ -0,0 +1,21 @@
+package whisper
+
+import (
+	"os"
+	"syscall"
+)
+
+// The FICLONE ioctl, which makes a file share the data of another
+const ficlone = 0x40049409
+
+// Make dst a reflink of src if the filesystem supports it. Returns false if it doesn't.
+func cloneFile(dst, src *os.File) (cloned bool, err error) {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd())
+	switch errno {
+	case 0:
+		return true, nil
+	case syscall.EOPNOTSUPP, syscall.ENOTTY, syscall.EINVAL, syscall.EXDEV, syscall.EBADF, syscall.EPERM:
+		return false, nil
+	}
+	return false, errno
+}
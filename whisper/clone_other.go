@@ -0,0 +1,13 @@
+//go:build !linux
+
+package whisper
+
+import (
+	"os"
+)
+
+// Reflinks are not supported on this platform, so files are always copied
+
+func cloneFile(dst, src *os.File) (cloned bool, err error) {
+	return false, nil
+}
@@ -0,0 +1,92 @@
+package whisper
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Empty databases that createFixture clones, so tests don't create every database from scratch
+var fixtures struct {
+	sync.Mutex
+	dir       string
+	templates map[string]string
+}
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "whisper-fixtures")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fixtures.dir = dir
+	fixtures.templates = make(map[string]string)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Create an empty database at path by cloning a template with the same archives and metadata
+func createFixture(t *testing.T, path string, archives []ArchiveInfo, xFilesFactor float32, aggregationMethod AggregationMethod) {
+	t.Helper()
+	key := fmt.Sprintf("%v %g %d", archives, xFilesFactor, aggregationMethod)
+
+	fixtures.Lock()
+	template, ok := fixtures.templates[key]
+	if !ok {
+		template = filepath.Join(fixtures.dir, fmt.Sprintf("%d.wsp", len(fixtures.templates)))
+		if err := Create(template, archives, xFilesFactor, aggregationMethod, false); err != nil {
+			fixtures.Unlock()
+			t.Fatal(err)
+		}
+		fixtures.templates[key] = template
+	}
+	fixtures.Unlock()
+
+	if err := CloneFile(template, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloneFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.wsp")
+	createWithPoints(t, src, ArchiveInfo{0, 60, 10}, archive{{600, 1}, {660, 2}})
+	original, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "dst.wsp")
+	if err := CloneFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	cloned, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cloned, original) {
+		t.Error("clone differs from the original")
+	}
+
+	// Writing to the clone leaves the original alone
+	w, err := Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.writeArchive(w.Header.Archives[0], archive{{720, 3}})
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(src); !bytes.Equal(after, original) {
+		t.Error("writing to the clone changed the original")
+	}
+
+	if err := CloneFile(src, dst); err == nil {
+		t.Error("cloned over an existing file")
+	}
+}
@@ -0,0 +1,70 @@
+package whisper
+
+/*
+FetchConsolidated fetches the values between two timestamps like FetchSeries, then consolidates them
+into at most maxPoints values with method, like graphite's maxDataPoints handling.
+
+Runs of consecutive values are combined into one, multiplying the step. The runs are aligned to
+multiples of the new step, so the consolidated values of a time range don't change as the range
+moves. Missing values are ignored and a run without any known value stays nil. If maxPoints is zero
+or the series already fits, it is returned unchanged.
+*/
+func (w *Whisper) FetchConsolidated(from, until uint32, maxPoints int, method AggregationMethod) (series Series, err error) {
+	series, err = w.FetchSeries(from, until)
+	if err != nil {
+		return
+	}
+	return consolidate(series, maxPoints, method)
+}
+
+// Combine the values of a series into at most maxPoints values
+func consolidate(series Series, maxPoints int, method AggregationMethod) (consolidated Series, err error) {
+	if maxPoints <= 0 || len(series.Values) <= maxPoints {
+		return series, nil
+	}
+
+	// Aligning the runs can need one more of them than the length alone suggests
+	perPoint := uint32((len(series.Values) + maxPoints - 1) / maxPoints)
+	var step uint32
+	for {
+		step = series.Step * perPoint
+		start := quantizeTimestamp(series.From, step)
+		if (series.Until-start+step-1)/step <= uint32(maxPoints) {
+			break
+		}
+		perPoint++
+	}
+
+	return series.GroupBy(step, method)
+}
+
+/*
+GroupBy combines the values of a series into intervals of step seconds with method. The intervals are
+aligned to multiples of step. Missing values are ignored and an interval without any known value is nil.
+*/
+func (series Series) GroupBy(step uint32, method AggregationMethod) (grouped Series, err error) {
+	start := quantizeTimestamp(series.From, step)
+	count := (series.Until - start + step - 1) / step
+	runs := make([][]Point, count)
+	for i, value := range series.Values {
+		if value == nil {
+			continue
+		}
+		timestamp := series.From + uint32(i)*series.Step
+		run := (timestamp - start) / step
+		runs[run] = append(runs[run], Point{timestamp, *value})
+	}
+
+	grouped = Series{start, start + count*step, step, make([]*float64, count)}
+	for i, run := range runs {
+		if len(run) == 0 {
+			continue
+		}
+		point, e := aggregate(method, run)
+		if e != nil {
+			return Series{}, e
+		}
+		grouped.Values[i] = &point.Value
+	}
+	return
+}
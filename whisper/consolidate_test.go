@@ -0,0 +1,54 @@
+package whisper
+
+import "testing"
+
+func TestConsolidate(t *testing.T) {
+	values := make([]*float64, 7)
+	for i := range values {
+		if i != 4 && i != 5 {
+			value := float64(i)
+			values[i] = &value
+		}
+	}
+	series := Series{110, 180, 10, values} // 110, 120, ..., 170
+
+	unchanged, err := consolidate(series, 10, AGGREGATION_AVERAGE)
+	if err != nil || len(unchanged.Values) != 7 {
+		t.Errorf("series that fits was consolidated to %d values, %v", len(unchanged.Values), err)
+	}
+
+	// Three values per point, with the runs aligned to 30s
+	consolidated, err := consolidate(series, 3, AGGREGATION_SUM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consolidated.From != 90 || consolidated.Until != 180 || consolidated.Step != 30 {
+		t.Errorf("consolidated series covers %d-%d step %d, want 90-180 step 30", consolidated.From, consolidated.Until, consolidated.Step)
+	}
+	// Runs: 90-120 holds 110, 120-150 holds 120-140, 150-180 holds 150-170 of which only 170 is known
+	expected := []float64{0, 1 + 2 + 3, 6}
+	if len(consolidated.Values) != len(expected) {
+		t.Fatalf("got %d values, want %d", len(consolidated.Values), len(expected))
+	}
+	for i, value := range consolidated.Values {
+		if value == nil || *value != expected[i] {
+			t.Errorf("value %d = %v, want %g", i, value, expected[i])
+		}
+	}
+
+	// Two values per point would need 4 runs once aligned to 20s, so three are used
+	shorter, err := consolidate(Series{110, 170, 10, values[:6]}, 3, AGGREGATION_SUM)
+	if err != nil || shorter.Step != 30 || len(shorter.Values) != 3 {
+		t.Errorf("6 values consolidated to %d values with step %d, %v", len(shorter.Values), shorter.Step, err)
+	}
+
+	empty, err := consolidate(Series{100, 200, 10, make([]*float64, 10)}, 5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, value := range empty.Values {
+		if value != nil {
+			t.Errorf("run %d of an empty series = %g", i, *value)
+		}
+	}
+}
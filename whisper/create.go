@@ -0,0 +1,63 @@
+package whisper
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// A Creator returns the archives, xFilesFactor and aggregation method a Pool creates the missing
+// database at path with, or ok false if the database shouldn't be created. See Pool.SetCreator.
+type Creator func(path string) (archives []ArchiveInfo, xFilesFactor float32, aggregationMethod AggregationMethod, ok bool)
+
+/*
+CreateIfMissing opens the whisper database at path, first creating it with the given archives,
+xFilesFactor and aggregation method if it doesn't exist. Returns true if the database was created.
+
+Any number of writers may call CreateIfMissing for the same path at once, as happens when several
+carbon writers receive the first point of a new metric: exactly one of them creates the database and
+the others open it. The database is written next to path and only linked into place once it is
+complete, so no writer ever opens a partially written file.
+*/
+func CreateIfMissing(path string, archives []ArchiveInfo, xFilesFactor float32, aggregationMethod AggregationMethod) (w *Whisper, created bool, err error) {
+	w, err = Open(path)
+	if !os.IsNotExist(err) {
+		return
+	}
+
+	tmpPath := path + "." + strconv.FormatUint(rand.Uint64(), 36) + ".create"
+	err = Create(tmpPath, archives, xFilesFactor, aggregationMethod, false)
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpPath)
+
+	// Link rather than rename so a database created at path in the meantime is never replaced
+	err = os.Link(tmpPath, path)
+	created = err == nil
+	if err != nil && !os.IsExist(err) {
+		return
+	}
+	w, err = Open(path)
+	return
+}
+
+// Open the database at path. If it doesn't exist and creator allows it, the database and any missing
+// parent directories are created first with CreateIfMissing.
+func openOrCreate(path string, creator Creator) (w *Whisper, err error) {
+	w, err = Open(path)
+	if !os.IsNotExist(err) || creator == nil {
+		return
+	}
+	archives, xFilesFactor, aggregationMethod, ok := creator(path)
+	if !ok {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return
+	}
+	w, _, err = CreateIfMissing(path, archives, xFilesFactor, aggregationMethod)
+	return
+}
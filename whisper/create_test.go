@@ -0,0 +1,61 @@
+package whisper
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCreateIfMissing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.wsp")
+	archives := []ArchiveInfo{{0, 60, 1440}}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	creators := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w, created, err := CreateIfMissing(path, archives, 0.5, AGGREGATION_SUM)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer w.Close()
+			if w.Header.Metadata.AggregationMethod != AGGREGATION_SUM {
+				t.Errorf("aggregation method = %s", w.Header.Metadata.AggregationMethod)
+			}
+			if created {
+				mutex.Lock()
+				creators++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if creators != 1 {
+		t.Errorf("database created %d times, want once", creators)
+	}
+
+	// Temporary files are cleaned up
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want 1", len(entries))
+	}
+
+	// An existing database is opened as it is
+	w, created, err := CreateIfMissing(path, []ArchiveInfo{{0, 10, 10}}, 0.5, AGGREGATION_MAX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if created || w.Header.Archives[0].SecondsPerPoint != 60 {
+		t.Errorf("existing database was replaced")
+	}
+}
@@ -0,0 +1,92 @@
+package whisper
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// PointMismatch describes an interval where two databases hold different values
+type PointMismatch struct {
+	Timestamp uint32   // Start of the interval
+	ValueA    *float64 // Value in the first database, nil if it has no data for the interval
+	ValueB    *float64 // Value in the second database, nil if it has no data for the interval
+}
+
+// ArchiveDiff holds the mismatched points of a single archive
+type ArchiveDiff struct {
+	Archive    int             // Index of the archive in the database header
+	Mismatches []PointMismatch // Mismatched points, sorted by timestamp
+}
+
+/*
+Diff compares the points of two databases between from and until, archive by archive, like whisper-diff.py.
+
+Both databases must have the same archive layout. An ArchiveDiff is returned for every archive, listing each
+interval where only one database has data or where the values differ. Values are compared bit for bit,
+so equal NaNs such as staleness markers match and NaNs with different bit patterns don't.
+*/
+func Diff(a, b *Whisper, from, until uint32) (diffs []ArchiveDiff, err error) {
+	defer lockPair(a, b, false)()
+
+	if !sameArchives(a.Header.Archives, b.Header.Archives) {
+		return nil, errors.New("databases have different archive layouts")
+	}
+	if from > until {
+		return nil, errors.New("from time is not less than until time")
+	}
+
+	now := uint32(time.Now().Unix())
+	for i, info := range a.Header.Archives {
+		valuesA, e := a.archiveValues(info, from, until, now)
+		if e != nil {
+			return nil, e
+		}
+		valuesB, e := b.archiveValues(b.Header.Archives[i], from, until, now)
+		if e != nil {
+			return nil, e
+		}
+
+		diff := ArchiveDiff{Archive: i}
+		for timestamp, valueA := range valuesA {
+			valueB, ok := valuesB[timestamp]
+			if !ok {
+				diff.Mismatches = append(diff.Mismatches, PointMismatch{timestamp, valuePointer(valueA), nil})
+			} else if math.Float64bits(valueA) != math.Float64bits(valueB) {
+				diff.Mismatches = append(diff.Mismatches, PointMismatch{timestamp, valuePointer(valueA), valuePointer(valueB)})
+			}
+		}
+		for timestamp, valueB := range valuesB {
+			if _, ok := valuesA[timestamp]; !ok {
+				diff.Mismatches = append(diff.Mismatches, PointMismatch{timestamp, nil, valuePointer(valueB)})
+			}
+		}
+		sort.Slice(diff.Mismatches, func(i, j int) bool {
+			return diff.Mismatches[i].Timestamp < diff.Mismatches[j].Timestamp
+		})
+		diffs = append(diffs, diff)
+	}
+	return
+}
+
+// Read the values of the live points of an archive between from and until, keyed by interval
+func (w *Whisper) archiveValues(info ArchiveInfo, from, until, now uint32) (values map[uint32]float64, err error) {
+	points, err := w.readArchive(info)
+	if err != nil {
+		return
+	}
+
+	values = make(map[uint32]float64)
+	for _, point := range livePoints(info, points, now) {
+		timestamp := quantizeTimestamp(point.Timestamp, info.SecondsPerPoint)
+		if timestamp >= from && timestamp <= until {
+			values[timestamp] = point.Value
+		}
+	}
+	return
+}
+
+func valuePointer(value float64) *float64 {
+	return &value
+}
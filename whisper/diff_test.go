@@ -0,0 +1,81 @@
+package whisper
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 10, 6}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	pathA := filepath.Join(dir, "a.wsp")
+	pathB := filepath.Join(dir, "b.wsp")
+	createWithPoints(t, pathA, info, archive{{now - 50, 1}, {now - 40, 2}, {now - 30, 3}, {now - 20, 4}})
+	createWithPoints(t, pathB, info, archive{{now - 40, 2}, {now - 30, 5}, {now - 20, 4}, {now - 10, 6}})
+
+	a, err := OpenReadOnly(pathA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := OpenReadOnly(pathB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	diffs, err := Diff(a, b, now-45, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 {
+		t.Fatalf("got %d archive diffs, want 1", len(diffs))
+	}
+
+	mismatches := diffs[0].Mismatches
+	if len(mismatches) != 2 {
+		t.Fatalf("got mismatches %v, want 2", mismatches)
+	}
+	if m := mismatches[0]; m.Timestamp != now-30 || *m.ValueA != 3 || *m.ValueB != 5 {
+		t.Errorf("mismatch 0 = {%d %v %v}", m.Timestamp, *m.ValueA, *m.ValueB)
+	}
+	if m := mismatches[1]; m.Timestamp != now-10 || m.ValueA != nil || *m.ValueB != 6 {
+		t.Errorf("mismatch 1 = {%d %v %v}", m.Timestamp, m.ValueA, m.ValueB)
+	}
+}
+
+func TestDiffNaN(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 10, 6}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	pathA := filepath.Join(dir, "a.wsp")
+	pathB := filepath.Join(dir, "b.wsp")
+	createWithPoints(t, pathA, info, archive{{now - 40, math.NaN()}, {now - 30, StaleNaN}, {now - 20, math.NaN()}})
+	createWithPoints(t, pathB, info, archive{{now - 40, math.NaN()}, {now - 30, StaleNaN}, {now - 20, StaleNaN}})
+
+	a, err := OpenReadOnly(pathA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := OpenReadOnly(pathB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	diffs, err := Diff(a, b, now-60, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches := diffs[0].Mismatches
+	if len(mismatches) != 1 {
+		t.Fatalf("got mismatches %v, want 1", mismatches)
+	}
+	if m := mismatches[0]; m.Timestamp != now-20 || !math.IsNaN(*m.ValueA) || IsStale(*m.ValueA) || !IsStale(*m.ValueB) {
+		t.Errorf("mismatch = {%d %v %v}", m.Timestamp, *m.ValueA, *m.ValueB)
+	}
+}
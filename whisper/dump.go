@@ -0,0 +1,180 @@
+package whisper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version of the dump format written by Dump.WriteTo
+const DUMP_VERSION = 1
+
+/*
+A Dump is the contents of a whisper database independent of how they are laid out in the file, so two
+databases holding the same data have equal dumps even if their ring buffers start at different slots.
+
+Dumps have a canonical text form, written by WriteTo and read by ParseDump, meant for golden files and
+for comparing databases written by different implementations with diff:
+
+	whisper-dump 1
+	aggregation-method average
+	x-files-factor 0.5
+	archive 60 1440
+	1200 1.5
+	1260 NaN
+	archive 3600 168
+
+The first line holds the version of the format. Each archive line gives the seconds per point and
+number of points of an archive, followed by its written points sorted by timestamp. Archives are
+listed from the highest precision down. Values are written in the shortest form that parses back to
+the same float64.
+*/
+type Dump struct {
+	AggregationMethod AggregationMethod
+	XFilesFactor      float32
+	Archives          []DumpArchive
+}
+
+// A DumpArchive holds the layout of an archive and its written points
+type DumpArchive struct {
+	SecondsPerPoint uint32
+	Points          uint32  // Number of slots in the archive
+	Data            []Point // Written points, sorted by timestamp
+}
+
+// Read the contents of the database into a Dump
+func (w *Whisper) Dump() (dump Dump, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	dump.AggregationMethod = w.Header.Metadata.AggregationMethod
+	dump.XFilesFactor = w.Header.Metadata.XFilesFactor
+	for _, info := range w.Header.Archives {
+		slots, e := w.readArchive(info)
+		if e != nil {
+			return dump, e
+		}
+		data := archive{}
+		for _, slot := range slots {
+			if slot.Timestamp != 0 {
+				data = append(data, slot)
+			}
+		}
+		sort.Stable(data)
+		dump.Archives = append(dump.Archives, DumpArchive{info.SecondsPerPoint, info.Points, data})
+	}
+	return
+}
+
+// Write the dump in its canonical text form. Implements io.WriterTo.
+func (d Dump) WriteTo(w io.Writer) (n int64, err error) {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "whisper-dump %d\n", DUMP_VERSION)
+	if method := d.AggregationMethod.String(); method != "unknown" {
+		fmt.Fprintf(&out, "aggregation-method %s\n", method)
+	} else {
+		fmt.Fprintf(&out, "aggregation-method %d\n", d.AggregationMethod)
+	}
+	fmt.Fprintf(&out, "x-files-factor %s\n", strconv.FormatFloat(float64(d.XFilesFactor), 'g', -1, 32))
+	for _, archive := range d.Archives {
+		fmt.Fprintf(&out, "archive %d %d\n", archive.SecondsPerPoint, archive.Points)
+		for _, point := range archive.Data {
+			fmt.Fprintf(&out, "%d %s\n", point.Timestamp, formatDumpValue(point.Value))
+		}
+	}
+	written, err := w.Write(out.Bytes())
+	return int64(written), err
+}
+
+// Format a value so it parses back to the same float64. Every NaN is written the same way.
+func formatDumpValue(value float64) string {
+	if math.IsNaN(value) {
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Parse a dump written by Dump.WriteTo
+func ParseDump(r io.Reader) (dump Dump, err error) {
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	fail := func(format string, args ...interface{}) error {
+		return errors.New(fmt.Sprintf("dump line %d: %s", lineNumber, fmt.Sprintf(format, args...)))
+	}
+
+	// The fields of the lines expected before the first archive, in order
+	expected := []string{"whisper-dump", "aggregation-method", "x-files-factor"}
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "archive" && len(fields) != 3 || fields[0] != "archive" && len(fields) != 2 {
+			return dump, fail("malformed line %q", scanner.Text())
+		}
+
+		if lineNumber <= len(expected) {
+			if fields[0] != expected[lineNumber-1] {
+				return dump, fail("expected %s, found %s", expected[lineNumber-1], fields[0])
+			}
+			switch fields[0] {
+			case "whisper-dump":
+				if fields[1] != strconv.Itoa(DUMP_VERSION) {
+					return dump, fail("unsupported dump version %s", fields[1])
+				}
+			case "aggregation-method":
+				dump.AggregationMethod, err = ParseAggregationMethod(fields[1])
+				if err != nil {
+					method, e := strconv.ParseUint(fields[1], 10, 32)
+					if e != nil {
+						return dump, fail("%s", err)
+					}
+					dump.AggregationMethod, err = AggregationMethod(method), nil
+				}
+			case "x-files-factor":
+				xff, e := strconv.ParseFloat(fields[1], 32)
+				if e != nil {
+					return dump, fail("invalid xFilesFactor %s", fields[1])
+				}
+				dump.XFilesFactor = float32(xff)
+			}
+			continue
+		}
+
+		if fields[0] == "archive" {
+			secondsPerPoint, e1 := strconv.ParseUint(fields[1], 10, 32)
+			points, e2 := strconv.ParseUint(fields[2], 10, 32)
+			if e1 != nil || e2 != nil {
+				return dump, fail("invalid archive %s %s", fields[1], fields[2])
+			}
+			dump.Archives = append(dump.Archives, DumpArchive{uint32(secondsPerPoint), uint32(points), nil})
+			continue
+		}
+
+		if len(dump.Archives) == 0 {
+			return dump, fail("point before the first archive")
+		}
+		timestamp, e := strconv.ParseUint(fields[0], 10, 32)
+		if e != nil {
+			return dump, fail("invalid timestamp %s", fields[0])
+		}
+		value, e := strconv.ParseFloat(fields[1], 64)
+		if e != nil {
+			return dump, fail("invalid value %s", fields[1])
+		}
+		archive := &dump.Archives[len(dump.Archives)-1]
+		if n := len(archive.Data); n > 0 && archive.Data[n-1].Timestamp > uint32(timestamp) {
+			return dump, fail("timestamp %d is out of order", timestamp)
+		}
+		archive.Data = append(archive.Data, Point{uint32(timestamp), value})
+	}
+	err = scanner.Err()
+	if err == nil && lineNumber < len(expected) {
+		err = errors.New(fmt.Sprintf("dump ends after %d lines, before its header", lineNumber))
+	}
+	return
+}
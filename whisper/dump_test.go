@@ -0,0 +1,101 @@
+package whisper
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	archives := []ArchiveInfo{{0, 60, 3}, {0, 180, 2}}
+	points := []Point{{1200, 1.5}, {1260, math.NaN()}, {1320, 3}}
+
+	// The same points written starting at different slots of the ring buffer
+	a, err := NewMemory(archives, 0.5, AGGREGATION_MAX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.writeArchive(a.Header.Archives[0], archive(points)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewMemory(archives, 0.5, AGGREGATION_MAX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.writeAt(b.Header.Archives[0].Offset, []Point{points[1], points[2], points[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `whisper-dump 1
+aggregation-method max
+x-files-factor 0.5
+archive 60 3
+1200 1.5
+1260 NaN
+1320 3
+archive 180 2
+`
+	for name, w := range map[string]*Whisper{"a": a, "b": b} {
+		dump, err := w.Dump()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		n, err := dump.WriteTo(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != expected {
+			t.Errorf("dump of %s =\n%s\nwant\n%s", name, out.String(), expected)
+		}
+		if n != int64(out.Len()) {
+			t.Errorf("WriteTo returned %d bytes, wrote %d", n, out.Len())
+		}
+
+		parsed, err := ParseDump(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var again bytes.Buffer
+		parsed.WriteTo(&again)
+		if again.String() != expected {
+			t.Errorf("dump of %s doesn't survive parsing, got\n%s", name, again.String())
+		}
+		if parsed.AggregationMethod != AGGREGATION_MAX || parsed.XFilesFactor != 0.5 || len(parsed.Archives) != 2 {
+			t.Errorf("parsed dump of %s = %+v", name, parsed)
+		}
+	}
+}
+
+func TestParseDumpUnknownMethod(t *testing.T) {
+	dump := Dump{AggregationMethod: 6, XFilesFactor: 0.1, Archives: []DumpArchive{{60, 10, []Point{{60, -2.5e-300}}}}}
+	var out bytes.Buffer
+	dump.WriteTo(&out)
+	parsed, err := ParseDump(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, dump) {
+		t.Errorf("parsed %+v, want %+v", parsed, dump)
+	}
+}
+
+func TestParseDumpErrors(t *testing.T) {
+	header := "whisper-dump 1\naggregation-method average\nx-files-factor 0.5\n"
+	for _, text := range []string{
+		"",
+		"whisper-dump 2\naggregation-method average\nx-files-factor 0.5\n",
+		"whisper-dump 1\nx-files-factor 0.5\n",
+		"whisper-dump 1\naggregation-method median\nx-files-factor 0.5\n",
+		header + "60 1\n",
+		header + "archive 60 10\n120 1\n60 2\n",
+		header + "archive 60 10\n120 one\n",
+		header + "archive 60\n",
+	} {
+		if _, err := ParseDump(strings.NewReader(text)); err == nil {
+			t.Errorf("no error parsing %q", text)
+		}
+	}
+}
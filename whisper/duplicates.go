@@ -0,0 +1,79 @@
+package whisper
+
+import (
+	"math"
+	"sort"
+)
+
+// DuplicatePolicy decides which value is written when several points given to one UpdateMany fall
+// in the same interval of an archive
+type DuplicatePolicy int
+
+// Valid duplicate policies
+const (
+	DUPLICATE_KEEP_LAST  DuplicatePolicy = iota // The point with the latest timestamp, or the last given of points with the same timestamp
+	DUPLICATE_KEEP_FIRST                        // The point with the earliest timestamp, or the first given of points with the same timestamp
+	DUPLICATE_AGGREGATE                         // The points aggregated with the database's aggregation method
+)
+
+// Set how points of a single UpdateMany that fall in the same interval are combined. Points already
+// in the database are always replaced, whatever the policy. The default is DUPLICATE_KEEP_LAST.
+//
+// DUPLICATE_AGGREGATE suits clients that submit several samples per interval, eg: statsd style
+// counters with AGGREGATION_SUM, which would otherwise lose all but one of the samples.
+func (w *Whisper) SetDuplicatePolicy(policy DuplicatePolicy) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.duplicatePolicy = policy
+}
+
+// Quantize points to an archive's intervals and combine the points in each interval according to
+// the handle's duplicate policy. Points with equal timestamps must be in the order they were given.
+// Returns one point per interval, sorted by timestamp.
+func (w *Whisper) mergeDuplicates(points archive, step uint32) (merged archive, err error) {
+	sorted := append(archive{}, points...)
+	sort.Stable(sorted)
+
+	for i := 0; i < len(sorted); {
+		interval := quantizeTimestamp(sorted[i].Timestamp, step)
+		j := i + 1
+		for j < len(sorted) && quantizeTimestamp(sorted[j].Timestamp, step) == interval {
+			j++
+		}
+		group := sorted[i:j]
+		i = j
+
+		var point Point
+		switch w.duplicatePolicy {
+		case DUPLICATE_KEEP_FIRST:
+			point = group[0]
+		case DUPLICATE_AGGREGATE:
+			point, err = w.aggregateDuplicates(group)
+			if err != nil {
+				return
+			}
+		default:
+			point = group[len(group)-1]
+		}
+		point.Timestamp = interval
+		merged = append(merged, point)
+	}
+	return
+}
+
+// Aggregate the points of one interval, treating non-finite values as when propagating
+func (w *Whisper) aggregateDuplicates(group []Point) (point Point, err error) {
+	if len(group) == 1 {
+		return group[0], nil
+	}
+	known, poisoned := filterNonFinite(w.aggregateNonFinite, group)
+	if len(known) == 0 {
+		// Only non-finite values, which are stored as given
+		return group[len(group)-1], nil
+	}
+	point, err = aggregate(w.Header.Metadata.AggregationMethod, known)
+	if poisoned {
+		point.Value = math.NaN()
+	}
+	return
+}
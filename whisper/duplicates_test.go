@@ -0,0 +1,69 @@
+package whisper
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDuplicatePolicy(t *testing.T) {
+	dir := t.TempDir()
+	now := uint32(time.Now().Unix())
+	interval := now - now%60 - 600
+	// Given out of order, with two samples at the same timestamp
+	points := []Point{{interval + 30, 3}, {interval + 10, 1}, {interval + 50, 4}, {interval + 10, 2}}
+
+	tests := []struct {
+		policy DuplicatePolicy
+		want   float64
+	}{
+		{DUPLICATE_KEEP_LAST, 4},
+		{DUPLICATE_KEEP_FIRST, 1},
+		{DUPLICATE_AGGREGATE, 10},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("%d.wsp", i))
+		if err := Create(path, []ArchiveInfo{{0, 60, 60}}, 0, AGGREGATION_SUM, false); err != nil {
+			t.Fatal(err)
+		}
+		w, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetDuplicatePolicy(test.policy)
+		if err := w.UpdateMany(points); err != nil {
+			t.Fatal(err)
+		}
+		point, found, err := w.ValueAt(interval)
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || point.Value != test.want {
+			t.Errorf("policy %d: got %v, found %v, want %v", test.policy, point.Value, found, test.want)
+		}
+	}
+}
+
+func TestDuplicatePolicySameTimestamp(t *testing.T) {
+	w := &Whisper{}
+	points := archive{{120, 1}, {120, 2}, {60, 5}, {120, 3}}
+
+	merged, err := w.mergeDuplicates(points, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != 2 || merged[0] != (Point{60, 5}) || merged[1] != (Point{120, 3}) {
+		t.Errorf("kept %v", merged)
+	}
+
+	w.duplicatePolicy = DUPLICATE_KEEP_FIRST
+	merged, err = w.mergeDuplicates(points, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != 2 || merged[1] != (Point{120, 1}) {
+		t.Errorf("kept %v", merged)
+	}
+}
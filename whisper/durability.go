@@ -0,0 +1,89 @@
+package whisper
+
+import (
+	"os"
+	"time"
+)
+
+// SyncPolicy decides when updates are flushed from the page cache to disk
+type SyncPolicy int
+
+// Valid sync policies
+const (
+	SYNC_NEVER    SyncPolicy = iota // Leave flushing to the kernel
+	SYNC_UPDATE                     // Flush after every Update and UpdateMany, so a returned update survives a crash
+	SYNC_PERIODIC                   // Flush in the background at a fixed interval if the database was updated since
+)
+
+/*
+Set when updates are flushed to disk. The default, SYNC_NEVER, gives the best throughput, but points
+written shortly before a crash of the host may be lost. SYNC_UPDATE loses nothing that was
+acknowledged at the cost of a flush per write, and SYNC_PERIODIC bounds the loss to interval.
+
+An error from a background flush is returned by the next update. Opening the database with
+OpenDataSync makes every write durable on its own, without flushes.
+*/
+func (w *Whisper) SetSyncPolicy(policy SyncPolicy, interval time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopSync != nil {
+		close(w.stopSync)
+		w.stopSync = nil
+	}
+	w.syncPolicy = policy
+	if policy == SYNC_PERIODIC {
+		w.stopSync = make(chan struct{})
+		go w.syncPeriodically(interval, w.stopSync)
+	}
+}
+
+// Flush the database to disk
+func (w *Whisper) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.dirty = false
+	return w.sync()
+}
+
+// Open a whisper database with O_DSYNC, so every write reaches the disk before it returns. Where
+// O_DSYNC isn't available O_SYNC is used.
+func OpenDataSync(path string) (whisper *Whisper, err error) {
+	return open(path, os.O_RDWR|oDSYNC)
+}
+
+// Apply the sync policy after an update, passing on an error from a background flush. Must be
+// called with the write lock held.
+func (w *Whisper) afterUpdate(err *error) {
+	if *err != nil {
+		return
+	}
+	switch w.syncPolicy {
+	case SYNC_UPDATE:
+		*err = w.sync()
+	case SYNC_PERIODIC:
+		w.dirty = true
+		*err, w.syncErr = w.syncErr, nil
+	}
+}
+
+// Flush the database every interval while it has unflushed updates, until stop is closed
+func (w *Whisper) syncPeriodically(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		w.mutex.Lock()
+		if w.dirty {
+			w.dirty = false
+			if err := w.sync(); err != nil {
+				w.syncErr = err
+			}
+		}
+		w.mutex.Unlock()
+	}
+}
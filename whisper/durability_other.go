@@ -0,0 +1,10 @@
+//go:build !unix
+
+package whisper
+
+import (
+	"os"
+)
+
+// O_DSYNC isn't available on this platform, so the stronger O_SYNC is used
+const oDSYNC = os.O_SYNC
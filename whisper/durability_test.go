@@ -0,0 +1,105 @@
+package whisper
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A MemoryStorage counting how often it is flushed
+type syncCounter struct {
+	*MemoryStorage
+	mutex sync.Mutex
+	syncs int
+	err   error
+}
+
+func (s *syncCounter) Sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.syncs++
+	return s.err
+}
+
+func (s *syncCounter) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.syncs
+}
+
+func newSyncCounter(t *testing.T) (*Whisper, *syncCounter) {
+	memory, err := NewMemory([]ArchiveInfo{{0, 60, 10}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := &syncCounter{MemoryStorage: memory.storage.(*MemoryStorage)}
+	w, err := OpenStorage(storage, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, storage
+}
+
+func TestSyncPolicy(t *testing.T) {
+	now := uint32(time.Now().Unix())
+	points := []Point{{now - 60, 1}}
+
+	w, storage := newSyncCounter(t)
+	if err := w.UpdateMany(points); err != nil {
+		t.Fatal(err)
+	}
+	if storage.count() != 0 {
+		t.Errorf("SYNC_NEVER flushed %d times", storage.count())
+	}
+
+	w.SetSyncPolicy(SYNC_UPDATE, 0)
+	if err := w.UpdateMany(points); err != nil {
+		t.Fatal(err)
+	}
+	if storage.count() != 1 {
+		t.Errorf("SYNC_UPDATE flushed %d times, want once", storage.count())
+	}
+
+	w.SetSyncPolicy(SYNC_PERIODIC, time.Millisecond)
+	storage.err = errors.New("disk on fire")
+	if err := w.UpdateMany(points); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for storage.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if storage.count() != 2 {
+		t.Fatalf("SYNC_PERIODIC flushed %d times, want once more", storage.count())
+	}
+	// Wait for the flush to finish and check nothing is flushed without updates
+	time.Sleep(10 * time.Millisecond)
+	if storage.count() != 2 {
+		t.Errorf("flushed without updates")
+	}
+	if err := w.UpdateMany(points); err != storage.err {
+		t.Errorf("update after a failed background flush returned %v, want %v", err, storage.err)
+	}
+	w.Close()
+}
+
+func TestOpenDataSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 10}}, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := OpenDataSync(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if !w.dataSync || w.ReadOnly() {
+		t.Errorf("dataSync = %v, ReadOnly = %v", w.dataSync, w.ReadOnly())
+	}
+	now := uint32(time.Now().Unix())
+	if err := w.UpdateMany([]Point{{now - 60, 1}}); err != nil {
+		t.Fatal(err)
+	}
+}
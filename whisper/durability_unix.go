@@ -0,0 +1,10 @@
+//go:build unix
+
+package whisper
+
+import (
+	"syscall"
+)
+
+// Flag making writes to a file durable on their own
+const oDSYNC = syscall.O_DSYNC
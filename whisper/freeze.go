@@ -0,0 +1,117 @@
+package whisper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Returns the path of the sidecar listing the frozen archives of the database at path
+func FrozenPath(path string) string {
+	return path + ".frozen"
+}
+
+/*
+FreezeArchive stops Update and UpdateMany from writing to an archive, whether directly or by
+propagating from a higher precision archive, so carefully reconciled historical data isn't
+overwritten by newly arriving points. Maintenance operations such as Merge, Fill and Resize still
+write frozen archives.
+
+Frozen archives are listed in a sidecar file next to the database rather than in its header, so the
+database stays readable by other whisper implementations.
+*/
+func (w *Whisper) FreezeArchive(index int) error {
+	return w.setFrozen(index, true)
+}
+
+// Allow writes to an archive frozen by FreezeArchive again
+func (w *Whisper) ThawArchive(index int) error {
+	return w.setFrozen(index, false)
+}
+
+// Returns the indexes of the frozen archives, in ascending order
+func (w *Whisper) FrozenArchives() (indexes []int) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	for i, info := range w.Header.Archives {
+		if w.frozen[info.Offset] {
+			indexes = append(indexes, i)
+		}
+	}
+	return
+}
+
+func (w *Whisper) setFrozen(index int, frozen bool) (err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.readOnly {
+		return w.errReadOnly()
+	}
+	if index < 0 || index >= len(w.Header.Archives) {
+		return errors.New(fmt.Sprintf("no archive %d", index))
+	}
+	if w.frozen == nil {
+		w.frozen = make(map[uint32]bool)
+	}
+	offset := w.Header.Archives[index].Offset
+	if w.frozen[offset] == frozen {
+		return
+	}
+	w.frozen[offset] = frozen
+	err = w.saveFrozen()
+	if err != nil {
+		w.frozen[offset] = !frozen
+	}
+	return
+}
+
+// Write the frozen archive sidecar, removing it if no archives are frozen
+func (w *Whisper) saveFrozen() error {
+	if w.path == "" {
+		// Not backed by a file, the frozen archives only live in the handle
+		return nil
+	}
+
+	var lines []string
+	for i, info := range w.Header.Archives {
+		if w.frozen[info.Offset] {
+			lines = append(lines, strconv.Itoa(i))
+		}
+	}
+	if len(lines) == 0 {
+		err := os.Remove(FrozenPath(w.path))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(FrozenPath(w.path), []byte(strings.Join(lines, "\n")+"\n"), 0666)
+}
+
+// Read the frozen archives of the database at path from its sidecar, if it has one
+func readFrozen(path string, header Header) (frozen map[uint32]bool, err error) {
+	file, err := os.Open(FrozenPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	frozen = make(map[uint32]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		index, e := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if e != nil || index < 0 || index >= len(header.Archives) {
+			return nil, errors.New(fmt.Sprintf("%s: invalid archive: %q", FrozenPath(path), scanner.Text()))
+		}
+		frozen[header.Archives[index].Offset] = true
+	}
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,76 @@
+package whisper
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFreezeArchive(t *testing.T) {
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 60)
+	write := func(frozen bool) (low archive) {
+		w, err := NewMemory([]ArchiveInfo{{0, 10, 360}, {0, 60, 1440}}, 0, AGGREGATION_AVERAGE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frozen {
+			if err := w.FreezeArchive(1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.UpdateMany([]Point{{now, 5}}); err != nil {
+			t.Fatal(err)
+		}
+		low, err = w.readArchive(w.Header.Archives[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return livePoints(w.Header.Archives[1], low, now)
+	}
+
+	if low := write(false); len(low) != 1 {
+		t.Fatalf("expected the point to propagate, got %v", low)
+	}
+	if low := write(true); len(low) != 0 {
+		t.Errorf("a point propagated into a frozen archive: %v", low)
+	}
+}
+
+func TestFrozenSidecar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metric.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 10, 360}, {0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.FreezeArchive(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.FreezeArchive(2); err == nil {
+		t.Error("froze an archive that doesn't exist")
+	}
+	w.Close()
+
+	w, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frozen := w.FrozenArchives(); len(frozen) != 1 || frozen[0] != 1 {
+		t.Errorf("FrozenArchives() = %v after reopening", frozen)
+	}
+	if err := w.ThawArchive(1); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	w, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if frozen := w.FrozenArchives(); len(frozen) != 0 {
+		t.Errorf("FrozenArchives() = %v after thawing", frozen)
+	}
+}
@@ -0,0 +1,84 @@
+package whisper
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// A HeaderCache opens databases without re-reading the header of files it has seen before.
+// Cached headers are keyed by path, modification time and size, so a file that has been
+// modified or replaced since is read again. A HeaderCache is safe for concurrent use.
+type HeaderCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]headerCacheEntry
+}
+
+type headerCacheEntry struct {
+	modTime time.Time
+	size    int64
+	header  Header
+}
+
+// Create a header cache holding at most maxEntries headers
+func NewHeaderCache(maxEntries int) *HeaderCache {
+	return &HeaderCache{maxEntries: maxEntries, entries: make(map[string]headerCacheEntry)}
+}
+
+// Like Open, but uses the cached header if the file hasn't changed
+func (c *HeaderCache) Open(path string) (*Whisper, error) {
+	return c.open(path, os.O_RDWR)
+}
+
+// Like OpenReadOnly, but uses the cached header if the file hasn't changed
+func (c *HeaderCache) OpenReadOnly(path string) (*Whisper, error) {
+	return c.open(path, os.O_RDONLY)
+}
+
+func (c *HeaderCache) open(path string, flag int) (*Whisper, error) {
+	return openWithHeader(path, flag, func(file *os.File) (header Header, err error) {
+		info, err := file.Stat()
+		if err != nil {
+			return
+		}
+		header, ok := c.get(path, info)
+		if ok {
+			return header, nil
+		}
+		header, err = ReadHeader(file)
+		if err == nil {
+			c.put(path, info, header)
+		}
+		return
+	})
+}
+
+// Look up the header of a file, returning false if it isn't cached or the file has changed
+func (c *HeaderCache) get(path string, info os.FileInfo) (header Header, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[path]
+	if !ok || !entry.modTime.Equal(info.ModTime()) || entry.size != info.Size() {
+		return header, false
+	}
+	header = entry.header
+	header.Archives = append([]ArchiveInfo(nil), entry.header.Archives...)
+	return header, true
+}
+
+func (c *HeaderCache) put(path string, info os.FileInfo, header Header) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.entries[path]; !ok && len(c.entries) >= c.maxEntries {
+		// Make room by dropping an arbitrary entry
+		for evicted := range c.entries {
+			delete(c.entries, evicted)
+			break
+		}
+	}
+	header.Archives = append([]ArchiveInfo(nil), header.Archives...)
+	c.entries[path] = headerCacheEntry{info.ModTime(), info.Size(), header}
+}
@@ -0,0 +1,151 @@
+package whisper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHeaderCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewHeaderCache(1)
+
+	open := func() *Whisper {
+		w, err := cache.OpenReadOnly(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		return w
+	}
+
+	open()
+	if len(cache.entries) != 1 {
+		t.Fatalf("header not cached")
+	}
+
+	// Modifying the file invalidates the entry
+	w, err := cache.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.SetAggregationMethod(AGGREGATION_MAX)
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	if w := open(); w.Header.Metadata.AggregationMethod != AGGREGATION_MAX {
+		t.Errorf("header not re-read after modification: %+v", w.Header.Metadata)
+	}
+
+	// The cached entry is served as is while the file is unchanged
+	entry := cache.entries[path]
+	entry.header.Metadata.XFilesFactor = 0.25
+	cache.entries[path] = entry
+	if w := open(); w.Header.Metadata.XFilesFactor != 0.25 {
+		t.Errorf("cached header not used")
+	}
+
+	// The cache is bounded
+	other := filepath.Join(filepath.Dir(path), "other.wsp")
+	if err := Create(other, []ArchiveInfo{{0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	if w, err := cache.OpenReadOnly(other); err == nil {
+		w.Close()
+	}
+	if len(cache.entries) != 1 {
+		t.Errorf("cache has %d entries, want 1", len(cache.entries))
+	}
+}
+
+func TestHeaderCacheFrozenArchives(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 10, 360}, {0, 60, 1440}}, 0, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.FreezeArchive(1)
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewHeaderCache(1)
+	for i := 0; i < 2; i++ {
+		// Both when the header is read and when it is cached
+		w, err := cache.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frozen := w.FrozenArchives(); len(frozen) != 1 || frozen[0] != 1 {
+			t.Errorf("FrozenArchives() = %v through the cache", frozen)
+		}
+		now := quantizeTimestamp(uint32(time.Now().Unix()), 60)
+		if err := w.UpdateMany([]Point{{now, 5}}); err != nil {
+			t.Fatal(err)
+		}
+		low, err := w.readArchive(w.Header.Archives[1])
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if live := livePoints(w.Header.Archives[1], low, now); len(live) != 0 {
+			t.Errorf("frozen archive was written through the cache: %v", live)
+		}
+	}
+}
+
+func TestHeaderCacheReplaysJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 20}, {0, 300, 10}}, 0, AGGREGATION_SUM, false); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewHeaderCache(1)
+	w, err := cache.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash after writing the points, before propagating them
+	now := uint32(time.Now().Unix())
+	base := now - now%300 - 600
+	points := []Point{{base, 1}, {base + 60, 2}}
+	w.SetJournaling(true)
+	if err := w.beginJournal(points); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeArchive(w.Header.Archives[0], archive(points)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// A read-only open caches the header and leaves the journal, which opening with the cached
+	// header replays
+	readOnly, err := cache.OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly.Close()
+	replayed, err := cache.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+	if _, err := os.Stat(JournalPath(path)); !os.IsNotExist(err) {
+		t.Errorf("journal left behind after opening through the cache: %v", err)
+	}
+	point, found, err := replayed.LatestIn(replayed.Header.Archives[1])
+	if err != nil || !found || point != (Point{base, 3}) {
+		t.Errorf("got %v, %v, %v from the lower archive, want the propagated sum", point, found, err)
+	}
+}
@@ -0,0 +1,183 @@
+package whisper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// An Anomaly is a problem found in a database by CheckIntegrity
+type Anomaly struct {
+	Offset  int64  // Offset of the problem in bytes from the start of the file
+	Problem string // Description of the problem
+}
+
+func (a Anomaly) String() string {
+	return fmt.Sprintf("offset %d: %s", a.Offset, a.Problem)
+}
+
+/*
+CheckIntegrity checks the whisper database at path for the damage left behind by crashes, power loss
+or bad copies, like fsck does for a filesystem.
+
+The metadata is validated and the archive table checked against the file: every archive must lie
+after the header, inside the file and apart from the other archives, and the file must end with the
+last archive. Every written slot must hold a timestamp that is a multiple of its archive's step, isn't
+in the future and belongs in that slot of the ring buffer given the archive's base point.
+
+Problems are returned as anomalies with the offset they were found at. An error is only returned if
+the file can't be read.
+*/
+func CheckIntegrity(path string) (anomalies []Anomaly, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	return checkIntegrity(file, info.Size(), uint32(time.Now().Unix()))
+}
+
+func checkIntegrity(r io.ReaderAt, size int64, now uint32) (anomalies []Anomaly, err error) {
+	report := func(offset int64, format string, args ...interface{}) {
+		anomalies = append(anomalies, Anomaly{offset, fmt.Sprintf(format, args...)})
+	}
+
+	if size < int64(metadataSize) {
+		report(0, "file is %d bytes, too small to hold the metadata", size)
+		return
+	}
+	var metadata Metadata
+	err = readFrom(r, 0, &metadata)
+	if err != nil {
+		return
+	}
+	if metadata.AggregationMethod.String() == "unknown" {
+		report(aggregationMethodOffset, "unknown aggregation method %d", metadata.AggregationMethod)
+	}
+	if !(metadata.XFilesFactor >= 0 && metadata.XFilesFactor <= 1) {
+		report(xFilesFactorOffset, "xFilesFactor %g isn't between 0 and 1", metadata.XFilesFactor)
+	}
+
+	headerEnd := int64(metadataSize) + int64(metadata.ArchiveCount)*int64(archiveSize)
+	if metadata.ArchiveCount == 0 {
+		report(archiveCountOffset, "there are no archives")
+		return
+	}
+	if headerEnd > size {
+		report(archiveCountOffset, "%d archives need a %d byte header, but the file is %d bytes", metadata.ArchiveCount, headerEnd, size)
+		return
+	}
+	archives := make([]ArchiveInfo, metadata.ArchiveCount)
+	err = readFrom(r, int64(metadataSize), archives)
+	if err != nil {
+		return
+	}
+
+	// Check the archive table
+	var maxRetention uint32
+	end := headerEnd
+	readable := make([]bool, len(archives))
+	for i, info := range archives {
+		infoOffset := int64(metadataSize) + int64(i)*int64(archiveSize)
+		if info.SecondsPerPoint == 0 || info.Points == 0 {
+			report(infoOffset, "archive %d has %d points every %d seconds", i, info.Points, info.SecondsPerPoint)
+			continue
+		}
+		if info.Retention() > maxRetention {
+			maxRetention = info.Retention()
+		}
+		archiveEnd := int64(info.Offset) + int64(info.Points)*int64(pointSize)
+		if archiveEnd > end {
+			end = archiveEnd
+		}
+		switch {
+		case int64(info.Offset) < headerEnd:
+			report(infoOffset, "archive %d starts at %d, inside the header", i, info.Offset)
+		case archiveEnd > size:
+			report(infoOffset, "archive %d ends at %d, past the end of the file at %d", i, archiveEnd, size)
+		default:
+			readable[i] = true
+		}
+	}
+	if metadata.MaxRetention != maxRetention {
+		report(maxRetentionOffset, "max retention is %d, but the archives retain %d seconds", metadata.MaxRetention, maxRetention)
+	}
+	err = ValidateArchiveList(append([]ArchiveInfo(nil), archives...))
+	if err != nil {
+		report(int64(metadataSize), "invalid archive list: %s", err)
+		err = nil
+	}
+	if size > end {
+		report(end, "%d bytes follow the last archive", size-end)
+	}
+
+	// Check that no two archives share any bytes
+	byOffset := make([]int, len(archives))
+	for i := range byOffset {
+		byOffset[i] = i
+	}
+	sort.Slice(byOffset, func(i, j int) bool { return archives[byOffset[i]].Offset < archives[byOffset[j]].Offset })
+	for k := 1; k < len(byOffset); k++ {
+		previous, current := archives[byOffset[k-1]], archives[byOffset[k]]
+		if previous.Points > 0 && current.Offset < previous.end() {
+			report(int64(current.Offset), "archive %d overlaps archive %d", byOffset[k], byOffset[k-1])
+		}
+	}
+
+	// Check the slots of every archive that is inside the file
+	for i, info := range archives {
+		if !readable[i] {
+			continue
+		}
+		slots := make([]Point, info.Points)
+		err = readFrom(r, int64(info.Offset), slots)
+		if err != nil {
+			return
+		}
+
+		step := info.SecondsPerPoint
+		base := slots[0].Timestamp
+		if base%step != 0 {
+			// The positions of the other points can't be checked without a valid base
+			base = 0
+		}
+		reportedEmptyBase := false
+		for j, slot := range slots {
+			offset := info.Offset + uint32(j)*pointSize
+			switch {
+			case slot.Timestamp == 0:
+			case slot.Timestamp%step != 0:
+				report(int64(offset), "timestamp %d of archive %d isn't a multiple of its %d second step", slot.Timestamp, i, step)
+			case slot.Timestamp > now+step:
+				report(int64(offset), "timestamp %d of archive %d is in the future", slot.Timestamp, i)
+			case slots[0].Timestamp == 0:
+				if !reportedEmptyBase {
+					report(int64(info.Offset), "archive %d holds points but its first slot is empty", i)
+					reportedEmptyBase = true
+				}
+			case base != 0 && slotOffset(info, base, slot.Timestamp) != offset:
+				report(int64(offset), "timestamp %d of archive %d belongs in the slot at offset %d", slot.Timestamp, i, slotOffset(info, base, slot.Timestamp))
+			}
+		}
+	}
+	return
+}
+
+// Read big endian encoded data from an offset of a reader
+func readFrom(r io.ReaderAt, offset int64, data interface{}) (err error) {
+	buf := make([]byte, binary.Size(data))
+	_, err = r.ReadAt(buf, offset)
+	if err != nil {
+		return
+	}
+	_, err = binary.Decode(buf, binary.BigEndian, data)
+	return
+}
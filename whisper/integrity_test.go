@@ -0,0 +1,73 @@
+package whisper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 60, 10}
+	points := archive{{600, 1}, {660, 2}, {720, 3}}
+	path := filepath.Join(dir, "test.wsp")
+	createWithPoints(t, path, info, points)
+
+	anomalies, err := CheckIntegrity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) != 0 {
+		t.Errorf("healthy database has anomalies %v", anomalies)
+	}
+
+	// Damage a copy of the database in several places
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := OpenHeaderOnly(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := header.Archives[0].Offset
+	binary.BigEndian.PutUint32(data[xFilesFactorOffset:], math.Float32bits(2))
+	binary.BigEndian.PutUint32(data[offset+pointSize:], 661)   // Not a multiple of the step
+	binary.BigEndian.PutUint32(data[offset+2*pointSize:], 780) // Belongs in the slot after
+	data = append(data, 0, 0, 0)
+	damaged := filepath.Join(dir, "damaged.wsp")
+	if err := os.WriteFile(damaged, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	anomalies, err = CheckIntegrity(damaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int64{xFilesFactorOffset, int64(header.end()), int64(offset + pointSize), int64(offset + 2*pointSize)}
+	if len(anomalies) != len(expected) {
+		t.Fatalf("got anomalies %v, want %d", anomalies, len(expected))
+	}
+	for i, anomaly := range anomalies {
+		if anomaly.Offset != expected[i] {
+			t.Errorf("anomaly %v, want one at offset %d", anomaly, expected[i])
+		}
+	}
+
+	// A file cut off in the middle of its archive
+	truncated := filepath.Join(dir, "truncated.wsp")
+	if err := os.WriteFile(truncated, data[:offset+pointSize], 0666); err != nil {
+		t.Fatal(err)
+	}
+	anomalies, err = CheckIntegrity(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) == 0 || !strings.Contains(anomalies[len(anomalies)-1].Problem, "past the end of the file") {
+		t.Errorf("truncated file has anomalies %v", anomalies)
+	}
+}
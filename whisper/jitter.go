@@ -0,0 +1,32 @@
+package whisper
+
+// Set how many seconds early a point may arrive and still be counted in the next interval.
+//
+// Collectors with clock skew report points slightly before the interval they are meant for, which
+// quantization puts in the previous interval, leaving a gap and a doubled write. With a tolerance
+// set, points that fall within tolerance seconds before an interval boundary of the highest
+// precision archive are moved onto the boundary. A tolerance of 0, the default, disables snapping.
+func (w *Whisper) SetJitterTolerance(tolerance uint32) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.jitterTolerance = tolerance
+}
+
+// Returns the points with their timestamps snapped to the grid of the highest precision archive,
+// according to the handle's jitter tolerance. The points passed in are not modified.
+func (w *Whisper) snapTimestamps(points []Point) []Point {
+	if w.jitterTolerance == 0 || len(w.Header.Archives) == 0 {
+		return points
+	}
+
+	step := w.Header.Archives[0].SecondsPerPoint
+	snapped := make([]Point, len(points))
+	for i, point := range points {
+		early := step - point.Timestamp%step
+		if early < step && early <= w.jitterTolerance {
+			point.Timestamp += early
+		}
+		snapped[i] = point
+	}
+	return snapped
+}
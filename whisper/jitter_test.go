@@ -0,0 +1,26 @@
+package whisper
+
+import "testing"
+
+func TestSnapTimestamps(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := []Point{{118, 1}, {119, 2}, {120, 3}, {122, 4}, {100, 5}}
+
+	if snapped := w.snapTimestamps(points); &snapped[0] != &points[0] {
+		t.Error("expected no snapping without a tolerance")
+	}
+
+	w.SetJitterTolerance(2)
+	snapped := w.snapTimestamps(points)
+	for i, expected := range []uint32{120, 120, 120, 122, 100} {
+		if snapped[i].Timestamp != expected {
+			t.Errorf("point %v snapped to %d, want %d", points[i], snapped[i].Timestamp, expected)
+		}
+	}
+	if points[0].Timestamp != 118 {
+		t.Error("the points passed in were modified")
+	}
+}
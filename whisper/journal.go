@@ -0,0 +1,134 @@
+package whisper
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+)
+
+// Returns the path of the journal of the database at path
+func JournalPath(path string) string {
+	return path + ".journal"
+}
+
+/*
+Set whether updates are journaled, so a crash between writing points and propagating them to the
+lower precision archives can't leave the archives disagreeing.
+
+Before each update the timestamps of its points are appended to the journal at JournalPath and
+flushed to disk. Once the update has been written and flushed the journal is removed. If a journal
+is left behind, the next Open for writing propagates its timestamps again before returning. Replaying
+is safe whether or not the points themselves reached the disk, as the lower precision archives are
+recomputed from whatever the higher precision archives hold.
+
+Journaling costs two flushes per update and only protects updates made through journaling handles.
+It only applies to databases opened from a path.
+*/
+func (w *Whisper) SetJournaling(enabled bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.journaling = enabled && w.path != ""
+}
+
+// Record the timestamps of points about to be written in the journal. Must be called with the write
+// lock held.
+func (w *Whisper) beginJournal(points []Point) (err error) {
+	if !w.journaling {
+		return
+	}
+	// A record is a count, the timestamps and a checksum of both, so a torn record can be detected
+	record := make([]byte, 4+4*len(points), 8+4*len(points))
+	binary.BigEndian.PutUint32(record, uint32(len(points)))
+	for i, point := range points {
+		binary.BigEndian.PutUint32(record[4+4*i:], point.Timestamp)
+	}
+	record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(record))
+
+	file, err := os.OpenFile(JournalPath(w.path), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return
+	}
+	_, err = file.Write(record)
+	if err == nil {
+		err = file.Sync()
+	}
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+// Remove the journal once an update has been written, flushing the update first. If the update
+// failed the journal is kept for the next Open to replay. Must be called with the write lock held.
+func (w *Whisper) endJournal(err *error) {
+	if !w.journaling {
+		return
+	}
+	if *err != nil {
+		w.journalPending = true
+	}
+	if w.journalPending {
+		return
+	}
+	*err = w.sync()
+	if *err == nil {
+		*err = os.Remove(JournalPath(w.path))
+	}
+}
+
+// Propagate the timestamps recorded in a journal left behind by an interrupted update, then remove
+// the journal. Records torn by the interruption are ignored, as their updates never started.
+func (w *Whisper) replayJournal() (err error) {
+	data, err := os.ReadFile(JournalPath(w.path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	err = w.beginWrite()
+	if err != nil {
+		return
+	}
+	defer w.endWrite()
+
+	for len(data) >= 8 {
+		count := binary.BigEndian.Uint32(data)
+		size := 8 + 4*int64(count)
+		if size > int64(len(data)) {
+			break
+		}
+		record := data[:size-4]
+		if binary.BigEndian.Uint32(data[size-4:]) != crc32.ChecksumIEEE(record) {
+			break
+		}
+		for i := uint32(0); i < count; i++ {
+			timestamp := binary.BigEndian.Uint32(record[4+4*i:])
+			err = w.repropagate(timestamp)
+			if err != nil {
+				return
+			}
+		}
+		data = data[size:]
+	}
+
+	err = w.sync()
+	if err != nil {
+		return
+	}
+	return os.Remove(JournalPath(w.path))
+}
+
+// Recompute the intervals holding a timestamp in every lower precision archive from the archive above
+func (w *Whisper) repropagate(timestamp uint32) (err error) {
+	// Each pair is tried even if nothing was propagated above it, as the point may have been
+	// written to a lower precision archive directly
+	for i := 0; i+1 < len(w.Header.Archives); i++ {
+		_, err = w.propagate(timestamp, w.Header.Archives[i], w.Header.Archives[i+1])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,93 @@
+package whisper
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	archives := []ArchiveInfo{{0, 60, 20}, {0, 300, 10}}
+	now := uint32(time.Now().Unix())
+	base := now - now%300 - 600
+	points := []Point{{base, 1}, {base + 60, 2}, {base + 120, 3}, {base + 300, 4}}
+
+	open := func(name string) *Whisper {
+		path := filepath.Join(dir, name)
+		if err := Create(path, archives, 0, AGGREGATION_SUM, false); err != nil {
+			t.Fatal(err)
+		}
+		w, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	// A journaled update leaves no journal behind
+	updated := open("updated.wsp")
+	defer updated.Close()
+	updated.SetJournaling(true)
+	if err := updated.UpdateMany(points); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(JournalPath(updated.Path())); !os.IsNotExist(err) {
+		t.Errorf("journal left behind after a successful update: %v", err)
+	}
+
+	// Crash after writing the points, before propagating them
+	crashed := open("crashed.wsp")
+	crashed.SetJournaling(true)
+	if err := crashed.beginJournal(points); err != nil {
+		t.Fatal(err)
+	}
+	if err := crashed.writeArchive(crashed.Header.Archives[0], archive(points)); err != nil {
+		t.Fatal(err)
+	}
+	crashed.Close()
+
+	// A record torn by a crash while writing the journal is ignored
+	journal, err := os.OpenFile(JournalPath(crashed.Path()), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.Write([]byte{0, 0, 0, 9, 1, 2})
+	journal.Close()
+
+	// Read-only handles leave the journal alone
+	readOnly, err := OpenReadOnly(crashed.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly.Close()
+	if _, err := os.Stat(JournalPath(crashed.Path())); err != nil {
+		t.Fatalf("journal removed by a read-only open: %v", err)
+	}
+
+	replayed, err := Open(crashed.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+	if _, err := os.Stat(JournalPath(crashed.Path())); !os.IsNotExist(err) {
+		t.Errorf("journal left behind after replaying: %v", err)
+	}
+
+	want, err := updated.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := replayed.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want.Archives[1].Data) == 0 {
+		t.Fatal("update wasn't propagated")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed database holds %+v, want %+v", got, want)
+	}
+}
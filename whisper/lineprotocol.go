@@ -0,0 +1,68 @@
+package whisper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Escapes the characters with a meaning in measurements and in tag keys and values of line protocol
+var measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+var tagEscaper = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+
+/*
+Write the values between two timestamps to out in the line protocol of InfluxDB, which VictoriaMetrics
+also accepts, with a line for each interval that has a value:
+
+	cpu,host=web1 value=1.5 1200000000000
+
+Each line holds the measurement, the tags sorted by key, the value as the field "value" and the
+timestamp in nanoseconds, the protocol's default precision. Missing values are skipped, as are NaN and
+infinite values, which the protocol can't represent.
+*/
+func (w *Whisper) ExportLineProtocol(out io.Writer, measurement string, tags map[string]string, from, until uint32) (err error) {
+	if measurement == "" {
+		return errors.New("measurement must not be empty")
+	}
+	keys := make([]string, 0, len(tags))
+	for key, value := range tags {
+		if key == "" || value == "" {
+			return errors.New(fmt.Sprintf("tag %q=%q must have a key and a value", key, value))
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Every line starts with the same series key
+	prefix := measurementEscaper.Replace(measurement)
+	for _, key := range keys {
+		prefix += "," + tagEscaper.Replace(key) + "=" + tagEscaper.Replace(tags[key])
+	}
+	prefix += " value="
+
+	series, err := w.FetchSeries(from, until)
+	if err != nil {
+		return
+	}
+
+	buf := bufio.NewWriter(out)
+	line := []byte{}
+	for _, point := range series.points() {
+		if !isFinite(point.Value) {
+			continue
+		}
+		line = append(line[:0], prefix...)
+		line = strconv.AppendFloat(line, point.Value, 'g', -1, 64)
+		line = append(line, ' ')
+		line = strconv.AppendInt(line, int64(point.Timestamp)*1e9, 10)
+		line = append(line, '\n')
+		if _, err = buf.Write(line); err != nil {
+			return
+		}
+	}
+	return buf.Flush()
+}
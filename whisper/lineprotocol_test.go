@@ -0,0 +1,40 @@
+package whisper
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestExportLineProtocol(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{SecondsPerPoint: 60, Points: 10}}, 0, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	start := now - now%60 - 240
+	err = w.UpdateMany([]Point{{start, 1.5}, {start + 60, math.NaN()}, {start + 180, -2e+21}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	tags := map[string]string{"host": "web 1", "dc": "a,b=c"}
+	if err := w.ExportLineProtocol(&out, "cpu load", tags, start-1, start+240); err != nil {
+		t.Fatal(err)
+	}
+	expected := `cpu\ load,dc=a\,b\=c,host=web\ 1 value=1.5 ` + fmt.Sprint(start) + "000000000\n" +
+		`cpu\ load,dc=a\,b\=c,host=web\ 1 value=-2e+21 ` + fmt.Sprint(start+180) + "000000000\n"
+	if out.String() != expected {
+		t.Errorf("exported\n%s\nwant\n%s", out.String(), expected)
+	}
+
+	if err := w.ExportLineProtocol(&out, "", nil, start, now); err == nil {
+		t.Error("exported with an empty measurement")
+	}
+	if err := w.ExportLineProtocol(&out, "cpu", map[string]string{"host": ""}, start, now); err == nil {
+		t.Error("exported with an empty tag value")
+	}
+}
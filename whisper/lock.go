@@ -0,0 +1,32 @@
+package whisper
+
+import (
+	"os"
+)
+
+// A MaintenanceLock gives a maintenance operation exclusive ownership of a database.
+// While it is held, writes through any handle to the database fail with ErrMaintenanceInProgress.
+type MaintenanceLock struct {
+	file *os.File
+}
+
+// Lock the database at path for maintenance. Waits for writes in progress to finish.
+func LockForMaintenance(path string) (lock *MaintenanceLock, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	err = lockExclusive(file)
+	if err != nil {
+		file.Close()
+		return
+	}
+	lock = &MaintenanceLock{file}
+	return
+}
+
+// Release the lock
+func (l *MaintenanceLock) Unlock() error {
+	unlockFile(l.file)
+	return l.file.Close()
+}
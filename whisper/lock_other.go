@@ -0,0 +1,21 @@
+//go:build !unix
+
+package whisper
+
+import (
+	"os"
+)
+
+// File locking is not supported on this platform, so maintenance locks have no effect
+
+func lockShared(file *os.File) error {
+	return nil
+}
+
+func lockExclusive(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,105 @@
+//go:build unix
+
+package whisper
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	lock, err := LockForMaintenance(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetAggregationMethod(AGGREGATION_SUM); err != ErrMaintenanceInProgress {
+		t.Errorf("write during maintenance: got %v, want %v", err, ErrMaintenanceInProgress)
+	}
+	if err := w.UpdateMany([]Point{{0, 1}}); err != ErrMaintenanceInProgress {
+		t.Errorf("UpdateMany during maintenance: got %v, want %v", err, ErrMaintenanceInProgress)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetAggregationMethod(AGGREGATION_SUM); err != nil {
+		t.Errorf("write after maintenance: %v", err)
+	}
+}
+
+func TestWriteAfterResize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { w.Close() }()
+
+	if err := Resize(path, []ArchiveInfo{{0, 60, 100}}, ResizeOptions{NoBackup: true}); err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	if err := w.Update(Point{now - 60, 1}); err != ErrReplaced {
+		t.Fatalf("Update through a replaced handle: got %v, want %v", err, ErrReplaced)
+	}
+
+	if _, err := w.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Update(Point{now - 60, 1}); err != nil {
+		t.Fatal(err)
+	}
+	points, err := w.FetchLast(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) == 0 || points[0].Value != 1 {
+		t.Errorf("update after refresh not stored: %v", points)
+	}
+}
+
+func TestMaintenanceWaitsForMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := OpenMapped(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan *MaintenanceLock)
+	go func() {
+		lock, err := LockForMaintenance(path)
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- lock
+	}()
+	select {
+	case <-locked:
+		t.Fatal("maintenance started while the database was mapped")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	w.Close()
+	lock := <-locked
+	defer lock.Unlock()
+	if _, err := OpenMapped(path); err != ErrMaintenanceInProgress {
+		t.Errorf("OpenMapped during maintenance: got %v, want %v", err, ErrMaintenanceInProgress)
+	}
+}
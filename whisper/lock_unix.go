@@ -0,0 +1,27 @@
+//go:build unix
+
+package whisper
+
+import (
+	"os"
+	"syscall"
+)
+
+// Take a shared lock on a file, failing with ErrMaintenanceInProgress if it is locked exclusively
+func lockShared(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrMaintenanceInProgress
+	}
+	return err
+}
+
+// Take an exclusive lock on a file, waiting for any shared locks to be released
+func lockExclusive(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+// Release a lock on a file
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
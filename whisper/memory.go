@@ -0,0 +1,94 @@
+package whisper
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// MemoryStorage is a Storage that keeps a database in a byte slice
+type MemoryStorage struct {
+	mutex sync.RWMutex
+	data  []byte
+}
+
+// Create a MemoryStorage holding the given bytes, such as the contents of a whisper file
+func NewMemoryStorage(data []byte) *MemoryStorage {
+	return &MemoryStorage{data: data}
+}
+
+// Create a new whisper database held entirely in memory
+func NewMemory(archives []ArchiveInfo, xFilesFactor float32, aggregationMethod AggregationMethod) (*Whisper, error) {
+	return CreateStorage(NewMemoryStorage(nil), archives, xFilesFactor, aggregationMethod)
+}
+
+func (m *MemoryStorage) ReadAt(p []byte, off int64) (n int, err error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n = copy(p, m.data[off:])
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+func (m *MemoryStorage) WriteAt(p []byte, off int64) (n int, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if end := off + int64(len(p)); end > int64(len(m.data)) {
+		m.grow(end)
+	}
+	n = copy(m.data[off:], p)
+	return
+}
+
+func (m *MemoryStorage) Truncate(size int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	if size > int64(len(m.data)) {
+		m.grow(size)
+	} else {
+		m.data = m.data[:size]
+	}
+	return nil
+}
+
+// Closing a MemoryStorage keeps its data available through Bytes
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+// Returns a copy of the stored bytes
+func (m *MemoryStorage) Bytes() []byte {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]byte(nil), m.data...)
+}
+
+func (m *MemoryStorage) size() int64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return int64(len(m.data))
+}
+
+// Extend the data to size bytes, zero filling the new space
+func (m *MemoryStorage) grow(size int64) {
+	data := make([]byte, size)
+	copy(data, m.data)
+	m.data = data
+}
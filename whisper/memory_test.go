@@ -0,0 +1,65 @@
+package whisper
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewMemory(t *testing.T) {
+	archives := []ArchiveInfo{{0, 60, 1440}, {0, 3600, 168}}
+	w, err := NewMemory(archives, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	points := archive{{3600, 1}, {3660, 2}}
+	if err := w.writeArchive(w.Header.Archives[0], points); err != nil {
+		t.Fatal(err)
+	}
+	read, err := w.readArchive(w.Header.Archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read[0] != points[0] || read[1] != points[1] {
+		t.Errorf("read back %v, want %v", read[:2], points)
+	}
+
+	// The in-memory layout matches a database created on disk
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, archives, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	onDisk, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := NewMemory(archives, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(empty.storage.(*MemoryStorage).Bytes(), onDisk) {
+		t.Errorf("in-memory database differs from the one created on disk")
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	m := NewMemoryStorage([]byte("abc"))
+	if _, err := m.WriteAt([]byte("xy"), 5); err != nil {
+		t.Fatal(err)
+	}
+	if b := m.Bytes(); !bytes.Equal(b, []byte("abc\x00\x00xy")) {
+		t.Errorf("Bytes() = %q", b)
+	}
+
+	buf := make([]byte, 4)
+	if n, err := m.ReadAt(buf, 4); n != 3 || err == nil {
+		t.Errorf("short ReadAt = %d, %v", n, err)
+	}
+
+	m.Truncate(2)
+	if b := m.Bytes(); !bytes.Equal(b, []byte("ab")) {
+		t.Errorf("Bytes() after Truncate = %q", b)
+	}
+}
@@ -0,0 +1,191 @@
+package whisper
+
+import (
+	"errors"
+	"time"
+)
+
+// MergeOptions controls optional behaviour of Merge and Fill
+type MergeOptions struct {
+	Provenance *ProvenanceLog // If set, the source of every interval written to dst is recorded here
+	From       uint32         // Only copy points of src at or after this time
+	Until      uint32         // Only copy points of src before this time, unless 0
+	DryRun     bool           // Count the points that would be copied without changing dst
+}
+
+// Returns true if a point of src is within the window the options allow copying
+func (opts MergeOptions) inWindow(point Point) bool {
+	return point.Timestamp >= opts.From && (opts.Until == 0 || point.Timestamp < opts.Until)
+}
+
+/*
+Merge copies the points of src into dst archive by archive, like whisper-merge.py.
+
+Both databases must have the same archive layout. Points which already exist in dst are kept;
+src only supplies the intervals dst has no data for.
+*/
+func Merge(src, dst *Whisper) (err error) {
+	_, err = MergeWithOptions(src, dst, MergeOptions{})
+	return
+}
+
+// MergeWithOptions is like Merge but accepts options. Returns the number of points copied from src.
+func MergeWithOptions(src, dst *Whisper, opts MergeOptions) (copied int, err error) {
+	defer lockPair(src, dst, !opts.DryRun)()
+
+	if !opts.DryRun {
+		err = dst.beginWrite()
+		if err != nil {
+			return
+		}
+		defer dst.endWrite()
+	}
+
+	if !sameArchives(src.Header.Archives, dst.Header.Archives) {
+		return 0, errors.New("databases have different archive layouts")
+	}
+
+	now := uint32(time.Now().Unix())
+	for i, info := range dst.Header.Archives {
+		srcPoints, e := src.readArchive(src.Header.Archives[i])
+		if e != nil {
+			return copied, e
+		}
+		dstPoints, e := dst.readArchive(info)
+		if e != nil {
+			return copied, e
+		}
+
+		merged := make(map[uint32]Point)
+		sources := make(map[uint32]string)
+		fromSrc := make(map[uint32]bool)
+		for j, points := range []archive{srcPoints, dstPoints} {
+			source := []string{src.path, dst.path}[j]
+			for _, point := range livePoints(info, points, now) {
+				if j == 0 && !opts.inWindow(point) {
+					continue
+				}
+				point.Timestamp = quantizeTimestamp(point.Timestamp, info.SecondsPerPoint)
+				merged[point.Timestamp] = point
+				sources[point.Timestamp] = source
+				fromSrc[point.Timestamp] = j == 0
+			}
+		}
+
+		points := sortedPoints(merged)
+		copied += countFromSrc(points, fromSrc)
+		if opts.DryRun {
+			continue
+		}
+		err = dst.writeArchive(info, points)
+		if err != nil {
+			return
+		}
+		dst.filterArchive(info, points)
+		err = opts.Provenance.recordPoints(i, info, points, sources)
+		if err != nil {
+			return
+		}
+	}
+	if !opts.DryRun && dst.path != "" {
+		err = removeIntervalFilter(dst.path)
+	}
+	return
+}
+
+/*
+Fill copies points from src into the intervals of dst that have no data, like carbonate's whisper-fill.
+
+The databases may have different archive layouts. Each archive of dst is filled from the highest
+precision archive of src that has data for an interval, aggregated with dst's aggregation method
+and xFilesFactor where src has a higher precision. Existing points in dst are never changed.
+*/
+func Fill(src, dst *Whisper) (err error) {
+	_, err = FillWithOptions(src, dst, MergeOptions{})
+	return
+}
+
+// FillWithOptions is like Fill but accepts options. Returns the number of points copied from src.
+func FillWithOptions(src, dst *Whisper, opts MergeOptions) (copied int, err error) {
+	defer lockPair(src, dst, !opts.DryRun)()
+
+	if !opts.DryRun {
+		err = dst.beginWrite()
+		if err != nil {
+			return
+		}
+		defer dst.endWrite()
+	}
+
+	now := uint32(time.Now().Unix())
+	srcPoints := make([]archive, len(src.Header.Archives))
+	for i, info := range src.Header.Archives {
+		points, e := src.readArchive(info)
+		if e != nil {
+			return copied, e
+		}
+		for _, point := range livePoints(info, points, now) {
+			if opts.inWindow(point) {
+				srcPoints[i] = append(srcPoints[i], point)
+			}
+		}
+	}
+
+	metadata := dst.Header.Metadata
+	for i, info := range dst.Header.Archives {
+		dstPoints, e := dst.readArchive(info)
+		if e != nil {
+			return copied, e
+		}
+
+		filled := make(map[uint32]Point)
+		sources := make(map[uint32]string)
+		fromSrc := make(map[uint32]bool)
+		for _, point := range livePoints(info, dstPoints, now) {
+			point.Timestamp = quantizeTimestamp(point.Timestamp, info.SecondsPerPoint)
+			filled[point.Timestamp] = point
+			sources[point.Timestamp] = dst.path
+		}
+
+		buckets, e := aggregateBuckets(src.Header.Archives, srcPoints, info, metadata.AggregationMethod, metadata.XFilesFactor, dst.aggregateNonFinite)
+		if e != nil {
+			return copied, e
+		}
+		for interval, point := range buckets {
+			if _, ok := filled[interval]; !ok {
+				filled[interval] = point
+				sources[interval] = src.path
+				fromSrc[interval] = true
+			}
+		}
+
+		points := livePoints(info, sortedPoints(filled), now)
+		copied += countFromSrc(points, fromSrc)
+		if opts.DryRun {
+			continue
+		}
+		err = dst.writeArchive(info, points)
+		if err != nil {
+			return
+		}
+		dst.filterArchive(info, points)
+		err = opts.Provenance.recordPoints(i, info, points, sources)
+		if err != nil {
+			return
+		}
+	}
+	if !opts.DryRun && dst.path != "" {
+		err = removeIntervalFilter(dst.path)
+	}
+	return
+}
+
+// Count the points that were copied from src
+func countFromSrc(points archive, fromSrc map[uint32]bool) (count int) {
+	for _, point := range points {
+		if fromSrc[point.Timestamp] {
+			count++
+		}
+	}
+	return
+}
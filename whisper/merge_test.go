@@ -0,0 +1,221 @@
+package whisper
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 10, 6}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	srcPath := filepath.Join(dir, "src.wsp")
+	dstPath := filepath.Join(dir, "dst.wsp")
+	createWithPoints(t, srcPath, info, archive{{now - 40, 1}, {now - 30, 2}, {now - 20, 3}})
+	createWithPoints(t, dstPath, info, archive{{now - 30, 20}, {now - 10, 40}})
+
+	src, err := OpenReadOnly(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := Open(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	if err := Merge(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := dst.readArchive(dst.Header.Archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := archive{{now - 40, 1}, {now - 30, 20}, {now - 20, 3}, {now - 10, 40}}
+	for i := range expected {
+		if points[i] != expected[i] {
+			t.Errorf("slot %d = %v, want %v", i, points[i], expected[i])
+		}
+	}
+
+	if err := Merge(dst, src); err != ErrReadOnly {
+		t.Errorf("Merge into read-only database: got %v, want %v", err, ErrReadOnly)
+	}
+}
+
+func TestFill(t *testing.T) {
+	dir := t.TempDir()
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 60)
+	srcPath := filepath.Join(dir, "src.wsp")
+	dstPath := filepath.Join(dir, "dst.wsp")
+	createWithPoints(t, srcPath, ArchiveInfo{0, 10, 60}, archive{
+		{now - 120, 1}, {now - 110, 2}, {now - 100, 3}, {now - 90, 4},
+		{now - 60, 5}, {now - 50, 6}, {now - 40, 7}, {now - 30, 8},
+	})
+	createWithPoints(t, dstPath, ArchiveInfo{0, 60, 10}, archive{{now - 60, 100}})
+
+	src, err := OpenReadOnly(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := Open(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	if err := Fill(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := dst.readArchive(dst.Header.Archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The gap at now-120 is filled with the average of src, the existing point at now-60 is kept
+	expected := archive{{now - 120, 2.5}, {now - 60, 100}}
+	for i := range expected {
+		if points[i] != expected[i] {
+			t.Errorf("slot %d = %v, want %v", i, points[i], expected[i])
+		}
+	}
+}
+
+func TestMergeWindowAndDryRun(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 10, 6}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	srcPath := filepath.Join(dir, "src.wsp")
+	dstPath := filepath.Join(dir, "dst.wsp")
+	createWithPoints(t, srcPath, info, archive{{now - 40, 1}, {now - 30, 2}, {now - 20, 3}})
+	createWithPoints(t, dstPath, info, archive{{now - 30, 20}})
+
+	src, err := OpenReadOnly(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := Open(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	copied, err := MergeWithOptions(src, dst, MergeOptions{From: now - 30, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 1 {
+		t.Errorf("dry run would copy %d points, want 1", copied)
+	}
+	points, err := dst.readArchive(dst.Header.Archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live := livePoints(info, points, now); len(live) != 1 {
+		t.Errorf("dry run changed dst: %v", live)
+	}
+
+	copied, err = FillWithOptions(src, dst, MergeOptions{Until: now - 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 1 {
+		t.Errorf("filled %d points, want 1", copied)
+	}
+	points, err = dst.readArchive(dst.Header.Archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live := livePoints(info, points, now); len(live) != 2 || live[0] != (Point{now - 40, 1}) {
+		t.Errorf("dst after fill = %v", live)
+	}
+}
+
+func TestMergeDryRunReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 10, 6}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	srcPath := filepath.Join(dir, "src.wsp")
+	dstPath := filepath.Join(dir, "dst.wsp")
+	createWithPoints(t, srcPath, info, archive{{now - 40, 1}, {now - 30, 2}})
+	createWithPoints(t, dstPath, info, archive{{now - 30, 20}})
+
+	src, err := OpenReadOnly(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := OpenReadOnly(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	copied, err := MergeWithOptions(src, dst, MergeOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 1 {
+		t.Errorf("dry run merge would copy %d points, want 1", copied)
+	}
+	copied, err = FillWithOptions(src, dst, MergeOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 1 {
+		t.Errorf("dry run fill would copy %d points, want 1", copied)
+	}
+}
+
+func TestMergeOppositeOrder(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 10, 6}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	aPath := filepath.Join(dir, "a.wsp")
+	bPath := filepath.Join(dir, "b.wsp")
+	createWithPoints(t, aPath, info, archive{{now - 40, 1}})
+	createWithPoints(t, bPath, info, archive{{now - 30, 2}})
+
+	a, err := Open(aPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := Open(bPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	done := make(chan error, 3)
+	for _, run := range []func() error{
+		func() error { return Merge(a, b) },
+		func() error { return Fill(b, a) },
+		func() error { _, err := Diff(b, a, now-60, now); return err },
+	} {
+		go func(run func() error) {
+			for i := 0; i < 200; i++ {
+				if err := run(); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}(run)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("merging in opposite orders deadlocked")
+		}
+	}
+}
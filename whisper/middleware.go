@@ -0,0 +1,41 @@
+package whisper
+
+// UpdateFunc writes a list of points to the database at path and reports what became of them
+type UpdateFunc func(path string, points []Point) (report UpdateReport, err error)
+
+/*
+Middleware wraps the writes made to a database. It is given the next UpdateFunc in the chain and
+returns one that is called in its place, so it can validate, transform, meter or mirror points
+before passing them on, or reject them by returning an error without calling next. The report
+returned by next tells it which points the database accepted.
+*/
+type Middleware func(next UpdateFunc) UpdateFunc
+
+// Add middleware around every Update and UpdateMany on the handle. Middleware added first is
+// outermost, so it sees the points before any middleware added after it.
+func (w *Whisper) Use(middleware ...Middleware) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.middleware = append(w.middleware, middleware...)
+}
+
+// Add middleware around every write to a database opened by the pool, after any middleware
+// already added. Handles that are already open get it too.
+func (p *Pool) Use(middleware ...Middleware) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.middleware = append(p.middleware, middleware...)
+	for element := p.lru.Front(); element != nil; element = element.Next() {
+		element.Value.(*poolEntry).whisper.Use(middleware...)
+	}
+}
+
+// Wrap a write in the handle's middleware
+func (w *Whisper) chain(write UpdateFunc) UpdateFunc {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	for i := len(w.middleware) - 1; i >= 0; i-- {
+		write = w.middleware[i](write)
+	}
+	return write
+}
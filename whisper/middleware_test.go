@@ -0,0 +1,73 @@
+package whisper
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errNegative := errors.New("negative value")
+	var order []string
+	var seen []Point
+	w.Use(func(next UpdateFunc) UpdateFunc {
+		return func(path string, points []Point) (UpdateReport, error) {
+			order = append(order, "validate")
+			for _, point := range points {
+				if point.Value < 0 {
+					return UpdateReport{}, errNegative
+				}
+			}
+			return next(path, points)
+		}
+	}, func(next UpdateFunc) UpdateFunc {
+		return func(path string, points []Point) (UpdateReport, error) {
+			order = append(order, "record")
+			seen = append(seen, points...)
+			return next(path, points)
+		}
+	})
+
+	now := uint32(time.Now().Unix())
+	if err := w.UpdateMany([]Point{{now, 1}, {now - 60, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Update(Point{now, -1}); err != errNegative {
+		t.Errorf("expected the middleware to reject the point, got %v", err)
+	}
+	if len(order) != 3 || order[0] != "validate" || order[1] != "record" || order[2] != "validate" {
+		t.Errorf("middleware ran in order %v", order)
+	}
+	if len(seen) != 2 {
+		t.Errorf("recorded %v", seen)
+	}
+}
+
+func TestPoolMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metric.wsp")
+	createWithPoints(t, path, ArchiveInfo{0, 60, 1440}, archive{})
+
+	pool := NewPool(1)
+	defer pool.Close()
+
+	var paths []string
+	pool.Use(func(next UpdateFunc) UpdateFunc {
+		return func(path string, points []Point) (UpdateReport, error) {
+			paths = append(paths, path)
+			return next(path, points)
+		}
+	})
+	now := uint32(time.Now().Unix())
+	if err := pool.UpdateMany(path, []Point{{now, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != path {
+		t.Errorf("middleware saw writes to %v", paths)
+	}
+}
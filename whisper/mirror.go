@@ -0,0 +1,134 @@
+package whisper
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// A Sink receives the points mirrored from a whisper database
+type Sink interface {
+	Write(path string, points []Point) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(path string, points []Point) error
+
+func (f SinkFunc) Write(path string, points []Point) error {
+	return f(path, points)
+}
+
+// MirrorStats counts what happened to the writes seen by a Mirror
+type MirrorStats struct {
+	Forwarded uint64 // Writes delivered to the sink
+	Dropped   uint64 // Writes discarded because the buffer was full
+	Failed    uint64 // Writes the sink returned an error for
+	LastError error  // The most recent error returned by the sink
+}
+
+/*
+A Mirror forwards every point accepted by a database to a secondary Sink, for dual-writing during a
+migration to another storage system.
+
+Writes are queued in a bounded buffer and delivered by a single background goroutine, so a slow or
+failing sink never delays or fails writes to whisper. When the buffer is full, writes are dropped
+from the mirror and counted in its stats.
+*/
+type Mirror struct {
+	sink  Sink
+	queue chan mirrorWrite
+	done  chan struct{}
+	once  sync.Once
+
+	forwarded, dropped, failed uint64
+	mutex                      sync.Mutex
+	lastError                  error
+}
+
+type mirrorWrite struct {
+	path   string
+	points []Point
+}
+
+// Create a mirror to sink buffering up to bufferSize writes, and start delivering them
+func NewMirror(sink Sink, bufferSize int) *Mirror {
+	m := &Mirror{sink: sink, queue: make(chan mirrorWrite, bufferSize), done: make(chan struct{})}
+	go m.run()
+	return m
+}
+
+// Returns middleware that queues the points of every write for the sink once they have been written
+// to whisper. Points the database didn't accept, such as those outside its retention, aren't mirrored.
+func (m *Mirror) Middleware() Middleware {
+	return func(next UpdateFunc) UpdateFunc {
+		return func(path string, points []Point) (report UpdateReport, err error) {
+			report, err = next(path, points)
+			if err != nil || len(report.Written) == 0 {
+				return
+			}
+			select {
+			case m.queue <- mirrorWrite{path, append([]Point(nil), report.Written...)}:
+			default:
+				atomic.AddUint64(&m.dropped, 1)
+			}
+			return
+		}
+	}
+}
+
+// Returns the mirror's counters
+func (m *Mirror) Stats() MirrorStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return MirrorStats{
+		Forwarded: atomic.LoadUint64(&m.forwarded),
+		Dropped:   atomic.LoadUint64(&m.dropped),
+		Failed:    atomic.LoadUint64(&m.failed),
+		LastError: m.lastError,
+	}
+}
+
+// Deliver the writes still buffered and stop the mirror. No writes may be made through its
+// middleware afterwards.
+func (m *Mirror) Close() {
+	m.once.Do(func() {
+		close(m.queue)
+	})
+	<-m.done
+}
+
+func (m *Mirror) run() {
+	defer close(m.done)
+	for write := range m.queue {
+		err := m.sink.Write(write.path, write.points)
+		if err != nil {
+			atomic.AddUint64(&m.failed, 1)
+			m.mutex.Lock()
+			m.lastError = err
+			m.mutex.Unlock()
+			continue
+		}
+		atomic.AddUint64(&m.forwarded, 1)
+	}
+}
+
+// TreeSink mirrors writes to the databases at the same relative paths in another tree
+type TreeSink struct {
+	root    string
+	dstRoot string
+	pool    *Pool
+}
+
+// Create a sink that writes points for databases under root to the same paths under dstRoot,
+// opening the destination databases through pool. The destination databases must already exist.
+func NewTreeSink(root, dstRoot string, pool *Pool) *TreeSink {
+	return &TreeSink{root, dstRoot, pool}
+}
+
+func (s *TreeSink) Write(path string, points []Point) error {
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return err
+	}
+	return s.pool.UpdateMany(filepath.Join(s.dstRoot, rel), points)
+}
@@ -0,0 +1,125 @@
+package whisper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	var received []Point
+	errSink := errors.New("sink unavailable")
+	mirror := NewMirror(SinkFunc(func(path string, points []Point) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if points[0].Value < 0 {
+			return errSink
+		}
+		received = append(received, points...)
+		return nil
+	}), 10)
+	w.Use(mirror.Middleware())
+
+	now := uint32(time.Now().Unix())
+	for _, point := range []Point{{now, 1}, {now, -1}, {now, 2}} {
+		if err := w.UpdateMany([]Point{point}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mirror.Close()
+
+	if len(received) != 2 || received[0].Value != 1 || received[1].Value != 2 {
+		t.Errorf("sink received %v", received)
+	}
+	stats := mirror.Stats()
+	if stats.Forwarded != 2 || stats.Failed != 1 || stats.Dropped != 0 || stats.LastError != errSink {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestMirrorSkipsDroppedPoints(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 10}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []Point
+	mirror := NewMirror(SinkFunc(func(path string, points []Point) error {
+		received = append(received, points...)
+		return nil
+	}), 10)
+	w.Use(mirror.Middleware())
+
+	// Only the first point is within the database's retention
+	now := uint32(time.Now().Unix())
+	if err := w.UpdateMany([]Point{{now - 60, 1}, {now - 3600, 2}, {now + 3600, 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Update(Point{now - 3600, 4}); err == nil {
+		t.Error("expected an error for a point outside the retention")
+	}
+	mirror.Close()
+
+	if len(received) != 1 || received[0] != (Point{now - 60, 1}) {
+		t.Errorf("sink received %v", received)
+	}
+}
+
+func TestMirrorDropsWhenFull(t *testing.T) {
+	block := make(chan struct{})
+	mirror := NewMirror(SinkFunc(func(path string, points []Point) error {
+		<-block
+		return nil
+	}), 1)
+	update := mirror.Middleware()(func(path string, points []Point) (UpdateReport, error) {
+		return UpdateReport{Accepted: len(points), Written: points}, nil
+	})
+
+	for i := 0; i < 5; i++ {
+		update("metric.wsp", []Point{{uint32(i), 0}})
+	}
+	close(block)
+	mirror.Close()
+
+	stats := mirror.Stats()
+	if stats.Dropped == 0 || stats.Forwarded+stats.Dropped != 5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestTreeSink(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(dst, "servers"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	createWithPoints(t, filepath.Join(dst, "servers", "a.wsp"), ArchiveInfo{0, 60, 10}, archive{})
+
+	pool := NewPool(1)
+	defer pool.Close()
+	var written []string
+	pool.Use(func(next UpdateFunc) UpdateFunc {
+		return func(path string, points []Point) (UpdateReport, error) {
+			written = append(written, path)
+			return next(path, points)
+		}
+	})
+
+	sink := NewTreeSink(src, dst, pool)
+	if err := sink.Write(filepath.Join(src, "servers", "a.wsp"), []Point{{1, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || written[0] != filepath.Join(dst, "servers", "a.wsp") {
+		t.Errorf("sink wrote to %v", written)
+	}
+	if err := sink.Write(filepath.Join(src, "missing.wsp"), []Point{{1, 1}}); err == nil {
+		t.Error("expected an error for a missing destination")
+	}
+}
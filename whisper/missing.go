@@ -0,0 +1,57 @@
+package whisper
+
+import "math"
+
+/*
+MissingPolicy decides how FetchUntil represents intervals that have no data. FetchSeries always uses
+nil for them.
+
+Under the default, MISSING_NAN, a missing interval is a point with the interval's timestamp and the
+value MissingNaN. It is a NaN with its own bit pattern, so IsMissing tells it apart from NaN values
+that were written to the database and from StaleNaN staleness markers, which are returned as they
+were stored. Consumers that skip NaN values don't have their averages dragged toward zero by
+empty intervals.
+*/
+type MissingPolicy int
+
+// Valid missing policies
+const (
+	MISSING_NAN  MissingPolicy = iota // A point with the interval's timestamp and the value MissingNaN
+	MISSING_ZERO                      // A zero point, whose timestamp of 0 marks it as absent, as whisper has traditionally returned
+)
+
+// MissingNaN is the value of the points FetchUntil returns for missing intervals under MISSING_NAN
+var MissingNaN = math.Float64frombits(missingNaNBits)
+
+const missingNaNBits = 0x7ff0000000000003
+
+// Returns true if a value fetched by FetchUntil marks a missing interval rather than data
+func IsMissing(value float64) bool {
+	return math.Float64bits(value) == missingNaNBits
+}
+
+// Set how FetchUntil represents intervals without data. Slots holding a point left over from an
+// earlier cycle of the archive are treated as missing under every policy, as their value belongs
+// to a different interval. The default is MISSING_NAN.
+func (w *Whisper) SetMissingPolicy(policy MissingPolicy) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.missingPolicy = policy
+}
+
+// Replace the points fetched for an interval that don't hold that interval's data according to
+// the handle's missing policy
+func (w *Whisper) fillMissing(interval Interval, points []Point) {
+	for i, point := range points {
+		timestamp := interval.FromTimestamp + uint32(i)*interval.Step
+		if point.Timestamp == timestamp {
+			continue
+		}
+		switch w.missingPolicy {
+		case MISSING_ZERO:
+			points[i] = Point{}
+		default:
+			points[i] = Point{timestamp, MissingNaN}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package whisper
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMissingPolicy(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 10, 6}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	// The slot after now-30 holds a point from the previous cycle of the archive
+	if err := w.writeArchive(w.Header.Archives[0], archive{{now - 50, 1}, {now - 40, 0}, {now - 20, 5}, {now + 30, 9}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		policy   MissingPolicy
+		expected []Point
+	}{
+		{MISSING_ZERO, []Point{{now - 40, 0}, {}, {now - 20, 5}, {}}},
+		{MISSING_NAN, []Point{{now - 40, 0}, {now - 30, MissingNaN}, {now - 20, 5}, {now - 10, MissingNaN}}},
+	} {
+		w.SetMissingPolicy(test.policy)
+		interval, points, err := w.FetchUntil(now-50, now-10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if interval.FromTimestamp != now-40 || len(points) != len(test.expected) {
+			t.Fatalf("policy %d: got %d points from %d", test.policy, len(points), interval.FromTimestamp)
+		}
+		for i, point := range points {
+			expected := test.expected[i]
+			if point.Timestamp != expected.Timestamp || math.Float64bits(point.Value) != math.Float64bits(expected.Value) {
+				t.Errorf("policy %d: point %d = %v, want %v", test.policy, i, point, expected)
+			}
+		}
+	}
+}
+
+func TestMissingIsDistinct(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 10, 6}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	if err := w.UpdateMany([]Point{{now - 40, math.NaN()}, {now - 20, StaleNaN}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// By default missing intervals are NaN, but can be told apart from stored NaN values and markers
+	_, points, err := w.FetchUntil(now-50, now-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []bool{false, true, false, true} {
+		point := points[i]
+		if !math.IsNaN(point.Value) || IsMissing(point.Value) != expected {
+			t.Errorf("point %d = %v, IsMissing %v, want %v", i, point, IsMissing(point.Value), expected)
+		}
+	}
+	if !IsStale(points[2].Value) {
+		t.Errorf("staleness marker fetched as %v", points[2])
+	}
+	if IsMissing(math.NaN()) || IsMissing(0) || IsMissing(StaleNaN) {
+		t.Error("IsMissing should only match MissingNaN")
+	}
+}
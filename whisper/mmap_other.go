@@ -0,0 +1,9 @@
+//go:build !unix
+
+package whisper
+
+// OpenMapped opens a whisper database read-only. Memory mapping is not supported on this
+// platform, so this is the same as OpenReadOnly.
+func OpenMapped(path string) (whisper *Whisper, err error) {
+	return OpenReadOnly(path)
+}
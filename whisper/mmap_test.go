@@ -0,0 +1,34 @@
+package whisper
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenMapped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	points := archive{{600, 1}, {660, 2}, {720, 3}}
+	createWithPoints(t, path, ArchiveInfo{0, 60, 10}, points)
+
+	w, err := OpenMapped(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if w.Header.Archives[0].Points != 10 {
+		t.Errorf("unexpected header %+v", w.Header)
+	}
+	read, err := w.readArchive(w.Header.Archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range points {
+		if read[i] != points[i] {
+			t.Errorf("slot %d = %v, want %v", i, read[i], points[i])
+		}
+	}
+	if err := w.UpdateMany([]Point{{720, 4}}); err != ErrReadOnly {
+		t.Errorf("UpdateMany: got %v, want %v", err, ErrReadOnly)
+	}
+}
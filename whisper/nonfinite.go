@@ -0,0 +1,94 @@
+package whisper
+
+import (
+	"fmt"
+	"math"
+)
+
+// NonFinitePolicy decides what happens to NaN and infinite values written to a database
+type NonFinitePolicy int
+
+// Valid write policies for non-finite values
+const (
+	NONFINITE_STORE  NonFinitePolicy = iota // Store NaN and infinite values as given
+	NONFINITE_REJECT                        // Fail the write with a *NonFiniteError
+	NONFINITE_COERCE                        // Store NaN as 0 and infinite values as the largest finite value of the same sign
+)
+
+// AggregateNonFinitePolicy decides how NaN and infinite values are treated when aggregating points into a lower precision archive
+type AggregateNonFinitePolicy int
+
+// Valid aggregation policies for non-finite values
+const (
+	AGGREGATE_SKIP_NONFINITE   AggregateNonFinitePolicy = iota // Treat non-finite values as unknown, as if they were never written
+	AGGREGATE_POISON_NONFINITE                                 // The aggregate of an interval containing a non-finite value is NaN
+)
+
+// NonFiniteError is returned when a NaN or infinite value is written to a database using NONFINITE_REJECT
+type NonFiniteError struct {
+	Point Point // The rejected point
+}
+
+func (e *NonFiniteError) Error() string {
+	return fmt.Sprintf("non-finite value %v at timestamp %d", e.Point.Value, e.Point.Timestamp)
+}
+
+// Set how NaN and infinite values are handled when they are written to the database and when
+// they are aggregated into lower precision archives. By default they are stored, and skipped when aggregating.
+func (w *Whisper) SetNonFinitePolicy(write NonFinitePolicy, aggregate AggregateNonFinitePolicy) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.nonFinite = write
+	w.aggregateNonFinite = aggregate
+}
+
+// Apply the handle's write policy to a list of points. The given slice is never modified.
+func (w *Whisper) checkNonFinite(points []Point) ([]Point, error) {
+	if w.nonFinite == NONFINITE_STORE {
+		return points, nil
+	}
+
+	var coerced []Point
+	for i, point := range points {
+		if isFinite(point.Value) {
+			continue
+		}
+		if w.nonFinite == NONFINITE_REJECT {
+			return nil, &NonFiniteError{point}
+		}
+		if coerced == nil {
+			coerced = append([]Point(nil), points...)
+		}
+		switch {
+		case math.IsNaN(point.Value):
+			coerced[i].Value = 0
+		case point.Value > 0:
+			coerced[i].Value = math.MaxFloat64
+		default:
+			coerced[i].Value = -math.MaxFloat64
+		}
+	}
+
+	if coerced == nil {
+		return points, nil
+	}
+	return coerced, nil
+}
+
+// Select the points of an interval to aggregate according to an aggregation policy.
+// If poisoned is true the aggregate of the interval must be NaN.
+func filterNonFinite(policy AggregateNonFinitePolicy, points []Point) (known []Point, poisoned bool) {
+	for _, point := range points {
+		if isFinite(point.Value) {
+			known = append(known, point)
+		} else if policy == AGGREGATE_POISON_NONFINITE {
+			known = append(known, point)
+			poisoned = true
+		}
+	}
+	return
+}
+
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
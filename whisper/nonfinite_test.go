@@ -0,0 +1,50 @@
+package whisper
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckNonFinite(t *testing.T) {
+	points := []Point{{1, 1}, {2, math.NaN()}, {3, math.Inf(1)}, {4, math.Inf(-1)}}
+
+	w := Whisper{}
+	if checked, err := w.checkNonFinite(points); err != nil || len(checked) != 4 || !math.IsNaN(checked[1].Value) {
+		t.Errorf("store: got %v, %v", checked, err)
+	}
+
+	w.SetNonFinitePolicy(NONFINITE_REJECT, AGGREGATE_SKIP_NONFINITE)
+	_, err := w.checkNonFinite(points)
+	if e, ok := err.(*NonFiniteError); !ok || e.Point.Timestamp != 2 {
+		t.Errorf("reject: got %v, want *NonFiniteError for timestamp 2", err)
+	}
+
+	w.SetNonFinitePolicy(NONFINITE_COERCE, AGGREGATE_SKIP_NONFINITE)
+	checked, err := w.checkNonFinite(points)
+	expected := []Point{{1, 1}, {2, 0}, {3, math.MaxFloat64}, {4, -math.MaxFloat64}}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range expected {
+		if checked[i] != expected[i] {
+			t.Errorf("coerce: point %d = %v, want %v", i, checked[i], expected[i])
+		}
+	}
+	if !math.IsNaN(points[1].Value) {
+		t.Errorf("coerce modified the given points")
+	}
+}
+
+func TestFilterNonFinite(t *testing.T) {
+	points := []Point{{1, 1}, {2, math.NaN()}, {3, 3}}
+
+	known, poisoned := filterNonFinite(AGGREGATE_SKIP_NONFINITE, points)
+	if len(known) != 2 || poisoned {
+		t.Errorf("skip: got %v, %v", known, poisoned)
+	}
+
+	known, poisoned = filterNonFinite(AGGREGATE_POISON_NONFINITE, points)
+	if len(known) != 3 || !poisoned {
+		t.Errorf("poison: got %v, %v", known, poisoned)
+	}
+}
@@ -0,0 +1,259 @@
+package whisper
+
+import (
+	"container/list"
+	"os"
+	"sync"
+)
+
+/*
+A Pool keeps up to a fixed number of whisper databases open, so writers touching more databases
+than they can hold file descriptors for don't have to open and close a file on every access.
+
+Databases are opened on first access, or created if the pool has a Creator, and the least recently
+used ones are closed when the pool is full. Before every access the handle is refreshed, so a database replaced by an external resize is
+transparently reopened. Databases that keep failing can be quarantined, see SetQuarantinePolicy.
+A Pool is safe for concurrent use.
+*/
+type Pool struct {
+	mutex   sync.Mutex
+	idle    *sync.Cond
+	maxOpen int
+	entries map[string]*list.Element
+	lru     *list.List // Most recently used first
+	fenced  map[string]bool
+
+	middleware  []Middleware
+	creator     Creator
+	quarantine  QuarantinePolicy
+	failures    map[string]int   // Consecutive failed accesses of each database
+	quarantined map[string]error // The error that caused each quarantine
+}
+
+type poolEntry struct {
+	path    string
+	whisper *Whisper
+	refs    int // Number of accesses in progress
+}
+
+// Create a pool holding at most maxOpen databases open. Databases that are in use are never
+// closed, so the limit can be exceeded briefly by concurrent accesses.
+func NewPool(maxOpen int) *Pool {
+	p := &Pool{
+		maxOpen: maxOpen,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		fenced:  make(map[string]bool),
+
+		failures:    make(map[string]int),
+		quarantined: make(map[string]error),
+	}
+	p.idle = sync.NewCond(&p.mutex)
+	return p
+}
+
+// Call fn with an open handle to the database at path. The handle must not be used after fn returns.
+func (p *Pool) Do(path string, fn func(w *Whisper) error) (err error) {
+	entry, err := p.acquire(path)
+	if err != nil {
+		if err != ErrMaintenanceInProgress && err != ErrQuarantined && !os.IsNotExist(err) {
+			p.recordFailure(path, err)
+		}
+		return
+	}
+
+	_, err = entry.whisper.Refresh()
+	failed := err != nil && !os.IsNotExist(err)
+	if err == nil {
+		err = fn(entry.whisper)
+		failed = isStorageError(err)
+	}
+	p.release(entry)
+
+	if failed {
+		p.recordFailure(path, err)
+	} else {
+		p.recordSuccess(path)
+	}
+	return
+}
+
+// Write a single datapoint to the database at path
+func (p *Pool) Update(path string, point Point) error {
+	return p.Do(path, func(w *Whisper) error {
+		return w.Update(point)
+	})
+}
+
+// Write several datapoints to the database at path
+func (p *Pool) UpdateMany(path string, points []Point) error {
+	return p.Do(path, func(w *Whisper) error {
+		return w.UpdateMany(points)
+	})
+}
+
+// Fetch the points of the database at path between from and until
+func (p *Pool) FetchUntil(path string, from, until uint32) (interval Interval, points []Point, err error) {
+	err = p.Do(path, func(w *Whisper) (e error) {
+		interval, points, e = w.FetchUntil(from, until)
+		return
+	})
+	return
+}
+
+/*
+Maintain takes exclusive ownership of the database at path for the duration of fn, for maintenance
+operations such as Resize. It waits for accesses in progress to finish and closes the pooled handle.
+Until fn returns, accesses to the database through the pool fail with ErrMaintenanceInProgress.
+*/
+func (p *Pool) Maintain(path string, fn func() error) (err error) {
+	p.mutex.Lock()
+	if p.fenced[path] {
+		p.mutex.Unlock()
+		return ErrMaintenanceInProgress
+	}
+	p.fenced[path] = true
+	err = p.remove(path)
+	p.mutex.Unlock()
+
+	defer func() {
+		p.mutex.Lock()
+		delete(p.fenced, path)
+		p.mutex.Unlock()
+	}()
+	if err != nil {
+		return
+	}
+	return fn()
+}
+
+// Set the creator of missing databases. Accesses to a database that doesn't exist create it with the
+// settings creator returns, instead of failing with an error satisfying os.IsNotExist.
+func (p *Pool) SetCreator(creator Creator) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.creator = creator
+}
+
+// Close the pooled handle of the database at path, waiting for accesses in progress to finish
+func (p *Pool) Evict(path string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.remove(path)
+}
+
+// Returns the number of open databases
+func (p *Pool) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.lru.Len()
+}
+
+// Close every database in the pool. The pool must not be in use.
+func (p *Pool) Close() (err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for p.lru.Len() > 0 {
+		e := p.remove(p.lru.Front().Value.(*poolEntry).path)
+		if err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Get the entry of the database at path, opening it if needed, and mark it as in use
+func (p *Pool) acquire(path string) (entry *poolEntry, err error) {
+	p.mutex.Lock()
+	entry, err = p.lookup(path)
+	middleware, creator := p.middleware, p.creator
+	p.mutex.Unlock()
+	if entry != nil || err != nil {
+		return
+	}
+
+	// Open outside the mutex, so a slow open doesn't hold up accesses to every other database
+	w, err := openOrCreate(path, creator)
+	if err != nil {
+		return
+	}
+	w.Use(middleware...)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// The database may have been fenced or opened by another caller in the meantime
+	entry, err = p.lookup(path)
+	if entry != nil || err != nil {
+		w.Close()
+		return
+	}
+	entry = &poolEntry{path: path, whisper: w, refs: 1}
+	p.entries[path] = p.lru.PushFront(entry)
+	p.evictIdle()
+	return
+}
+
+// Returns the entry of the database at path with its reference taken, nil if it isn't open, or an
+// error if the database can't be accessed. Must be called with the mutex held.
+func (p *Pool) lookup(path string) (entry *poolEntry, err error) {
+	if p.fenced[path] {
+		return nil, ErrMaintenanceInProgress
+	}
+	if _, ok := p.quarantined[path]; ok {
+		return nil, ErrQuarantined
+	}
+	if element, ok := p.entries[path]; ok {
+		p.lru.MoveToFront(element)
+		entry = element.Value.(*poolEntry)
+		entry.refs++
+	}
+	return
+}
+
+// Mark an entry as no longer in use
+func (p *Pool) release(entry *poolEntry) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entry.refs--
+	if entry.refs == 0 {
+		p.idle.Broadcast()
+	}
+	p.evictIdle()
+}
+
+// Close the least recently used databases that aren't in use until the pool is within its limit.
+// Must be called with the mutex held.
+func (p *Pool) evictIdle() {
+	element := p.lru.Back()
+	for element != nil && p.lru.Len() > p.maxOpen {
+		previous := element.Prev()
+		entry := element.Value.(*poolEntry)
+		if entry.refs == 0 {
+			p.lru.Remove(element)
+			delete(p.entries, entry.path)
+			entry.whisper.Close()
+		}
+		element = previous
+	}
+}
+
+// Close and forget the handle of the database at path once it is no longer in use.
+// Must be called with the mutex held.
+func (p *Pool) remove(path string) error {
+	for {
+		element, ok := p.entries[path]
+		if !ok {
+			return nil
+		}
+		entry := element.Value.(*poolEntry)
+		if entry.refs == 0 {
+			p.lru.Remove(element)
+			delete(p.entries, path)
+			return entry.whisper.Close()
+		}
+		p.idle.Wait()
+	}
+}
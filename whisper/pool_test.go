@@ -0,0 +1,204 @@
+package whisper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolEviction(t *testing.T) {
+	dir := t.TempDir()
+	paths := make([]string, 3)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%d.wsp", i))
+		createWithPoints(t, paths[i], ArchiveInfo{0, 60, 10}, archive{})
+	}
+
+	pool := NewPool(2)
+	defer pool.Close()
+
+	opened := make(map[string]*Whisper)
+	for _, path := range []string{paths[0], paths[1], paths[0], paths[2]} {
+		err := pool.Do(path, func(w *Whisper) error {
+			if w.Path() != path {
+				t.Errorf("got handle for %s, want %s", w.Path(), path)
+			}
+			opened[path] = w
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pool.Len() != 2 {
+		t.Errorf("pool holds %d databases, want 2", pool.Len())
+	}
+
+	// paths[1] was least recently used, so it must have been evicted and is reopened
+	pool.Do(paths[0], func(w *Whisper) error {
+		if w != opened[paths[0]] {
+			t.Error("expected the pooled handle to be reused")
+		}
+		return nil
+	})
+	pool.Do(paths[1], func(w *Whisper) error {
+		if w == opened[paths[1]] {
+			t.Error("expected an evicted handle to be reopened")
+		}
+		return nil
+	})
+}
+
+func TestPoolReopensReplacedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metric.wsp")
+	createWithPoints(t, path, ArchiveInfo{0, 60, 10}, archive{})
+
+	pool := NewPool(1)
+	defer pool.Close()
+	if err := pool.Do(path, func(w *Whisper) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	replacement := filepath.Join(dir, "replacement.wsp")
+	createWithPoints(t, replacement, ArchiveInfo{0, 60, 20}, archive{})
+	if err := os.Rename(replacement, path); err != nil {
+		t.Fatal(err)
+	}
+
+	pool.Do(path, func(w *Whisper) error {
+		if w.Header.Archives[0].Points != 20 {
+			t.Errorf("expected the replaced file to be reopened, got %v", w.Header.Archives)
+		}
+		return nil
+	})
+}
+
+func TestPoolUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metric.wsp")
+	createWithPoints(t, path, ArchiveInfo{0, 60, 10}, archive{})
+
+	pool := NewPool(1)
+	defer pool.Close()
+	now := uint32(time.Now().Unix())
+	timestamp := quantizeTimestamp(now, 60) - 60
+	if err := pool.Update(path, Point{timestamp, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.UpdateMany(path, []Point{{timestamp - 60, 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, points, err := pool.FetchUntil(path, timestamp-120, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Point{{timestamp - 60, 2}, {timestamp, 1}}
+	if fmt.Sprint(points) != fmt.Sprint(expected) {
+		t.Errorf("fetched %v, want %v", points, expected)
+	}
+}
+
+func TestPoolMaintain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metric.wsp")
+	createWithPoints(t, path, ArchiveInfo{0, 60, 10}, archive{})
+
+	pool := NewPool(1)
+	defer pool.Close()
+	if err := pool.Do(path, func(w *Whisper) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	err := pool.Maintain(path, func() error {
+		if pool.Len() != 0 {
+			t.Error("expected the handle to be closed during maintenance")
+		}
+		if err := pool.Do(path, func(w *Whisper) error { return nil }); err != ErrMaintenanceInProgress {
+			t.Errorf("expected ErrMaintenanceInProgress, got %v", err)
+		}
+		return Resize(path, []ArchiveInfo{{0, 60, 20}}, ResizeOptions{NoBackup: true})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool.Do(path, func(w *Whisper) error {
+		if w.Header.Archives[0].Points != 20 {
+			t.Errorf("expected the resized file, got %v", w.Header.Archives)
+		}
+		return nil
+	})
+}
+
+func TestPoolConcurrentOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metric.wsp")
+	createWithPoints(t, path, ArchiveInfo{0, 60, 10}, archive{})
+
+	pool := NewPool(4)
+	defer pool.Close()
+	handles := make(chan *Whisper, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := pool.Do(path, func(w *Whisper) error {
+				handles <- w
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(handles)
+
+	// Handles opened by callers that lost the race are closed, every caller uses the pooled one
+	first := <-handles
+	for w := range handles {
+		if w != first {
+			t.Fatalf("callers were given different handles")
+		}
+	}
+	if pool.Len() != 1 {
+		t.Errorf("pool holds %d databases, want 1", pool.Len())
+	}
+}
+
+func TestPoolCreator(t *testing.T) {
+	dir := t.TempDir()
+	pool := NewPool(4)
+	defer pool.Close()
+	pool.SetCreator(func(path string) ([]ArchiveInfo, float32, AggregationMethod, bool) {
+		return []ArchiveInfo{{0, 60, 10}}, 0, AGGREGATION_SUM, filepath.Base(path) == "created.wsp"
+	})
+
+	timestamp := quantizeTimestamp(uint32(time.Now().Unix()), 60) - 60
+	if err := pool.Update(filepath.Join(dir, "skipped.wsp"), Point{timestamp, 1}); !os.IsNotExist(err) {
+		t.Errorf("Update of a database the creator skips: got %v, want a not exist error", err)
+	}
+
+	path := filepath.Join(dir, "servers", "created.wsp")
+	if err := pool.Update(path, Point{timestamp, 1}); err != nil {
+		t.Fatal(err)
+	}
+	w, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Header.Metadata.AggregationMethod != AGGREGATION_SUM || w.Header.Metadata.XFilesFactor != 0 {
+		t.Errorf("created with %+v", w.Header.Metadata)
+	}
+	_, points, err := w.FetchUntil(timestamp-60, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0] != (Point{timestamp, 1}) {
+		t.Errorf("fetched %v", points)
+	}
+}
@@ -0,0 +1,18 @@
+package whisper
+
+import (
+	"os"
+	"syscall"
+)
+
+// Allocate size bytes of zeroes for file with fallocate. Returns false if the filesystem doesn't support it.
+func preallocate(file *os.File, size int64) (allocated bool, err error) {
+	err = syscall.Fallocate(int(file.Fd()), 0, 0, size)
+	switch err {
+	case nil:
+		return true, nil
+	case syscall.EOPNOTSUPP, syscall.ENOSYS:
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package whisper
+
+import (
+	"os"
+)
+
+// Preallocation is not supported on this platform, so zeroes are always written
+
+func preallocate(file *os.File, size int64) (allocated bool, err error) {
+	return false, nil
+}
@@ -0,0 +1,109 @@
+package whisper
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// UpdateOptions controls how UpdateManyWithOptions writes points
+type UpdateOptions struct {
+	// Write points only to the archive that holds them, without aggregating them into the lower
+	// precision archives. Call Propagate afterwards to bring the lower precision archives up to date.
+	SkipPropagation bool
+}
+
+/*
+Propagate recomputes every interval of the lower precision archives between from and until from the
+archive above it, as updates do for the points they write.
+
+This brings the rollups up to date after points were loaded with SkipPropagation, so a bulk backfill
+can write the highest precision archive quickly and aggregate it once at the end instead of on every
+update. Each archive is aggregated from the one above after that one was recomputed, and intervals
+without enough known points for the xFilesFactor are left alone.
+*/
+func (w *Whisper) Propagate(from, until uint32) (err error) {
+	if from > until {
+		return errors.New(fmt.Sprintf("from %d is after until %d", from, until))
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err = w.beginWrite()
+	if err != nil {
+		return
+	}
+	defer w.endWrite()
+	defer w.afterUpdate(&err)
+
+	now := uint32(time.Now().Unix())
+	if until > now {
+		until = now
+	}
+	archives := w.Header.Archives
+	for i := 0; i+1 < len(archives); i++ {
+		err = w.propagateRange(archives[i], archives[i+1], from, until, now)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Recompute the intervals of lower between from and until that higher still holds data for
+func (w *Whisper) propagateRange(higher, lower ArchiveInfo, from, until, now uint32) (err error) {
+	step := lower.SecondsPerPoint
+	start := quantizeTimestamp(from, step)
+	if earliest := quantizeTimestamp(higher.StartTime(now), step); start < earliest {
+		start = earliest
+	}
+
+	var timestamps []uint32
+	for timestamp := start; timestamp <= until && timestamp >= start; timestamp += step {
+		timestamps = append(timestamps, timestamp)
+	}
+	if len(timestamps) == 0 {
+		return
+	}
+	_, err = w.propagateMany(timestamps, higher, lower)
+	return
+}
+
+/*
+RebuildRollups recomputes every lower precision archive from the highest precision archive using the
+database's aggregation method, for repairing the rollups after SetAggregationMethod or after bad
+values were propagated.
+
+Only the intervals of each archive that lie entirely within the retention of the highest precision
+archive are recomputed; older intervals can't be rebuilt and are kept as they are, as are intervals
+without enough known points for the xFilesFactor.
+*/
+func (w *Whisper) RebuildRollups() (err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err = w.beginWrite()
+	if err != nil {
+		return
+	}
+	defer w.endWrite()
+	defer w.afterUpdate(&err)
+
+	now := uint32(time.Now().Unix())
+	highest := w.Header.Archives[0]
+	for _, lower := range w.Header.Archives[1:] {
+		// Skip the oldest interval if the highest precision archive only holds part of it
+		step := lower.SecondsPerPoint
+		start := quantizeTimestamp(highest.StartTime(now)+step-1, step)
+		var timestamps []uint32
+		for timestamp := start; timestamp <= now && timestamp >= start; timestamp += step {
+			timestamps = append(timestamps, timestamp)
+		}
+		_, err = w.propagateMany(timestamps, highest, lower)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package whisper
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSkipPropagation(t *testing.T) {
+	dir := t.TempDir()
+	archives := []ArchiveInfo{{0, 60, 60}, {0, 300, 24}, {0, 900, 12}}
+	now := uint32(time.Now().Unix())
+	start := now - now%900 - 1800
+	var points []Point
+	for timestamp := start; timestamp < start+1800; timestamp += 60 {
+		points = append(points, Point{timestamp, 2})
+	}
+
+	open := func(name string) *Whisper {
+		path := filepath.Join(dir, name)
+		if err := Create(path, archives, 0.5, AGGREGATION_SUM, false); err != nil {
+			t.Fatal(err)
+		}
+		w, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	updated := open("updated.wsp")
+	defer updated.Close()
+	if err := updated.UpdateMany(points); err != nil {
+		t.Fatal(err)
+	}
+	want, err := updated.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backfilled := open("backfilled.wsp")
+	defer backfilled.Close()
+	if err := backfilled.UpdateManyWithOptions(points, UpdateOptions{SkipPropagation: true}); err != nil {
+		t.Fatal(err)
+	}
+	dump, err := backfilled.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dump.Archives[0].Data) != len(points) {
+		t.Errorf("highest precision archive has %d points, want %d", len(dump.Archives[0].Data), len(points))
+	}
+	for i, archive := range dump.Archives[1:] {
+		if len(archive.Data) != 0 {
+			t.Errorf("archive %d was propagated to: %v", i+1, archive.Data)
+		}
+	}
+
+	if err := backfilled.Propagate(start, now); err != nil {
+		t.Fatal(err)
+	}
+	dump, err = backfilled.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dump, want) {
+		t.Errorf("propagated database is\n%v\nwant\n%v", dump, want)
+	}
+
+	// Propagating again changes nothing
+	if err := backfilled.Propagate(0, now+3600); err != nil {
+		t.Fatal(err)
+	}
+	dump, err = backfilled.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dump, want) {
+		t.Errorf("propagating twice gave\n%v\nwant\n%v", dump, want)
+	}
+
+	if err := backfilled.Propagate(now, start); err == nil {
+		t.Error("expected an error propagating a backwards range")
+	}
+}
+
+func TestRebuildRollups(t *testing.T) {
+	dir := t.TempDir()
+	archives := []ArchiveInfo{{0, 60, 60}, {0, 300, 24}, {0, 900, 12}}
+	now := uint32(time.Now().Unix())
+	start := now - now%900 - 1800
+	var points []Point
+	for timestamp := start; timestamp < start+1800; timestamp += 60 {
+		points = append(points, Point{timestamp, float64(timestamp-start) / 60})
+	}
+
+	open := func(name string, method AggregationMethod) *Whisper {
+		path := filepath.Join(dir, name)
+		if err := Create(path, archives, 0.5, method, false); err != nil {
+			t.Fatal(err)
+		}
+		w, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.UpdateMany(points); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	expected := open("max.wsp", AGGREGATION_MAX)
+	defer expected.Close()
+	want, err := expected.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Switch a database to max and damage one of its rollups
+	w := open("sum.wsp", AGGREGATION_SUM)
+	defer w.Close()
+	if err := w.SetAggregationMethod(AGGREGATION_MAX); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writePoint(w.Header.Archives[2], Point{start, -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.RebuildRollups(); err != nil {
+		t.Fatal(err)
+	}
+	dump, err := w.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dump, want) {
+		t.Errorf("rebuilt database is\n%v\nwant\n%v", dump, want)
+	}
+}
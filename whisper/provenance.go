@@ -0,0 +1,106 @@
+package whisper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ProvenanceRecord states which source supplied the points of an archive over a range of intervals
+type ProvenanceRecord struct {
+	Archive        int    // Index of the archive in the database header
+	FromTimestamp  uint32 // First interval supplied by the source
+	UntilTimestamp uint32 // Last interval supplied by the source
+	Source         string // Identifies the source, usually its path
+}
+
+// A ProvenanceLog is an append-only sidecar file recording where the data of a database came from
+// when it was combined from several sources by Merge, Fill or Stitch.
+type ProvenanceLog struct {
+	file *os.File
+}
+
+// Returns the path of the provenance sidecar for the database at path
+func ProvenancePath(path string) string {
+	return path + ".provenance"
+}
+
+// Open the provenance sidecar of the database at path for appending, creating it if needed
+func OpenProvenanceLog(path string) (log *ProvenanceLog, err error) {
+	file, err := os.OpenFile(ProvenancePath(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return
+	}
+	log = &ProvenanceLog{file}
+	return
+}
+
+// Append a record to the log
+func (l *ProvenanceLog) Record(r ProvenanceRecord) (err error) {
+	if strings.Contains(r.Source, "\n") {
+		return errors.New(fmt.Sprintf("invalid provenance source: %q", r.Source))
+	}
+	_, err = fmt.Fprintf(l.file, "%d %d %d %s\n", r.Archive, r.FromTimestamp, r.UntilTimestamp, r.Source)
+	return
+}
+
+// Close the log
+func (l *ProvenanceLog) Close() error {
+	return l.file.Close()
+}
+
+// Read all the provenance records of the database at path, oldest first
+func ReadProvenance(path string) (records []ProvenanceRecord, err error) {
+	file, err := os.Open(ProvenancePath(path))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r ProvenanceRecord
+		fields := strings.SplitN(scanner.Text(), " ", 4)
+		if len(fields) != 4 {
+			return nil, errors.New(fmt.Sprintf("invalid provenance record: %s", scanner.Text()))
+		}
+		_, err = fmt.Sscan(strings.Join(fields[:3], " "), &r.Archive, &r.FromTimestamp, &r.UntilTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		r.Source = fields[3]
+		records = append(records, r)
+	}
+	err = scanner.Err()
+	return
+}
+
+// Record the sources of a sorted list of points written to an archive, one record for each run of
+// contiguous intervals supplied by the same source
+func (l *ProvenanceLog) recordPoints(index int, info ArchiveInfo, points archive, sources map[uint32]string) (err error) {
+	if l == nil {
+		return
+	}
+
+	var run ProvenanceRecord
+	for i, point := range points {
+		source := sources[point.Timestamp]
+		if i > 0 && source == run.Source && point.Timestamp == run.UntilTimestamp+info.SecondsPerPoint {
+			run.UntilTimestamp = point.Timestamp
+			continue
+		}
+		if i > 0 {
+			err = l.Record(run)
+			if err != nil {
+				return
+			}
+		}
+		run = ProvenanceRecord{index, point.Timestamp, point.Timestamp, source}
+	}
+	if len(points) > 0 {
+		err = l.Record(run)
+	}
+	return
+}
@@ -0,0 +1,55 @@
+package whisper
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMergeProvenance(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 10, 6}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	srcPath := filepath.Join(dir, "src.wsp")
+	dstPath := filepath.Join(dir, "dst.wsp")
+	createWithPoints(t, srcPath, info, archive{{now - 50, 1}, {now - 40, 1}, {now - 20, 1}})
+	createWithPoints(t, dstPath, info, archive{{now - 30, 2}, {now - 20, 2}, {now - 10, 2}})
+
+	src, err := OpenReadOnly(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := Open(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	log, err := OpenProvenanceLog(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = MergeWithOptions(src, dst, MergeOptions{Provenance: log})
+	log.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadProvenance(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ProvenanceRecord{
+		{0, now - 50, now - 40, srcPath},
+		{0, now - 30, now - 10, dstPath},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("got %d records, want %d: %v", len(records), len(expected), records)
+	}
+	for i := range expected {
+		if records[i] != expected[i] {
+			t.Errorf("record %d = %v, want %v", i, records[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package whisper
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrQuarantined is returned by a Pool for accesses to a database it has quarantined
+var ErrQuarantined = errors.New("whisper database is quarantined")
+
+// QuarantinePolicy decides when a Pool stops using a database that keeps failing
+type QuarantinePolicy struct {
+	MaxFailures  int                          // Consecutive failed accesses before a database is quarantined. Zero disables quarantining
+	MoveAside    bool                         // Rename quarantined databases to QuarantinePath, so other tools don't use them either
+	OnQuarantine func(path string, err error) // Called with the error that caused a quarantine, if set
+}
+
+// Returns the path a quarantined database is moved to when the QuarantinePolicy moves them aside
+func QuarantinePath(path string) string {
+	return path + ".quarantine"
+}
+
+/*
+Set the policy for quarantining databases that keep failing, instead of retrying them forever.
+
+An access fails if the database can't be opened or reread, for example because its header is
+corrupt, or if the function passed to Do returns an error from reading or writing the file. Errors
+such as a rejected point don't count. Once a database has failed policy.MaxFailures times in a row
+its handle is closed and every access fails with ErrQuarantined until Unquarantine is called.
+*/
+func (p *Pool) SetQuarantinePolicy(policy QuarantinePolicy) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.quarantine = policy
+}
+
+// Returns the quarantined databases and the error that caused each quarantine
+func (p *Pool) Quarantined() map[string]error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	quarantined := make(map[string]error, len(p.quarantined))
+	for path, err := range p.quarantined {
+		quarantined[path] = err
+	}
+	return quarantined
+}
+
+// Allow a quarantined database to be used again once it has been repaired. If it was moved aside
+// and nothing has replaced it since, it is moved back first.
+func (p *Pool) Unquarantine(path string) (err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.quarantined[path]; !ok {
+		return
+	}
+	if _, err = os.Stat(path); os.IsNotExist(err) {
+		err = os.Rename(QuarantinePath(path), path)
+		if os.IsNotExist(err) {
+			// It wasn't moved aside
+			err = nil
+		}
+	}
+	if err != nil {
+		return
+	}
+	delete(p.quarantined, path)
+	delete(p.failures, path)
+	return
+}
+
+// Count a failed access to the database at path, quarantining it if it has failed too many times
+func (p *Pool) recordFailure(path string, cause error) {
+	p.mutex.Lock()
+	policy := p.quarantine
+	p.failures[path]++
+	if policy.MaxFailures <= 0 || p.failures[path] < policy.MaxFailures {
+		p.mutex.Unlock()
+		return
+	}
+	if _, ok := p.quarantined[path]; ok {
+		// Another access has already quarantined it
+		p.mutex.Unlock()
+		return
+	}
+	p.quarantined[path] = cause
+	p.remove(path)
+	if policy.MoveAside {
+		os.Rename(path, QuarantinePath(path))
+	}
+	p.mutex.Unlock()
+
+	if policy.OnQuarantine != nil {
+		policy.OnQuarantine(path, cause)
+	}
+}
+
+// Reset the count of failed accesses to the database at path
+func (p *Pool) recordSuccess(path string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.failures, path)
+}
+
+// Returns true if an error returned while using a database points at a problem with its file
+func isStorageError(err error) bool {
+	var pathErr *os.PathError
+	return errors.As(err, &pathErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,69 @@
+package whisper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPoolQuarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupt.wsp")
+	if err := os.WriteFile(path, []byte{1, 2, 3}, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewPool(2)
+	defer pool.Close()
+	var events []string
+	pool.SetQuarantinePolicy(QuarantinePolicy{
+		MaxFailures:  2,
+		MoveAside:    true,
+		OnQuarantine: func(path string, err error) { events = append(events, path) },
+	})
+
+	read := func(w *Whisper) error { return nil }
+	for i := 0; i < 2; i++ {
+		if err := pool.Do(path, read); err == nil || err == ErrQuarantined {
+			t.Fatalf("access %d of a corrupt database: got %v", i, err)
+		}
+	}
+	if err := pool.Do(path, read); err != ErrQuarantined {
+		t.Errorf("access after quarantine: got %v, want %v", err, ErrQuarantined)
+	}
+	if len(events) != 1 || events[0] != path {
+		t.Errorf("quarantine events %v", events)
+	}
+	if _, ok := pool.Quarantined()[path]; !ok {
+		t.Errorf("%s not listed as quarantined", path)
+	}
+	if _, err := os.Stat(QuarantinePath(path)); err != nil {
+		t.Errorf("database wasn't moved aside: %v", err)
+	}
+
+	// Repair the database where it was moved to, then let the pool use it again
+	os.Remove(QuarantinePath(path))
+	createWithPoints(t, QuarantinePath(path), ArchiveInfo{0, 60, 10}, archive{})
+	if err := pool.Unquarantine(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Do(path, read); err != nil {
+		t.Errorf("access after repair: %v", err)
+	}
+	if len(pool.Quarantined()) != 0 {
+		t.Errorf("still quarantined: %v", pool.Quarantined())
+	}
+}
+
+func TestPoolQuarantineDisabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupt.wsp")
+	if err := os.WriteFile(path, []byte{1, 2, 3}, 0666); err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(2)
+	defer pool.Close()
+	for i := 0; i < 5; i++ {
+		if err := pool.Do(path, func(w *Whisper) error { return nil }); err == nil || err == ErrQuarantined {
+			t.Fatalf("access %d: got %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package whisper
+
+// Read-only filesystems can't be told apart from other errors on this platform
+
+func isReadOnlyFS(err error) bool {
+	return false
+}
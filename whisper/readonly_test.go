@@ -0,0 +1,50 @@
+//go:build unix
+
+package whisper
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Storage whose filesystem can be remounted read-only
+type remountableStorage struct {
+	*MemoryStorage
+	readOnly bool
+}
+
+func (s *remountableStorage) WriteAt(p []byte, off int64) (int, error) {
+	if s.readOnly {
+		return 0, &os.PathError{Op: "write", Path: "test.wsp", Err: syscall.EROFS}
+	}
+	return s.MemoryStorage.WriteAt(p, off)
+}
+
+func TestReadOnlyStorage(t *testing.T) {
+	storage := &remountableStorage{MemoryStorage: NewMemoryStorage(nil)}
+	w, err := CreateStorage(storage, []ArchiveInfo{{0, 60, 10}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	if err := w.UpdateMany([]Point{{now, 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	storage.readOnly = true
+	if err := w.UpdateMany([]Point{{now, 2}}); err != ErrReadOnlyStorage {
+		t.Errorf("write to a read-only filesystem: got %v, want %v", err, ErrReadOnlyStorage)
+	}
+	if !w.ReadOnly() {
+		t.Error("handle wasn't switched to read-only")
+	}
+	// Later writes fail before touching the storage, and reads keep working
+	if err := w.SetXFilesFactor(0.1); err != ErrReadOnlyStorage {
+		t.Errorf("second write: got %v, want %v", err, ErrReadOnlyStorage)
+	}
+	if point, found, err := w.ValueAt(now); err != nil || !found || point.Value != 1 {
+		t.Errorf("ValueAt after switching to read-only = %v, %t, %v", point, found, err)
+	}
+}
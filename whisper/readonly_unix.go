@@ -0,0 +1,13 @@
+//go:build unix
+
+package whisper
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Returns true if an error means the filesystem is mounted read-only
+func isReadOnlyFS(err error) bool {
+	return errors.Is(err, syscall.EROFS)
+}
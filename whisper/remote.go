@@ -0,0 +1,108 @@
+package whisper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ByteRange is a contiguous range of bytes within a whisper database
+type ByteRange struct {
+	Offset uint32 // Offset of the first byte
+	Length uint32 // Number of bytes
+}
+
+// Returns the range formatted as the value of an HTTP Range header
+func (r ByteRange) String() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+r.Length-1)
+}
+
+/*
+FetchPlan lists the reads needed to serve a fetch from a remote copy of a database, such as
+an object in S3, where each read is a separate range request. A fetch is planned in three steps:
+
+1. Read MetadataRange() and decode the Metadata.
+
+2. Read ArchiveTableRange(metadata) and decode the archive table to complete the Header.
+Steps 1 and 2 only need to happen once per database.
+
+3. Read BaseRange of the archive returned by Header.FetchArchive to find its base timestamp,
+then read the ranges returned by Header.PlanFetch and decode them with FetchPlan.Decode.
+*/
+type FetchPlan struct {
+	Archive  ArchiveInfo // The archive the fetch is served from
+	Interval Interval    // The interval the fetch returns
+	Ranges   []ByteRange // The slots to read; two ranges when the interval wraps around the end of the archive
+}
+
+// Returns the range holding the metadata of a database
+func MetadataRange() ByteRange {
+	return ByteRange{0, metadataSize}
+}
+
+// Returns the range holding the archive table of a database with the given metadata
+func ArchiveTableRange(metadata Metadata) ByteRange {
+	return ByteRange{metadataSize, archiveSize * metadata.ArchiveCount}
+}
+
+// Returns the range holding the first slot of an archive, whose timestamp is the base every other slot is relative to
+func BaseRange(archive ArchiveInfo) ByteRange {
+	return ByteRange{archive.Offset, pointSize}
+}
+
+// Returns the archive a fetch from a timestamp is served from, whose base point must be known to plan the fetch
+func (h Header) FetchArchive(from, now uint32) ArchiveInfo {
+	return h.archiveFor(from, now)
+}
+
+// PlanFetch plans the reads of a fetch between from and until, mirroring FetchUntil. base is the
+// timestamp of the first slot of the archive returned by FetchArchive.
+func (h Header) PlanFetch(from, until, now, base uint32) (plan FetchPlan, err error) {
+	if from > until {
+		return plan, errors.New("from time is not less than until time")
+	}
+	if oldest := h.earliestTime(now); from < oldest {
+		from = oldest
+	}
+	if until > now {
+		until = now
+	}
+
+	archive := h.archiveFor(from, now)
+	step := archive.SecondsPerPoint
+	fromTimestamp := quantizeTimestamp(from, step) + step
+	untilTimestamp := quantizeTimestamp(until, step) + step
+	plan.Archive = archive
+	plan.Interval = Interval{fromTimestamp, untilTimestamp, step}
+
+	fromOffset := slotOffset(archive, base, fromTimestamp)
+	untilOffset := slotOffset(archive, base, untilTimestamp)
+	if fromOffset < untilOffset {
+		plan.Ranges = []ByteRange{{fromOffset, untilOffset - fromOffset}}
+	} else {
+		plan.Ranges = []ByteRange{{fromOffset, archive.end() - fromOffset}}
+		if untilOffset > archive.Offset {
+			plan.Ranges = append(plan.Ranges, ByteRange{archive.Offset, untilOffset - archive.Offset})
+		}
+	}
+	return
+}
+
+// Decode the points read for each of the plan's ranges, given in the same order
+func (p FetchPlan) Decode(data ...[]byte) (points []Point, err error) {
+	if len(data) != len(p.Ranges) {
+		return nil, errors.New(fmt.Sprintf("plan has %d ranges, got data for %d", len(p.Ranges), len(data)))
+	}
+	for i, r := range p.Ranges {
+		if uint32(len(data[i])) != r.Length {
+			return nil, errors.New(fmt.Sprintf("range %d has %d bytes, want %d", i, len(data[i]), r.Length))
+		}
+		decoded := make([]Point, r.Length/pointSize)
+		_, err = binary.Decode(data[i], binary.BigEndian, decoded)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, decoded...)
+	}
+	return
+}
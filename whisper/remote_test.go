@@ -0,0 +1,69 @@
+package whisper
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestSlotOffset(t *testing.T) {
+	info := ArchiveInfo{40, 60, 10}
+	tests := []struct {
+		base, timestamp, offset uint32
+	}{
+		{0, 6000, 40},
+		{6000, 6000, 40},
+		{6000, 6060, 52},
+		{6000, 6119, 52},
+		{6000, 6600, 40},
+		{6000, 5940, 40 + 9*12},
+		{6000, 5939, 40 + 8*12},
+	}
+	for i, tt := range tests {
+		if offset := slotOffset(info, tt.base, tt.timestamp); offset != tt.offset {
+			t.Errorf("%d. slotOffset(%d, %d) = %d, want %d", i, tt.base, tt.timestamp, offset, tt.offset)
+		}
+	}
+}
+
+func TestPlanFetch(t *testing.T) {
+	header := newHeader([]ArchiveInfo{{0, 60, 10}, {0, 600, 10}}, 0.5, AGGREGATION_AVERAGE)
+	if r := ArchiveTableRange(header.Metadata); r != (ByteRange{16, 24}) {
+		t.Errorf("ArchiveTableRange = %v", r)
+	}
+
+	now := uint32(60000)
+	archive := header.FetchArchive(now-300, now)
+	if archive != header.Archives[0] {
+		t.Fatalf("FetchArchive = %v, want %v", archive, header.Archives[0])
+	}
+	if r := BaseRange(archive); r.String() != "bytes=40-51" {
+		t.Errorf("BaseRange = %s", r)
+	}
+
+	// The base is 7 slots before the start of the fetch, so the 5 slots wrap around the end
+	base := now - 300 - 7*60
+	plan, err := header.PlanFetch(now-300, now, now, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Interval != (Interval{now - 240, now + 60, 60}) {
+		t.Errorf("Interval = %+v", plan.Interval)
+	}
+	expected := []ByteRange{{40 + 8*12, 2 * 12}, {40, 3 * 12}}
+	if len(plan.Ranges) != 2 || plan.Ranges[0] != expected[0] || plan.Ranges[1] != expected[1] {
+		t.Fatalf("Ranges = %v, want %v", plan.Ranges, expected)
+	}
+
+	first, _ := binary.Append(nil, binary.BigEndian, []Point{{now - 240, 1}, {now - 180, 2}})
+	second, _ := binary.Append(nil, binary.BigEndian, []Point{{now - 120, 3}, {now - 60, 4}, {now, 5}})
+	points, err := plan.Decode(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 5 || points[0].Value != 1 || points[4].Value != 5 {
+		t.Errorf("Decode = %v", points)
+	}
+	if _, err := plan.Decode(first); err == nil {
+		t.Errorf("no error decoding too few ranges")
+	}
+}
@@ -0,0 +1,137 @@
+package whisper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+/*
+RenderSeries is a series named by its target. It marshals to and from the JSON form of a series in
+graphite-web's render API, with a pair of value and timestamp for every interval:
+
+	{"target": "servers.web1.cpu", "datapoints": [[1.5, 1200], [null, 1260]]}
+
+Missing values and NaN or infinite values, which JSON can't represent, are null.
+*/
+type RenderSeries struct {
+	Target string
+	Series
+}
+
+// The JSON form of a series in the render API
+type renderSeries struct {
+	Target     string        `json:"target"`
+	Datapoints [][2]*float64 `json:"datapoints"` // Pairs of value, which may be null, and timestamp
+}
+
+// Marshal the series in the render API's form. Implements json.Marshaler.
+func (s RenderSeries) MarshalJSON() ([]byte, error) {
+	out := renderSeries{s.Target, make([][2]*float64, len(s.Values))}
+	for i, value := range s.Values {
+		timestamp := float64(s.From + uint32(i)*s.Step)
+		out.Datapoints[i][0] = jsonValue(value)
+		out.Datapoints[i][1] = &timestamp
+	}
+	return json.Marshal(out)
+}
+
+// The JSON form of a Series
+type seriesJSON struct {
+	Start  uint32     `json:"start"`
+	End    uint32     `json:"end"`
+	Step   uint32     `json:"step"`
+	Values []*float64 `json:"values"`
+}
+
+/*
+Marshal the series as its interval and the value of every step:
+
+	{"start": 1200, "end": 1320, "step": 60, "values": [1.5, null]}
+
+Like RenderSeries, missing values and NaN or infinite values are null. Implements json.Marshaler.
+*/
+func (s Series) MarshalJSON() ([]byte, error) {
+	out := seriesJSON{s.From, s.Until, s.Step, make([]*float64, len(s.Values))}
+	for i, value := range s.Values {
+		out.Values[i] = jsonValue(value)
+	}
+	return json.Marshal(out)
+}
+
+// Returns a value as it can be written in JSON, nil if it is missing or not finite
+func jsonValue(value *float64) *float64 {
+	if value == nil || !isFinite(*value) {
+		return nil
+	}
+	return value
+}
+
+// Unmarshal a series in the render API's form, whose datapoints must be evenly spaced. Implements
+// json.Unmarshaler.
+func (s *RenderSeries) UnmarshalJSON(data []byte) (err error) {
+	var in renderSeries
+	err = json.Unmarshal(data, &in)
+	if err != nil {
+		return
+	}
+
+	*s = RenderSeries{Target: in.Target}
+	s.Values = make([]*float64, len(in.Datapoints))
+	for i, datapoint := range in.Datapoints {
+		timestamp := datapoint[1]
+		if timestamp == nil || *timestamp < 0 || *timestamp > math.MaxUint32 || *timestamp != math.Trunc(*timestamp) {
+			return errors.New(fmt.Sprintf("%s: invalid timestamp in datapoint %d", in.Target, i))
+		}
+		switch i {
+		case 0:
+			s.From = uint32(*timestamp)
+		case 1:
+			if uint32(*timestamp) <= s.From {
+				return errors.New(fmt.Sprintf("%s: datapoints aren't in order", in.Target))
+			}
+			s.Step = uint32(*timestamp) - s.From
+		}
+		if uint32(*timestamp) != s.From+uint32(i)*s.Step {
+			return errors.New(fmt.Sprintf("%s: datapoints aren't evenly spaced", in.Target))
+		}
+		s.Values[i] = datapoint[0]
+	}
+	s.Until = s.From + uint32(len(s.Values))*s.Step
+	return
+}
+
+// Returns the intervals of the series that have a value
+func (s Series) points() (points archive) {
+	for i, value := range s.Values {
+		if value != nil {
+			points = append(points, Point{s.From + uint32(i)*s.Step, *value})
+		}
+	}
+	return
+}
+
+// Write the values between two timestamps to out as the render API's JSON for a single target
+func (w *Whisper) ExportJSON(out io.Writer, target string, from, until uint32) (err error) {
+	series, err := w.FetchSeries(from, until)
+	if err != nil {
+		return
+	}
+	return json.NewEncoder(out).Encode([]RenderSeries{{target, series}})
+}
+
+// Write the values of a series read from the render API's JSON, as written by ExportJSON, to the
+// database. The JSON must hold a single target. Missing values are skipped.
+func (w *Whisper) ImportJSON(r io.Reader) (report UpdateReport, err error) {
+	var series []RenderSeries
+	err = json.NewDecoder(r).Decode(&series)
+	if err != nil {
+		return
+	}
+	if len(series) != 1 {
+		return report, errors.New(fmt.Sprintf("expected a single target, found %d", len(series)))
+	}
+	return w.UpdateManyWithReport(series[0].points(), UpdateOptions{})
+}
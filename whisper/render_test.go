@@ -0,0 +1,120 @@
+package whisper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRenderSeriesJSON(t *testing.T) {
+	one, nan := 1.5, math.NaN()
+	series := RenderSeries{"servers.web1.cpu", Series{1200, 1380, 60, []*float64{&one, nil, &nan}}}
+	data, err := json.Marshal(series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"target":"servers.web1.cpu","datapoints":[[1.5,1200],[null,1260],[null,1320]]}`
+	if string(data) != expected {
+		t.Errorf("got %s, want %s", data, expected)
+	}
+
+	var parsed RenderSeries
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Target != series.Target || parsed.From != 1200 || parsed.Until != 1380 || parsed.Step != 60 {
+		t.Errorf("parsed %+v", parsed)
+	}
+	if len(parsed.Values) != 3 || *parsed.Values[0] != 1.5 || parsed.Values[1] != nil || parsed.Values[2] != nil {
+		t.Errorf("parsed values %v", parsed.Values)
+	}
+
+	for _, invalid := range []string{
+		`{"target":"a","datapoints":[[1,1200],[2,1260],[3,1380]]}`,
+		`{"target":"a","datapoints":[[1,1260],[2,1200]]}`,
+		`{"target":"a","datapoints":[[1,null]]}`,
+		`{"target":"a","datapoints":[[1,-60]]}`,
+	} {
+		if err := json.Unmarshal([]byte(invalid), &parsed); err == nil {
+			t.Errorf("expected an error parsing %s", invalid)
+		}
+	}
+}
+
+func TestSeriesJSON(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 10}}, 0, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 60)
+	from := now - 300
+	err = w.UpdateMany([]Point{{from + 60, 1.5}, {from + 120, math.NaN()}, {from + 240, StaleNaN}, {from + 300, math.Inf(1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, err := w.FetchSeries(from, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A stale marker and the other values JSON can't represent are null, like missing values
+	data, err := json.Marshal(series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(`{"start":%d,"end":%d,"step":60,"values":[1.5,null,null,null,null]}`, from+60, now+60)
+	if string(data) != expected {
+		t.Errorf("got %s, want %s", data, expected)
+	}
+}
+
+func TestExportImportJSON(t *testing.T) {
+	archives := []ArchiveInfo{{0, 60, 10}}
+	src, err := NewMemory(archives, 0, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	now -= now % 60
+	if err := src.UpdateMany([]Point{{now - 240, 1}, {now - 120, 2}, {now - 60, 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := src.ExportJSON(&out, "a.b", now-300, now); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := NewMemory(archives, 0, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := dst.ImportJSON(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accepted != 3 {
+		t.Errorf("imported %d points, want 3", report.Accepted)
+	}
+
+	want, err := src.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := dst.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantText, gotText bytes.Buffer
+	want.WriteTo(&wantText)
+	got.WriteTo(&gotText)
+	if wantText.String() != gotText.String() {
+		t.Errorf("imported database is\n%s\nwant\n%s", gotText.String(), wantText.String())
+	}
+
+	if _, err := dst.ImportJSON(bytes.NewBufferString(`[]`)); err == nil {
+		t.Error("expected an error importing no targets")
+	}
+}
@@ -0,0 +1,253 @@
+package whisper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// RepairOptions controls what Repair does with a damaged database
+type RepairOptions struct {
+	DryRun   bool // Report the repairs without changing the database
+	NoBackup bool // Don't keep a copy of the damaged database at path + ".bak"
+}
+
+/*
+Repair fixes the damage CheckIntegrity finds in the whisper database at path, keeping as much of its
+data as possible. Each repair is returned as an Anomaly describing the problem that was fixed.
+
+The archive table must be intact: every archive must lie after the header and apart from the others.
+Otherwise an error is returned and the database is left alone. The rest is repaired:
+
+  - an unknown aggregation method becomes average and an invalid xFilesFactor 0.5
+  - the max retention is recomputed from the archives
+  - the file is truncated or zero extended to the size the archives imply
+  - timestamps that aren't a multiple of their archive's step are rounded down to one
+  - slots holding a timestamp in the future, or one that doesn't belong in that slot of the ring
+    buffer, are cleared. Where slots disagree on the position of the ring buffer, the position most
+    of them agree on is kept.
+
+Unless opts.NoBackup is set, a copy of the damaged database is kept at path + ".bak". Writes to the
+database fail with ErrMaintenanceInProgress while it is being repaired.
+*/
+func Repair(path string, opts RepairOptions) (repairs []Anomaly, err error) {
+	lock, err := LockForMaintenance(path)
+	if err != nil {
+		return
+	}
+	defer lock.Unlock()
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	size := info.Size()
+
+	report := func(offset int64, format string, args ...interface{}) {
+		repairs = append(repairs, Anomaly{offset, fmt.Sprintf(format, args...)})
+	}
+
+	header, err := readRepairableHeader(file, size)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: archive table is damaged beyond repair: %s", path, err))
+	}
+	metadata := &header.Metadata
+	if metadata.AggregationMethod.String() == "unknown" {
+		report(aggregationMethodOffset, "replaced unknown aggregation method %d with average", metadata.AggregationMethod)
+		metadata.AggregationMethod = AGGREGATION_AVERAGE
+	}
+	if !(metadata.XFilesFactor >= 0 && metadata.XFilesFactor <= 1) {
+		report(xFilesFactorOffset, "replaced invalid xFilesFactor %g with 0.5", metadata.XFilesFactor)
+		metadata.XFilesFactor = 0.5
+	}
+	var maxRetention uint32
+	for _, archive := range header.Archives {
+		if archive.Retention() > maxRetention {
+			maxRetention = archive.Retention()
+		}
+	}
+	if metadata.MaxRetention != maxRetention {
+		report(maxRetentionOffset, "replaced max retention %d with %d", metadata.MaxRetention, maxRetention)
+		metadata.MaxRetention = maxRetention
+	}
+	if end := int64(header.end()); size != end {
+		report(end, "resized file from %d to %d bytes", size, end)
+	}
+
+	// Work out the repaired slots of every archive
+	now := uint32(time.Now().Unix())
+	fixed := make([][]Point, len(header.Archives))
+	for i, archive := range header.Archives {
+		slots, e := readSlotsPadded(file, archive)
+		if e != nil {
+			return nil, e
+		}
+		if repairSlots(i, archive, slots, now, report) {
+			fixed[i] = slots
+		}
+	}
+
+	if opts.DryRun || len(repairs) == 0 {
+		return
+	}
+
+	if !opts.NoBackup {
+		backupPath := path + ".bak"
+		os.Remove(backupPath)
+		err = CloneFile(path, backupPath)
+		if err != nil {
+			return
+		}
+	}
+
+	w := &Whisper{Header: header, path: path, storage: file}
+	err = file.Truncate(int64(header.end()))
+	if err != nil {
+		return
+	}
+	err = w.writeAt(0, header.Metadata)
+	if err != nil {
+		return
+	}
+	for i, slots := range fixed {
+		if slots == nil {
+			continue
+		}
+		info := header.Archives[i]
+		if slots[0].Timestamp == 0 {
+			// The base point is gone, so lay the points out again starting from the oldest
+			points := archive{}
+			for _, slot := range slots {
+				if slot.Timestamp != 0 {
+					points = append(points, slot)
+				}
+			}
+			sort.Sort(points)
+			err = w.writeArchive(info, points)
+		} else {
+			err = w.writeAt(info.Offset, slots)
+		}
+		if err != nil {
+			return
+		}
+	}
+	err = w.sync()
+	return
+}
+
+// Read the header of a damaged database, checking that the archive table is usable
+func readRepairableHeader(file *os.File, size int64) (header Header, err error) {
+	if size < int64(metadataSize) {
+		return header, errors.New(fmt.Sprintf("file is %d bytes, too small to hold the metadata", size))
+	}
+	err = readFrom(file, 0, &header.Metadata)
+	if err != nil {
+		return
+	}
+	count := header.Metadata.ArchiveCount
+	headerEnd := int64(metadataSize) + int64(count)*int64(archiveSize)
+	if count == 0 || headerEnd > size {
+		return header, errors.New(fmt.Sprintf("archive count %d doesn't fit a %d byte file", count, size))
+	}
+	header.Archives = make([]ArchiveInfo, count)
+	err = readFrom(file, int64(metadataSize), header.Archives)
+	if err != nil {
+		return
+	}
+
+	sorted := append([]ArchiveInfo(nil), header.Archives...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+	for i, archive := range sorted {
+		if archive.SecondsPerPoint == 0 || archive.Points == 0 {
+			return header, errors.New(fmt.Sprintf("archive at %d has %d points every %d seconds", archive.Offset, archive.Points, archive.SecondsPerPoint))
+		}
+		if int64(archive.Offset) < headerEnd {
+			return header, errors.New(fmt.Sprintf("archive at %d starts inside the header", archive.Offset))
+		}
+		if i > 0 && archive.Offset < sorted[i-1].end() {
+			return header, errors.New(fmt.Sprintf("archive at %d overlaps the archive at %d", archive.Offset, sorted[i-1].Offset))
+		}
+	}
+	return
+}
+
+// Read the slots of an archive, treating any part of it past the end of the file as empty
+func readSlotsPadded(file *os.File, archive ArchiveInfo) (slots []Point, err error) {
+	buf := make([]byte, int(archive.Points)*int(pointSize))
+	_, err = file.ReadAt(buf, int64(archive.Offset))
+	if err == io.EOF {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	slots = make([]Point, archive.Points)
+	_, err = binary.Decode(buf, binary.BigEndian, slots)
+	return
+}
+
+// Repair the slots of an archive in place, reporting every change. Returns true if any slot changed.
+func repairSlots(index int, archive ArchiveInfo, slots []Point, now uint32, report func(offset int64, format string, args ...interface{})) (changed bool) {
+	step := archive.SecondsPerPoint
+	retention := uint64(archive.Retention())
+	offset := func(slot int) int64 {
+		return int64(archive.Offset) + int64(slot)*int64(pointSize)
+	}
+
+	// The position of the ring buffer each slot implies, as the timestamp of the first slot modulo
+	// the retention, and how many slots agree on each position
+	positions := make(map[uint64]int)
+	position := func(slot int) uint64 {
+		return (uint64(slots[slot].Timestamp)%retention + retention - uint64(slot)*uint64(step)%retention) % retention
+	}
+	for i, slot := range slots {
+		if slot.Timestamp == 0 {
+			continue
+		}
+		if slot.Timestamp > now+step {
+			report(offset(i), "cleared timestamp %d of archive %d, which is in the future", slot.Timestamp, index)
+			slots[i] = Point{}
+			changed = true
+			continue
+		}
+		if slot.Timestamp%step != 0 {
+			quantized := quantizeTimestamp(slot.Timestamp, step)
+			report(offset(i), "rounded timestamp %d of archive %d down to %d", slot.Timestamp, index, quantized)
+			slots[i].Timestamp = quantized
+			changed = true
+		}
+		positions[position(i)]++
+	}
+
+	// Ties go to the smallest position, so the choice doesn't depend on map order
+	var best uint64
+	bestVotes := 0
+	for p, votes := range positions {
+		if votes > bestVotes || votes == bestVotes && p < best {
+			best, bestVotes = p, votes
+		}
+	}
+	for i, slot := range slots {
+		if slot.Timestamp != 0 && position(i) != best {
+			report(offset(i), "cleared timestamp %d of archive %d, which doesn't belong in this slot", slot.Timestamp, index)
+			slots[i] = Point{}
+			changed = true
+		}
+	}
+
+	if slots[0].Timestamp == 0 && len(positions) > 0 {
+		// Readers take the first slot as the base point and treat the archive as empty without it
+		report(offset(0), "moved the points of archive %d so its first slot holds the oldest", index)
+		changed = true
+	}
+	return
+}
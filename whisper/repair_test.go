@@ -0,0 +1,118 @@
+package whisper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create a database and damage it by editing its bytes
+func createDamaged(t *testing.T, path string, damage func(data []byte, offset uint32) []byte) {
+	createWithPoints(t, path, ArchiveInfo{0, 60, 10}, archive{{600, 1}, {660, 2}, {720, 3}})
+	w, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := w.Header.Archives[0].Offset
+	w.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, damage(data, offset), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	createDamaged(t, path, func(data []byte, offset uint32) []byte {
+		binary.BigEndian.PutUint32(data[xFilesFactorOffset:], math.Float32bits(2))
+		binary.BigEndian.PutUint32(data[offset+pointSize:], 661)   // Rounded down to 660
+		binary.BigEndian.PutUint32(data[offset+2*pointSize:], 780) // Belongs in the slot after
+		return append(data, 0, 0, 0)
+	})
+	damaged, _ := os.ReadFile(path)
+
+	repairs, err := Repair(path, RepairOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repairs) != 4 {
+		t.Errorf("dry run found repairs %v, want 4", repairs)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, damaged) {
+		t.Error("dry run changed the database")
+	}
+
+	if _, err := Repair(path, RepairOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if anomalies, err := CheckIntegrity(path); err != nil || len(anomalies) != 0 {
+		t.Errorf("repaired database has anomalies %v, %v", anomalies, err)
+	}
+	if backup, _ := os.ReadFile(path + ".bak"); !bytes.Equal(backup, damaged) {
+		t.Error("backup doesn't hold the damaged database")
+	}
+
+	w, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Header.Metadata.XFilesFactor != 0.5 {
+		t.Errorf("xFilesFactor = %g, want 0.5", w.Header.Metadata.XFilesFactor)
+	}
+	slots, err := w.ReadSlots(w.Header.Archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Point{{600, 1}, {660, 2}, {}}
+	for i, point := range expected {
+		if slots[i] != point {
+			t.Errorf("slot %d = %v, want %v", i, slots[i], point)
+		}
+	}
+}
+
+func TestRepairLostBase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	createDamaged(t, path, func(data []byte, offset uint32) []byte {
+		copy(data[offset:offset+pointSize], make([]byte, pointSize))
+		return data
+	})
+
+	if _, err := Repair(path, RepairOptions{NoBackup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Error("backup was kept")
+	}
+	w, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	slots, err := w.ReadSlots(w.Header.Archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slots[0] != (Point{660, 2}) || slots[1] != (Point{720, 3}) {
+		t.Errorf("slots after repair start %v, want the oldest remaining point first", slots[:2])
+	}
+}
+
+func TestRepairDamagedArchiveTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	createDamaged(t, path, func(data []byte, offset uint32) []byte {
+		binary.BigEndian.PutUint32(data[metadataSize:], 0) // Archive starts at 0, inside the header
+		return data
+	})
+	if _, err := Repair(path, RepairOptions{}); err == nil {
+		t.Error("repaired a database with a damaged archive table")
+	}
+}
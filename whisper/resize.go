@@ -0,0 +1,212 @@
+package whisper
+
+import (
+	"context"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// ResizeOptions controls how Resize migrates data to a new archive layout
+type ResizeOptions struct {
+	XFilesFactor       *float32                 // xFilesFactor of the new database. Keeps the current value if nil
+	AggregationMethod  AggregationMethod        // Aggregation method of the new database. Keeps the current method if AGGREGATION_UNKNOWN
+	Aggregate          bool                     // Aggregate high precision points when moving them to a lower precision archive, instead of keeping the last one
+	NoBackup           bool                     // Don't keep a copy of the original database at path + ".bak"
+	AggregateNonFinite AggregateNonFinitePolicy // How NaN and infinite values are treated when aggregating
+}
+
+/*
+Resize changes the archive layout of the whisper database at path, like whisper-resize.py.
+
+A new database with the given archives is created next to the original at path + ".tmp", replacing
+any left there by an interrupted resize. The data of every old archive is migrated into each new
+archive, preferring the highest precision data available for every interval. The new database is
+then renamed over the original. Unless opts.NoBackup is set, the original is kept at path + ".bak".
+Archives frozen by FreezeArchive are thawed, as the new layout has different archives, and the saved
+interval filter is removed. Writes to the original fail with ErrMaintenanceInProgress while the
+resize is running, and with ErrReplaced after it has finished, until the handle is refreshed with
+Refresh.
+*/
+func Resize(path string, newArchives []ArchiveInfo, opts ResizeOptions) (err error) {
+	return ResizeContext(context.Background(), path, newArchives, opts)
+}
+
+// ResizeContext changes the archive layout of a database like Resize, giving up with ctx's error
+// if it is cancelled before the new database replaces the original. The original is left as it was.
+func ResizeContext(ctx context.Context, path string, newArchives []ArchiveInfo, opts ResizeOptions) (err error) {
+	err = ValidateArchiveList(newArchives)
+	if err != nil {
+		return
+	}
+
+	lock, err := LockForMaintenance(path)
+	if err != nil {
+		return
+	}
+	defer lock.Unlock()
+
+	old, err := OpenReadOnly(path)
+	if err != nil {
+		return
+	}
+	defer old.Close()
+
+	xFilesFactor := old.Header.Metadata.XFilesFactor
+	if opts.XFilesFactor != nil {
+		xFilesFactor = *opts.XFilesFactor
+	}
+	aggregationMethod := old.Header.Metadata.AggregationMethod
+	if opts.AggregationMethod != AGGREGATION_UNKNOWN {
+		aggregationMethod = opts.AggregationMethod
+	}
+
+	// Read all the existing data, highest precision first
+	now := uint32(time.Now().Unix())
+	oldPoints := make([]archive, len(old.Header.Archives))
+	for i, info := range old.Header.Archives {
+		points, e := old.readArchiveContext(ctx, info)
+		if e != nil {
+			return e
+		}
+		oldPoints[i] = livePoints(info, points, now)
+	}
+
+	// A resize that was killed may have left its new database behind. The maintenance lock
+	// ensures no other resize is using it.
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+	err = Create(tmpPath, newArchives, xFilesFactor, aggregationMethod, false)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	w, err := Open(tmpPath)
+	if err != nil {
+		return
+	}
+	defer w.Close()
+
+	for _, info := range w.Header.Archives {
+		var buckets map[uint32]Point
+		if opts.Aggregate {
+			buckets, err = aggregateBuckets(old.Header.Archives, oldPoints, info, aggregationMethod, xFilesFactor, opts.AggregateNonFinite)
+			if err != nil {
+				return
+			}
+		} else {
+			buckets = lastValueBuckets(oldPoints, info)
+		}
+
+		err = w.writeArchiveContext(ctx, info, livePoints(info, sortedPoints(buckets), now))
+		if err != nil {
+			return
+		}
+	}
+
+	err = w.sync()
+	if err != nil {
+		return
+	}
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+
+	if !opts.NoBackup {
+		backupPath := path + ".bak"
+		os.Remove(backupPath)
+		err = os.Link(path, backupPath)
+		if err != nil {
+			return
+		}
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return
+	}
+
+	// Frozen archives refer to the old layout, and the interval filter to the old points
+	err = os.Remove(FrozenPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	return removeIntervalFilter(path)
+}
+
+// Filter the points of an archive down to those which have been written and are still within its retention
+func livePoints(info ArchiveInfo, points archive, now uint32) archive {
+	oldest := info.StartTime(now)
+	live := archive{}
+	for _, point := range points {
+		if point.Timestamp != 0 && point.Timestamp > oldest {
+			live = append(live, point)
+		}
+	}
+	return live
+}
+
+// Place the points of each source archive into the intervals of an archive, keeping the value from
+// the highest precision source and the latest point within each interval.
+// The sources must be ordered from highest to lowest precision.
+func lastValueBuckets(sources []archive, info ArchiveInfo) map[uint32]Point {
+	buckets := make(map[uint32]Point)
+	for i := len(sources) - 1; i >= 0; i-- {
+		points := append(archive{}, sources[i]...)
+		sort.Sort(points)
+		for _, point := range points {
+			point.Timestamp = quantizeTimestamp(point.Timestamp, info.SecondsPerPoint)
+			buckets[point.Timestamp] = point
+		}
+	}
+	return buckets
+}
+
+// Aggregate the points of each source archive into the intervals of an archive, using the highest
+// precision source that has data for each interval. Intervals with fewer known points than the
+// xFilesFactor requires are left empty.
+// The sources must be ordered from highest to lowest precision.
+func aggregateBuckets(infos []ArchiveInfo, sources []archive, info ArchiveInfo, method AggregationMethod, xFilesFactor float32, policy AggregateNonFinitePolicy) (buckets map[uint32]Point, err error) {
+	buckets = make(map[uint32]Point)
+	for i, source := range sources {
+		groups := make(map[uint32]archive)
+		for _, point := range source {
+			interval := quantizeTimestamp(point.Timestamp, info.SecondsPerPoint)
+			if _, ok := buckets[interval]; ok {
+				// Already filled from a higher precision archive
+				continue
+			}
+			groups[interval] = append(groups[interval], point)
+		}
+
+		for interval, group := range groups {
+			sort.Sort(group)
+			if infos[i].SecondsPerPoint >= info.SecondsPerPoint {
+				buckets[interval] = Point{interval, group[len(group)-1].Value}
+				continue
+			}
+
+			known, poisoned := filterNonFinite(policy, group)
+			slots := info.SecondsPerPoint / infos[i].SecondsPerPoint
+			if len(known) == 0 || float32(len(known))/float32(slots) < xFilesFactor {
+				continue
+			}
+			point, e := aggregate(method, known)
+			if e != nil {
+				return nil, e
+			}
+			if poisoned {
+				point.Value = math.NaN()
+			}
+			point.Timestamp = interval
+			buckets[interval] = point
+		}
+	}
+	return
+}
@@ -0,0 +1,168 @@
+package whisper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.wsp")
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 60)
+	createWithPoints(t, path, ArchiveInfo{0, 10, 60}, archive{
+		{now - 120, 1}, {now - 110, 2}, {now - 100, 3},
+		{now - 60, 4}, {now - 50, 8},
+	})
+
+	tests := []struct {
+		opts     ResizeOptions
+		expected archive
+	}{
+		{ResizeOptions{NoBackup: true}, archive{{now - 120, 3}, {now - 60, 8}}},
+		// Only 2 of the 6 points in the second interval are known, below the xFilesFactor of 0.5
+		{ResizeOptions{NoBackup: true, Aggregate: true}, archive{{now - 120, 2}, {0, 0}}},
+	}
+
+	for i, tt := range tests {
+		backup := path + ".orig"
+		if err := os.Link(path, backup); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := Resize(path, []ArchiveInfo{{0, 60, 10}}, tt.opts); err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+
+		w, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(w.Header.Archives) != 1 || w.Header.Archives[0].SecondsPerPoint != 60 {
+			t.Errorf("%d. unexpected archives %v", i, w.Header.Archives)
+		}
+		points, err := w.readArchive(w.Header.Archives[0])
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := range tt.expected {
+			if points[j] != tt.expected[j] {
+				t.Errorf("%d. slot %d = %v, want %v", i, j, points[j], tt.expected[j])
+			}
+		}
+
+		if err := os.Rename(backup, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResizeBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	createWithPoints(t, path, ArchiveInfo{0, 10, 60}, nil)
+
+	xFilesFactor := float32(0.1)
+	opts := ResizeOptions{XFilesFactor: &xFilesFactor, AggregationMethod: AGGREGATION_MAX}
+	if err := Resize(path, []ArchiveInfo{{0, 60, 10}}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := Open(path + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup.Close()
+	if backup.Header.Archives[0].SecondsPerPoint != 10 {
+		t.Errorf("backup has archives %v", backup.Header.Archives)
+	}
+
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if w.Header.Metadata.XFilesFactor != xFilesFactor || w.Header.Metadata.AggregationMethod != AGGREGATION_MAX {
+		t.Errorf("metadata not updated: %+v", w.Header.Metadata)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind")
+	}
+}
+
+func TestRefreshAfterResize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	createWithPoints(t, path, ArchiveInfo{0, 10, 60}, nil)
+
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { w.Close() }()
+
+	if reopened, err := w.Refresh(); reopened || err != nil {
+		t.Fatalf("Refresh() before resize = %v, %v", reopened, err)
+	}
+	if err := Resize(path, []ArchiveInfo{{0, 60, 10}}, ResizeOptions{NoBackup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if reopened, err := w.Refresh(); !reopened || err != nil {
+		t.Fatalf("Refresh() after resize = %v, %v", reopened, err)
+	}
+	if w.Header.Archives[0].SecondsPerPoint != 60 {
+		t.Errorf("header not reloaded: %v", w.Header.Archives)
+	}
+}
+
+func TestResizeContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 60)
+	createWithPoints(t, path, ArchiveInfo{0, 10, 60}, archive{{now - 120, 1}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ResizeContext(ctx, path, []ArchiveInfo{{0, 60, 10}}, ResizeOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary database left behind: %v", err)
+	}
+
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if len(w.Header.Archives) != 1 || w.Header.Archives[0].SecondsPerPoint != 10 {
+		t.Errorf("cancelled resize changed the archives to %v", w.Header.Archives)
+	}
+}
+
+func TestResizeLeftoverTmp(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.wsp")
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 60)
+	createWithPoints(t, path, ArchiveInfo{0, 10, 60}, archive{{now - 60, 4}})
+
+	// An interrupted resize leaves its new database behind
+	if err := os.WriteFile(path+".tmp", []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Resize(path, []ArchiveInfo{{0, 60, 10}}, ResizeOptions{NoBackup: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("tmp database left behind: %v", err)
+	}
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if len(w.Header.Archives) != 1 || w.Header.Archives[0].SecondsPerPoint != 60 {
+		t.Errorf("unexpected archives %v", w.Header.Archives)
+	}
+}
@@ -0,0 +1,115 @@
+package whisper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Returns the path of the rollup of the database at path that holds values aggregated with method
+func RollupPath(path string, method AggregationMethod) string {
+	return path + ".rollup." + method.String()
+}
+
+/*
+UpdateRollups brings the rollups of the whisper database at path up to date. A rollup is a whisper
+database next to the original, at RollupPath, with a single long archive holding the data of the
+original aggregated with one method, eg: the daily minimum, maximum and average. Long range queries
+can read the rollup instead of aggregating the original's archives every time.
+
+Rollups that don't exist are created with the given archive. Only intervals that have ended and are
+newer than the newest point of a rollup are added to it, so UpdateRollups is cheap to call
+periodically. Intervals are aggregated from the highest precision archive of the original holding
+data for them, and are left empty if fewer points are known than the original's xFilesFactor requires.
+*/
+func UpdateRollups(path string, info ArchiveInfo, methods []AggregationMethod) (err error) {
+	source, err := OpenReadOnly(path)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	now := uint32(time.Now().Unix())
+	points := make([]archive, len(source.Header.Archives))
+	for i, sourceInfo := range source.Header.Archives {
+		slots, e := source.ReadSlots(sourceInfo)
+		if e != nil {
+			return e
+		}
+		points[i] = livePoints(sourceInfo, slots, now)
+	}
+	// Intervals that are still in progress are left for the next update
+	current := quantizeTimestamp(now, info.SecondsPerPoint)
+
+	for _, method := range methods {
+		rollup, e := openRollup(RollupPath(path, method), info, method)
+		if e != nil {
+			return e
+		}
+		err = updateRollup(rollup, source, points, method, current)
+		rollup.Close()
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", RollupPath(path, method), err))
+		}
+	}
+	return
+}
+
+// Open the rollup at path, creating it with a single archive if it doesn't exist
+func openRollup(path string, info ArchiveInfo, method AggregationMethod) (rollup *Whisper, err error) {
+	err = Create(path, []ArchiveInfo{info}, 0, method, false)
+	if err != nil && !os.IsExist(err) {
+		return
+	}
+	rollup, err = Open(path)
+	if err != nil {
+		return
+	}
+	archives := rollup.Header.Archives
+	if len(archives) != 1 || archives[0].SecondsPerPoint != info.SecondsPerPoint || archives[0].Points != info.Points {
+		rollup.Close()
+		return nil, errors.New(fmt.Sprintf("%s doesn't have a single archive of %d points every %ds", path, info.Points, info.SecondsPerPoint))
+	}
+	return
+}
+
+// Aggregate the intervals of the source newer than the rollup's newest point and before current into the rollup
+func updateRollup(rollup, source *Whisper, points []archive, method AggregationMethod, current uint32) (err error) {
+	info := rollup.Header.Archives[0]
+	slots, err := rollup.ReadSlots(info)
+	if err != nil {
+		return
+	}
+	var newest uint32
+	for _, slot := range slots {
+		if slot.Timestamp > newest {
+			newest = slot.Timestamp
+		}
+	}
+
+	buckets, err := aggregateBuckets(source.Header.Archives, points, info, method, source.Header.Metadata.XFilesFactor, AGGREGATE_SKIP_NONFINITE)
+	if err != nil {
+		return
+	}
+	var update []Point
+	for _, point := range sortedPoints(buckets) {
+		if point.Timestamp > newest && point.Timestamp < current {
+			update = append(update, point)
+		}
+	}
+	if len(update) == 0 {
+		return
+	}
+	return rollup.UpdateMany(update)
+}
+
+// Fetch the values of the rollup of the database at path aggregated with method between two timestamps
+func FetchRollup(path string, method AggregationMethod, from, until uint32) (series Series, err error) {
+	rollup, err := OpenReadOnly(RollupPath(path, method))
+	if err != nil {
+		return
+	}
+	defer rollup.Close()
+	return rollup.FetchSeries(from, until)
+}
@@ -0,0 +1,63 @@
+package whisper
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpdateRollups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metric.wsp")
+	minute := quantizeTimestamp(uint32(time.Now().Unix()), 60)
+	start := minute - 300
+	var points archive
+	for ts := start; ts < minute; ts += 10 {
+		points = append(points, Point{ts, float64((ts - start) / 10)})
+	}
+	createWithPoints(t, path, ArchiveInfo{0, 10, 360}, points)
+
+	info := ArchiveInfo{0, 60, 60}
+	methods := []AggregationMethod{AGGREGATION_MIN, AGGREGATION_MAX}
+	if err := UpdateRollups(path, info, methods); err != nil {
+		t.Fatal(err)
+	}
+	check := func(method AggregationMethod, expected func(k int) float64) {
+		series, err := FetchRollup(path, method, start-60, minute-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if series.From != start || series.Step != 60 || len(series.Values) != 5 {
+			t.Fatalf("%s: series %d step %d with %d values", method, series.From, series.Step, len(series.Values))
+		}
+		for k, value := range series.Values {
+			if value == nil || *value != expected(k) {
+				t.Errorf("%s: minute %d = %v, want %g", method, k, value, expected(k))
+			}
+		}
+	}
+	check(AGGREGATION_MIN, func(k int) float64 { return float64(6 * k) })
+	check(AGGREGATION_MAX, func(k int) float64 { return float64(6*k + 5) })
+
+	// Intervals already in the rollup aren't aggregated again
+	rollup, err := Open(RollupPath(path, AGGREGATION_MIN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rollup.UpdateMany([]Point{{start, -1}}); err != nil {
+		t.Fatal(err)
+	}
+	rollup.Close()
+	if err := UpdateRollups(path, info, methods); err != nil {
+		t.Fatal(err)
+	}
+	check(AGGREGATION_MIN, func(k int) float64 {
+		if k == 0 {
+			return -1
+		}
+		return float64(6 * k)
+	})
+
+	if err := UpdateRollups(path, ArchiveInfo{0, 3600, 24}, methods); err == nil {
+		t.Error("expected an error for a rollup with a different archive")
+	}
+}
@@ -0,0 +1,73 @@
+package whisper
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// SeriesInfo describes the series a database holds, so a database copied out of its directory
+// still says what it measures
+type SeriesInfo struct {
+	Name        string `json:"name,omitempty"`        // The original metric name, eg: servers.web1.cpu.user
+	Unit        string `json:"unit,omitempty"`        // Unit of the values, eg: percent
+	Description string `json:"description,omitempty"` // Free form description of the series
+}
+
+// Returns the path of the series info sidecar for the database at path
+func SeriesInfoPath(path string) string {
+	return path + ".info"
+}
+
+// Read the series info of the database. Returns an empty SeriesInfo if none has been set.
+func (w *Whisper) SeriesInfo() (info SeriesInfo, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	if w.path == "" {
+		return
+	}
+	data, err := os.ReadFile(SeriesInfoPath(w.path))
+	if os.IsNotExist(err) {
+		return info, nil
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &info)
+	return
+}
+
+// Store the series info of the database in a sidecar file next to it. Setting an empty SeriesInfo
+// removes the sidecar.
+func (w *Whisper) SetSeriesInfo(info SeriesInfo) (err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.readOnly {
+		return w.errReadOnly()
+	}
+	if w.path == "" {
+		return errors.New("series info can only be stored for databases opened from a file")
+	}
+
+	path := SeriesInfoPath(w.path)
+	if info == (SeriesInfo{}) {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, append(data, '\n'), 0666)
+	if err != nil {
+		return
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,81 @@
+package whisper
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSeriesInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user.wsp")
+	createWithPoints(t, path, ArchiveInfo{0, 60, 10}, archive{})
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if info, err := w.SeriesInfo(); err != nil || info != (SeriesInfo{}) {
+		t.Errorf("SeriesInfo() = %+v, %v before it was set", info, err)
+	}
+
+	expected := SeriesInfo{Name: "servers.web1.cpu.user", Unit: "percent", Description: "Time spent in user mode\nacross all cores"}
+	if err := w.SetSeriesInfo(expected); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if info, err := reopened.SeriesInfo(); err != nil || info != expected {
+		t.Errorf("SeriesInfo() = %+v, %v, want %+v", info, err, expected)
+	}
+	if err := reopened.SetSeriesInfo(expected); err != ErrReadOnly {
+		t.Errorf("SetSeriesInfo on a read-only handle returned %v", err)
+	}
+
+	if err := w.SetSeriesInfo(SeriesInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := w.SeriesInfo(); err != nil || info != (SeriesInfo{}) {
+		t.Errorf("SeriesInfo() = %+v, %v after clearing it", info, err)
+	}
+}
+
+func TestConcurrentSeriesInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user.wsp")
+	createWithPoints(t, path, ArchiveInfo{0, 60, 10}, archive{})
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := w.SetSeriesInfo(SeriesInfo{Name: "servers.web1.cpu.user", Unit: fmt.Sprint(i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := w.SeriesInfo(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	info, err := w.SeriesInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "servers.web1.cpu.user" {
+		t.Errorf("SeriesInfo() = %+v", info)
+	}
+}
@@ -0,0 +1,109 @@
+package whisper
+
+import (
+	"context"
+	"math"
+	"os"
+	"time"
+)
+
+// StaleNaN is the value of staleness markers. It is a NaN with a distinct bit pattern, so it can be
+// told apart from NaN values written by clients.
+var StaleNaN = math.Float64frombits(staleNaNBits)
+
+const staleNaNBits = 0x7ff0000000000002
+
+// Returns true if a value is a staleness marker written by MarkStale
+func IsStale(value float64) bool {
+	return math.Float64bits(value) == staleNaNBits
+}
+
+/*
+Returns the time of the last write to the database. Writes through the handle are tracked directly;
+before the first of those the modification time of the database file is used, so the watermark
+survives reopening without storing anything extra. Staleness markers don't count as writes: when
+the newest point of the database is a marker, which modified the file, the time of the newest point
+that isn't one is used instead. Returns the zero time if the database isn't a file and hasn't been
+written through the handle.
+*/
+func (w *Whisper) LastUpdate() (time.Time, error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.lastUpdateTime()
+}
+
+func (w *Whisper) lastUpdateTime() (last time.Time, err error) {
+	if !w.lastUpdate.IsZero() {
+		return w.lastUpdate, nil
+	}
+	marked, err := w.markedStale()
+	if err != nil {
+		return
+	}
+	if marked {
+		return w.newestUnmarked()
+	}
+	if file, ok := w.storage.(*os.File); ok {
+		info, err := file.Stat()
+		if err != nil {
+			return last, err
+		}
+		last = info.ModTime()
+	}
+	return
+}
+
+// Returns true if the newest point of the highest precision archive is a staleness marker
+func (w *Whisper) markedStale() (marked bool, err error) {
+	point, found, err := w.latestMatching(w.Header.Archives[0], func(Point) bool { return true })
+	return found && IsStale(point.Value), err
+}
+
+// Returns the time of the newest point that isn't a staleness marker, or the zero time if there is none
+func (w *Whisper) newestUnmarked() (newest time.Time, err error) {
+	for _, archive := range w.Header.Archives {
+		point, found, err := w.latestIn(archive)
+		if err != nil || found {
+			return time.Unix(int64(point.Timestamp), 0), err
+		}
+	}
+	return
+}
+
+/*
+MarkStale writes a staleness marker for the current interval if the database hasn't been written
+to for longer than after, so readers can tell that the metric stopped reporting rather than
+reporting nothing. Returns true if a marker was written. A database whose newest point is already a
+marker isn't marked again.
+
+Markers are StaleNaN values. They are written regardless of the handle's NonFinitePolicy, but
+otherwise like any update, through the journal and the sync policy. They don't count as updates for
+LastUpdate, and like other non-finite values are skipped when aggregating unless the handle's
+AggregateNonFinitePolicy says otherwise.
+*/
+func (w *Whisper) MarkStale(after time.Duration) (marked bool, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err = w.beginWrite()
+	if err != nil {
+		return
+	}
+	defer w.endWrite()
+
+	marked, err = w.markedStale()
+	if err != nil || marked {
+		return false, err
+	}
+	last, err := w.lastUpdateTime()
+	if err != nil || time.Since(last) <= after {
+		return
+	}
+
+	marker := []Point{{uint32(time.Now().Unix()), StaleNaN}}
+	_, err = w.writeJournaled(context.Background(), marker, UpdateOptions{})
+	if err != nil {
+		return
+	}
+	return true, nil
+}
@@ -0,0 +1,118 @@
+package whisper
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIsStale(t *testing.T) {
+	if !IsStale(StaleNaN) || IsStale(math.NaN()) || IsStale(0) {
+		t.Error("IsStale should only match StaleNaN")
+	}
+}
+
+func TestLastUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metric.wsp")
+	createWithPoints(t, path, ArchiveInfo{0, 60, 1440}, archive{})
+
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	created, err := w.LastUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(created) > time.Minute {
+		t.Errorf("expected the file's modification time, got %v", created)
+	}
+
+	if marked, err := w.MarkStale(time.Hour); err != nil || marked {
+		t.Errorf("marked a fresh database stale: %v, %v", marked, err)
+	}
+
+	// Pretend the last write was long ago
+	w.lastUpdate = time.Now().Add(-2 * time.Hour)
+	marked, err := w.MarkStale(time.Hour)
+	if err != nil || !marked {
+		t.Fatalf("expected a marker to be written: %v, %v", marked, err)
+	}
+	if last, _ := w.LastUpdate(); time.Since(last) < time.Hour {
+		t.Error("writing a marker counted as an update")
+	}
+
+	points, err := w.readArchive(w.Header.Archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, point := range points {
+		if IsStale(point.Value) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("no staleness marker was written")
+	}
+
+	if err := w.UpdateMany([]Point{{uint32(time.Now().Unix()), 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := w.LastUpdate(); time.Since(last) > time.Minute {
+		t.Errorf("LastUpdate = %v after a write", last)
+	}
+}
+
+func TestMarkStaleAfterReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metric.wsp")
+	now := uint32(time.Now().Unix())
+	written := quantizeTimestamp(now-3600, 60)
+	createWithPoints(t, path, ArchiveInfo{0, 60, 1440}, archive{{written, 1}})
+	hourAgo := time.Unix(int64(written), 0)
+	if err := os.Chtimes(path, hourAgo, hourAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.SetJournaling(true)
+	w.SetSyncPolicy(SYNC_PERIODIC, time.Hour)
+
+	marked, err := w.MarkStale(30 * time.Minute)
+	if err != nil || !marked {
+		t.Fatalf("expected a marker to be written: %v, %v", marked, err)
+	}
+	// The marker went through the same write path as updates
+	if !w.dirty {
+		t.Error("writing a marker didn't apply the sync policy")
+	}
+	if _, err := os.Stat(JournalPath(path)); !os.IsNotExist(err) {
+		t.Errorf("journal left behind after writing a marker: %v", err)
+	}
+
+	// The file was modified by the marker, but LastUpdate still gives the last real write, also
+	// after reopening
+	reopened, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for _, handle := range []*Whisper{w, reopened} {
+		if last, err := handle.LastUpdate(); err != nil || !last.Equal(hourAgo) {
+			t.Errorf("LastUpdate = %v, %v after marking stale, want %v", last, err, hourAgo)
+		}
+	}
+
+	// A database already marked stale isn't marked again
+	if marked, err := w.MarkStale(30 * time.Minute); err != nil || marked {
+		t.Errorf("marked a database stale twice: %v, %v", marked, err)
+	}
+}
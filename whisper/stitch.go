@@ -0,0 +1,130 @@
+package whisper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// OverlapPolicy decides which value is kept when more than one source holds a point for the same interval
+type OverlapPolicy int
+
+// Valid overlap policies
+const (
+	OVERLAP_KEEP_FIRST OverlapPolicy = iota // Keep the value from the source listed first
+	OVERLAP_KEEP_LAST                       // Keep the value from the source listed last
+)
+
+// StitchOptions controls how Stitch combines its parts
+type StitchOptions struct {
+	Policy     OverlapPolicy  // Decides which part wins when parts overlap
+	Provenance *ProvenanceLog // If set, the part that supplied every interval is recorded here
+}
+
+/*
+Stitch merges a list of time-partitioned whisper databases back into a single database at dst.
+
+All of the parts must share the same archive layout. The new database is created with the archives,
+xFilesFactor and aggregation method of the first part. Points are copied archive by archive; when more
+than one part holds a point for the same interval opts.Policy decides which value is kept. If the
+stitched data covers more time than an archive can hold, the newest points are kept.
+*/
+func Stitch(parts []string, dst string, opts StitchOptions) (err error) {
+	if len(parts) == 0 {
+		return errors.New("no parts to stitch")
+	}
+
+	sources := make([]*Whisper, 0, len(parts))
+	defer func() {
+		for _, source := range sources {
+			source.Close()
+		}
+	}()
+	for _, part := range parts {
+		source, e := OpenReadOnly(part)
+		if e != nil {
+			return e
+		}
+		sources = append(sources, source)
+	}
+
+	header := sources[0].Header
+	for i, source := range sources[1:] {
+		if !sameArchives(header.Archives, source.Header.Archives) {
+			return errors.New(fmt.Sprintf("%s has a different archive layout than %s", parts[i+1], parts[0]))
+		}
+	}
+
+	err = Create(dst, header.Archives, header.Metadata.XFilesFactor, header.Metadata.AggregationMethod, false)
+	if err != nil {
+		return
+	}
+	w, err := Open(dst)
+	if err != nil {
+		return
+	}
+	defer w.Close()
+
+	// A filter saved for an earlier database at dst doesn't cover the stitched points
+	err = removeIntervalFilter(dst)
+	if err != nil {
+		return
+	}
+
+	for i, info := range w.Header.Archives {
+		merged := make(map[uint32]Point)
+		suppliers := make(map[uint32]string)
+		for j, source := range sources {
+			points, e := source.readArchive(source.Header.Archives[i])
+			if e != nil {
+				return e
+			}
+			for _, point := range points {
+				if point.Timestamp == 0 {
+					// Never written
+					continue
+				}
+				point.Timestamp = quantizeTimestamp(point.Timestamp, info.SecondsPerPoint)
+				if _, ok := merged[point.Timestamp]; ok && opts.Policy == OVERLAP_KEEP_FIRST {
+					continue
+				}
+				merged[point.Timestamp] = point
+				suppliers[point.Timestamp] = parts[j]
+			}
+		}
+
+		points := newestPoints(sortedPoints(merged), info)
+		err = w.writeArchive(info, points)
+		if err != nil {
+			return
+		}
+		err = opts.Provenance.recordPoints(i, info, points, suppliers)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Returns true if both lists describe the same archives
+func sameArchives(a, b []ArchiveInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].SecondsPerPoint != b[i].SecondsPerPoint || a[i].Points != b[i].Points {
+			return false
+		}
+	}
+	return true
+}
+
+// Trim a sorted list of points to those that fit in the archive's retention, counting back from the newest point
+func newestPoints(points archive, info ArchiveInfo) archive {
+	if len(points) == 0 || points[len(points)-1].Timestamp < info.Retention() {
+		return points
+	}
+	oldest := points[len(points)-1].Timestamp - info.Retention()
+	i := sort.Search(len(points), func(i int) bool { return points[i].Timestamp > oldest })
+	return points[i:]
+}
@@ -0,0 +1,72 @@
+package whisper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create a database with a single archive and write the given points straight into it
+func createWithPoints(t *testing.T, path string, info ArchiveInfo, points archive) {
+	createFixture(t, path, []ArchiveInfo{info}, 0.5, AGGREGATION_AVERAGE)
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.writeArchive(w.Header.Archives[0], points); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStitch(t *testing.T) {
+	dir := t.TempDir()
+	info := ArchiveInfo{0, 10, 5}
+	first := filepath.Join(dir, "first.wsp")
+	second := filepath.Join(dir, "second.wsp")
+	createWithPoints(t, first, info, archive{{100, 1}, {110, 2}, {120, 3}})
+	createWithPoints(t, second, info, archive{{120, 30}, {130, 4}, {140, 5}, {150, 6}})
+
+	tests := []struct {
+		policy   OverlapPolicy
+		expected archive
+	}{
+		// 100 falls out of the retention once 150 is present
+		{OVERLAP_KEEP_FIRST, archive{{110, 2}, {120, 3}, {130, 4}, {140, 5}, {150, 6}}},
+		{OVERLAP_KEEP_LAST, archive{{110, 2}, {120, 30}, {130, 4}, {140, 5}, {150, 6}}},
+	}
+
+	for i, tt := range tests {
+		dst := filepath.Join(dir, "stitched.wsp")
+		if err := Stitch([]string{first, second}, dst, StitchOptions{Policy: tt.policy}); err != nil {
+			t.Fatalf("%d. %v", i, err)
+		}
+		w, err := Open(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		points, err := w.readArchive(w.Header.Archives[0])
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := range tt.expected {
+			if points[j] != tt.expected[j] {
+				t.Errorf("%d. slot %d = %v, want %v", i, j, points[j], tt.expected[j])
+			}
+		}
+		os.Remove(dst)
+	}
+}
+
+func TestStitchMismatchedArchives(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.wsp")
+	second := filepath.Join(dir, "second.wsp")
+	createWithPoints(t, first, ArchiveInfo{0, 10, 5}, nil)
+	createWithPoints(t, second, ArchiveInfo{0, 10, 6}, nil)
+
+	if err := Stitch([]string{first, second}, filepath.Join(dir, "stitched.wsp"), StitchOptions{}); err == nil {
+		t.Errorf("no error for parts with different archives")
+	}
+}
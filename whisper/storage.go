@@ -0,0 +1,93 @@
+package whisper
+
+import (
+	"io"
+	"math"
+	"os"
+)
+
+// Storage holds the bytes of a whisper database. *os.File implements Storage, and other
+// implementations let databases live in memory, in remote objects or in test fakes.
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error // Change the size of the storage, zero filling any growth
+	Close() error
+}
+
+// Open a whisper database kept in storage. If readOnly is set any attempt to write to the
+// database fails with ErrReadOnly.
+func OpenStorage(storage Storage, readOnly bool) (whisper *Whisper, err error) {
+	size, err := storageSize(storage)
+	if err != nil {
+		return
+	}
+	header, err := readHeaderFrom(io.NewSectionReader(storage, 0, math.MaxInt64), size)
+	if err != nil {
+		return
+	}
+	whisper = &Whisper{Header: header, storage: storage, readOnly: readOnly}
+	return
+}
+
+// Returns the number of bytes held by storage, or -1 if it can't tell
+func storageSize(storage Storage) (size int64, err error) {
+	switch s := storage.(type) {
+	case *os.File:
+		info, err := s.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	case interface{ size() int64 }:
+		return s.size(), nil
+	}
+	return -1, nil
+}
+
+// Create a new whisper database in storage, replacing anything stored there, and open it. The
+// archives are validated and ordered like CreateWithOptions.
+func CreateStorage(storage Storage, archives []ArchiveInfo, xFilesFactor float32, aggregationMethod AggregationMethod) (whisper *Whisper, err error) {
+	err = validateMetadata(xFilesFactor, aggregationMethod)
+	if err != nil {
+		return
+	}
+	archives = append([]ArchiveInfo(nil), archives...)
+	err = ValidateArchiveList(archives)
+	if err != nil {
+		return
+	}
+
+	header := newHeader(archives, xFilesFactor, aggregationMethod)
+
+	// Truncating to zero first clears any existing data
+	err = storage.Truncate(0)
+	if err != nil {
+		return
+	}
+	err = storage.Truncate(int64(header.end()))
+	if err != nil {
+		return
+	}
+
+	whisper = &Whisper{Header: header, storage: storage}
+	err = whisper.writeAt(0, header.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	err = whisper.writeAt(metadataSize, header.Archives)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Flush the storage to stable media if it supports it
+func (w *Whisper) sync() error {
+	if s, ok := w.storage.(interface {
+		Sync() error
+	}); ok {
+		return s.Sync()
+	}
+	return nil
+}
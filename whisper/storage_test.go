@@ -0,0 +1,41 @@
+package whisper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateStorage(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "test.wsp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(make([]byte, 100000))
+
+	archives := []ArchiveInfo{{0, 60, 1440}, {0, 3600, 168}}
+	created, err := CreateStorage(file, archives, 0.5, AGGREGATION_SUM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := file.Stat(); info.Size() != 40+(1440+168)*12 {
+		t.Errorf("storage has size %d, want %d", info.Size(), 40+(1440+168)*12)
+	}
+
+	w, err := OpenStorage(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Header.Metadata != created.Header.Metadata {
+		t.Errorf("metadata = %+v, want %+v", w.Header.Metadata, created.Header.Metadata)
+	}
+	for i := range archives {
+		if w.Header.Archives[i] != created.Header.Archives[i] {
+			t.Errorf("archive %d = %+v, want %+v", i, w.Header.Archives[i], created.Header.Archives[i])
+		}
+	}
+	if err := w.SetAggregationMethod(AGGREGATION_MAX); err != ErrReadOnly {
+		t.Errorf("write to read-only storage: got %v, want %v", err, ErrReadOnly)
+	}
+}
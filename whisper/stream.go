@@ -0,0 +1,261 @@
+package whisper
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+)
+
+// Version of the stream framing written by DumpTo
+const STREAM_VERSION = 1
+
+// First bytes of every stream written by DumpTo
+const streamMagic = "WSPSTREAM\n"
+
+// DumpFormat selects how DumpTo encodes the body of a stream
+type DumpFormat uint32
+
+// Valid dump formats
+const (
+	DUMP_RAW  DumpFormat = iota // The body is written as is
+	DUMP_GZIP                   // The body is gzip compressed
+)
+
+/*
+DumpTo streams the whole database to out, for backups and copies that must not depend on the file.
+Unlike Dump, the stream holds every slot exactly as it is stored, so the file can be recreated bit for
+bit. The database is read a chunk at a time, holding the handle's read lock until it is finished.
+
+A stream starts with a preamble, followed by the body, which is compressed as a whole if the format
+is DUMP_GZIP. Integers are big-endian, as in whisper files.
+
+	preamble:
+		magic      the 10 bytes "WSPSTREAM\n"
+		version    uint32, STREAM_VERSION
+		format     uint32, a DumpFormat
+	body:
+		header     the metadata and archive table, as in the file
+		archives   the slots of each archive in the order of the archive table, as in the file
+		checksum   uint32, CRC-32 (IEEE) of the header and archives
+*/
+func (w *Whisper) DumpTo(out io.Writer, format DumpFormat) (err error) {
+	if format != DUMP_RAW && format != DUMP_GZIP {
+		return errors.New(fmt.Sprintf("unknown dump format %d", format))
+	}
+
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	buffered := bufio.NewWriter(out)
+	preamble := append([]byte(streamMagic), make([]byte, 8)...)
+	binary.BigEndian.PutUint32(preamble[len(streamMagic):], STREAM_VERSION)
+	binary.BigEndian.PutUint32(preamble[len(streamMagic)+4:], uint32(format))
+	_, err = buffered.Write(preamble)
+	if err != nil {
+		return
+	}
+
+	var body io.Writer = buffered
+	var compressed *gzip.Writer
+	if format == DUMP_GZIP {
+		compressed = gzip.NewWriter(buffered)
+		body = compressed
+	}
+	err = w.writeStreamBody(body)
+	if err != nil {
+		return
+	}
+	if compressed != nil {
+		err = compressed.Close()
+		if err != nil {
+			return
+		}
+	}
+	return buffered.Flush()
+}
+
+// Write the header and the slots of every archive followed by their checksum
+func (w *Whisper) writeStreamBody(body io.Writer) (err error) {
+	checksum := crc32.NewIEEE()
+	out := io.MultiWriter(body, checksum)
+
+	err = binary.Write(out, binary.BigEndian, w.Header.Metadata)
+	if err != nil {
+		return
+	}
+	err = binary.Write(out, binary.BigEndian, w.Header.Archives)
+	if err != nil {
+		return
+	}
+
+	chunk := make([]Point, chunkPoints)
+	for _, info := range w.Header.Archives {
+		err = w.copyArchive(out, info, chunk)
+		if err != nil {
+			return
+		}
+	}
+	return binary.Write(body, binary.BigEndian, checksum.Sum32())
+}
+
+// Copy the slots of an archive to out a chunk at a time
+func (w *Whisper) copyArchive(out io.Writer, info ArchiveInfo, chunk []Point) (err error) {
+	for i := uint32(0); i < info.Points; i += uint32(len(chunk)) {
+		n := info.Points - i
+		if n > uint32(len(chunk)) {
+			n = uint32(len(chunk))
+		}
+		err = w.readPoints(info.Offset+i*pointSize, chunk[:n])
+		if err != nil {
+			return
+		}
+		err = binary.Write(out, binary.BigEndian, chunk[:n])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// LoadOptions controls how LoadFrom recreates a database
+type LoadOptions struct {
+	Archives []ArchiveInfo // Layout to migrate the data to, like Resize. Keeps the layout of the stream if nil
+	Resize   ResizeOptions // How the data is migrated when Archives is set
+}
+
+/*
+LoadFrom recreates the database at path from a stream written by DumpTo. Without opts.Archives the
+file is identical to the one that was dumped, bit for bit; otherwise its data is migrated to the
+given archives as Resize does.
+
+The database is written next to path and only moved into place once the whole stream has been read
+and its checksum matches, so a truncated or damaged stream never leaves a partial database behind. An
+existing database at path is never replaced, LoadFrom fails with an error satisfying os.IsExist instead.
+*/
+func LoadFrom(path string, r io.Reader, opts LoadOptions) (err error) {
+	body, err := readPreamble(r)
+	if err != nil {
+		return
+	}
+
+	tmpPath := path + "." + strconv.FormatUint(rand.Uint64(), 36) + ".load"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpPath)
+	err = readStreamBody(body, file)
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
+
+	if opts.Archives != nil {
+		resize := opts.Resize
+		resize.NoBackup = true
+		err = Resize(tmpPath, opts.Archives, resize)
+		if err != nil {
+			return
+		}
+	}
+
+	// Link rather than rename so an existing database is never replaced
+	err = os.Link(tmpPath, path)
+	if os.IsExist(err) {
+		err = &os.PathError{Op: "load", Path: path, Err: os.ErrExist}
+	}
+	return
+}
+
+// Check the preamble of a stream and return a reader of its body
+func readPreamble(r io.Reader) (body io.Reader, err error) {
+	preamble := make([]byte, len(streamMagic)+8)
+	_, err = io.ReadFull(r, preamble)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, errors.New("stream is too short to be a whisper dump")
+	}
+	if err != nil {
+		return
+	}
+	if string(preamble[:len(streamMagic)]) != streamMagic {
+		return nil, errors.New("stream isn't a whisper dump")
+	}
+	version := binary.BigEndian.Uint32(preamble[len(streamMagic):])
+	if version != STREAM_VERSION {
+		return nil, errors.New(fmt.Sprintf("unsupported stream version %d", version))
+	}
+
+	switch format := DumpFormat(binary.BigEndian.Uint32(preamble[len(streamMagic)+4:])); format {
+	case DUMP_RAW:
+		body = bufio.NewReader(r)
+	case DUMP_GZIP:
+		body, err = gzip.NewReader(r)
+	default:
+		err = errors.New(fmt.Sprintf("unknown dump format %d", format))
+	}
+	return
+}
+
+// Copy the header and archives of a stream's body to file, checking them against the checksum
+func readStreamBody(body io.Reader, file *os.File) (err error) {
+	checksum := crc32.NewIEEE()
+	in := io.TeeReader(body, checksum)
+
+	header, err := readHeaderFrom(in, -1)
+	if err != nil {
+		return streamError(err)
+	}
+	err = binary.Write(file, binary.BigEndian, header.Metadata)
+	if err != nil {
+		return
+	}
+	err = binary.Write(file, binary.BigEndian, header.Archives)
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, chunkPoints*pointSize)
+	for _, info := range header.Archives {
+		for offset := info.Offset; offset < info.end(); offset += uint32(len(buf)) {
+			n := info.end() - offset
+			if n > uint32(len(buf)) {
+				n = uint32(len(buf))
+			}
+			_, err = io.ReadFull(in, buf[:n])
+			if err != nil {
+				return streamError(err)
+			}
+			_, err = file.WriteAt(buf[:n], int64(offset))
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	var sum uint32
+	err = binary.Read(body, binary.BigEndian, &sum)
+	if err != nil {
+		return streamError(err)
+	}
+	if sum != checksum.Sum32() {
+		return errors.New(fmt.Sprintf("stream checksum is %08x, expected %08x", checksum.Sum32(), sum))
+	}
+	return
+}
+
+// Describe an error reading the body of a stream, which ends early if it was truncated
+func streamError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("stream is truncated")
+	}
+	return err
+}
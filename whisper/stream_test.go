@@ -0,0 +1,167 @@
+package whisper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDumpTo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 10}, {0, 300, 10}}, 0.5, AGGREGATION_MAX, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	now := uint32(time.Now().Unix())
+	if err := w.UpdateMany([]Point{{now - 60, 1}, {now - 120, 2}, {now - 900, 3}}); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []DumpFormat{DUMP_RAW, DUMP_GZIP} {
+		var stream bytes.Buffer
+		if err := w.DumpTo(&stream, format); err != nil {
+			t.Fatal(err)
+		}
+
+		preamble := stream.Next(len(streamMagic) + 8)
+		if string(preamble[:len(streamMagic)]) != streamMagic {
+			t.Fatalf("format %d: stream starts with %q", format, preamble)
+		}
+		if version := binary.BigEndian.Uint32(preamble[len(streamMagic):]); version != STREAM_VERSION {
+			t.Errorf("format %d: version %d", format, version)
+		}
+		if f := binary.BigEndian.Uint32(preamble[len(streamMagic)+4:]); f != uint32(format) {
+			t.Errorf("format %d: stream says format %d", format, f)
+		}
+
+		var body []byte
+		if format == DUMP_GZIP {
+			r, err := gzip.NewReader(&stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err = io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			body = stream.Bytes()
+		}
+
+		data, checksum := body[:len(body)-4], binary.BigEndian.Uint32(body[len(body)-4:])
+		if !bytes.Equal(data, file) {
+			t.Errorf("format %d: body doesn't hold the file", format)
+		}
+		if checksum != crc32.ChecksumIEEE(data) {
+			t.Errorf("format %d: checksum %x, want %x", format, checksum, crc32.ChecksumIEEE(data))
+		}
+	}
+
+	if err := w.DumpTo(io.Discard, DumpFormat(7)); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestLoadFrom(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 10}, {0, 300, 10}}, 0.5, AGGREGATION_MAX, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	if err := w.UpdateMany([]Point{{now - 60, 1}, {now - 120, 2}, {now - 900, 3}}); err != nil {
+		t.Fatal(err)
+	}
+	var raw, compressed bytes.Buffer
+	if err := w.DumpTo(&raw, DUMP_RAW); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.DumpTo(&compressed, DUMP_GZIP); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, stream := range [][]byte{raw.Bytes(), compressed.Bytes()} {
+		restored := filepath.Join(dir, fmt.Sprintf("restored%d.wsp", i))
+		if err := LoadFrom(restored, bytes.NewReader(stream), LoadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, file) {
+			t.Errorf("stream %d: restored database differs from the original", i)
+		}
+
+		// Never replaces an existing database
+		if err := LoadFrom(restored, bytes.NewReader(stream), LoadOptions{}); !os.IsExist(err) {
+			t.Errorf("stream %d: got %v loading over an existing database", i, err)
+		}
+	}
+
+	// Migrated to another layout
+	migrated := filepath.Join(dir, "migrated.wsp")
+	err = LoadFrom(migrated, bytes.NewReader(raw.Bytes()), LoadOptions{Archives: []ArchiveInfo{{0, 60, 30}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if len(m.Header.Archives) != 1 || m.Header.Archives[0].Points != 30 {
+		t.Errorf("migrated database has archives %v", m.Header.Archives)
+	}
+	if point, found, err := m.ValueAt(quantizeTimestamp(now-900, 300)); err != nil || !found || point.Value != 3 {
+		t.Errorf("got %v, %v, %v for the oldest point", point, found, err)
+	}
+
+	// Damaged streams are rejected without leaving anything behind
+	damaged := append([]byte{}, raw.Bytes()...)
+	damaged[len(damaged)-10] ^= 1
+	for name, stream := range map[string][]byte{
+		"truncated":  raw.Bytes()[:raw.Len()/2],
+		"damaged":    damaged,
+		"not a dump": []byte("whisper-dump 1\n"),
+	} {
+		broken := filepath.Join(dir, "broken.wsp")
+		if err := LoadFrom(broken, bytes.NewReader(stream), LoadOptions{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if _, err := os.Stat(broken); !os.IsNotExist(err) {
+			t.Errorf("%s: database left behind", name)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("%d files left in the directory, want 4", len(entries))
+	}
+}
@@ -0,0 +1,113 @@
+package whisper
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// HeaderMismatch describes a database whose header differs between two trees
+type HeaderMismatch struct {
+	Path string // Path of the database relative to the tree roots
+	A    Header // Header of the database in the first tree
+	B    Header // Header of the database in the second tree
+}
+
+// UnreadableHeader describes a database whose header couldn't be read
+type UnreadableHeader struct {
+	Path string // Path of the database relative to the tree root
+	Root string // Root of the tree holding the database
+	Err  error  // Error opening the database or reading its header
+}
+
+// TreeDiff holds the differences between two trees of whisper databases
+type TreeDiff struct {
+	OnlyInA    []string           // Databases found only in the first tree
+	OnlyInB    []string           // Databases found only in the second tree
+	Mismatched []HeaderMismatch   // Databases whose archives, aggregation method or xFilesFactor differ
+	Unreadable []UnreadableHeader // Databases in either tree whose header couldn't be read, so weren't compared
+}
+
+// Returns true if the trees hold the same databases with the same readable headers
+func (d TreeDiff) Empty() bool {
+	return len(d.OnlyInA) == 0 && len(d.OnlyInB) == 0 && len(d.Mismatched) == 0 && len(d.Unreadable) == 0
+}
+
+// DiffTrees compares the .wsp files found under two root directories. Databases are matched by
+// their path relative to the root, and only their headers are compared. A database whose header
+// can't be read is listed in Unreadable and the walk carries on.
+func DiffTrees(a, b string) (diff TreeDiff, err error) {
+	headersA, err := treeHeaders(a, &diff.Unreadable)
+	if err != nil {
+		return
+	}
+	headersB, err := treeHeaders(b, &diff.Unreadable)
+	if err != nil {
+		return
+	}
+
+	for path, headerA := range headersA {
+		headerB, ok := headersB[path]
+		if !ok {
+			diff.OnlyInA = append(diff.OnlyInA, path)
+		} else if headerA != nil && headerB != nil && !sameHeader(*headerA, *headerB) {
+			diff.Mismatched = append(diff.Mismatched, HeaderMismatch{path, *headerA, *headerB})
+		}
+	}
+	for path := range headersB {
+		if _, ok := headersA[path]; !ok {
+			diff.OnlyInB = append(diff.OnlyInB, path)
+		}
+	}
+
+	sort.Strings(diff.OnlyInA)
+	sort.Strings(diff.OnlyInB)
+	sort.Slice(diff.Mismatched, func(i, j int) bool { return diff.Mismatched[i].Path < diff.Mismatched[j].Path })
+	return
+}
+
+// Read the header of every .wsp file under root, keyed by path relative to root. Databases whose
+// header can't be read are keyed to nil and appended to unreadable.
+func treeHeaders(root string, unreadable *[]UnreadableHeader) (headers map[string]*Header, err error) {
+	headers = make(map[string]*Header)
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".wsp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		header, err := readHeaderFile(path)
+		if err != nil {
+			*unreadable = append(*unreadable, UnreadableHeader{rel, root, err})
+			headers[rel] = nil
+			return nil
+		}
+		headers[rel] = &header
+		return nil
+	})
+	return
+}
+
+// Read the header of the database at path
+func readHeaderFile(path string) (header Header, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return ReadHeader(file)
+}
+
+// Returns true if two headers describe the same retention, aggregation method and xFilesFactor
+func sameHeader(a, b Header) bool {
+	return a.Metadata.AggregationMethod == b.Metadata.AggregationMethod &&
+		a.Metadata.XFilesFactor == b.Metadata.XFilesFactor &&
+		sameArchives(a.Archives, b.Archives)
+}
@@ -0,0 +1,73 @@
+package whisper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffTrees(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	create := func(root, name string, archives []ArchiveInfo, method AggregationMethod) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := Create(path, archives, 0.5, method, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archives := []ArchiveInfo{{0, 60, 1440}}
+	create(a, "same.wsp", archives, AGGREGATION_AVERAGE)
+	create(b, "same.wsp", archives, AGGREGATION_AVERAGE)
+	create(a, "servers/a.wsp", archives, AGGREGATION_AVERAGE)
+	create(b, "servers/b.wsp", archives, AGGREGATION_AVERAGE)
+	create(a, "method.wsp", archives, AGGREGATION_AVERAGE)
+	create(b, "method.wsp", archives, AGGREGATION_SUM)
+	create(a, "retention.wsp", archives, AGGREGATION_AVERAGE)
+	create(b, "retention.wsp", []ArchiveInfo{{0, 60, 10080}}, AGGREGATION_AVERAGE)
+
+	diff, err := DiffTrees(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.OnlyInA) != 1 || diff.OnlyInA[0] != filepath.Join("servers", "a.wsp") {
+		t.Errorf("OnlyInA = %v", diff.OnlyInA)
+	}
+	if len(diff.OnlyInB) != 1 || diff.OnlyInB[0] != filepath.Join("servers", "b.wsp") {
+		t.Errorf("OnlyInB = %v", diff.OnlyInB)
+	}
+	if len(diff.Mismatched) != 2 || diff.Mismatched[0].Path != "method.wsp" || diff.Mismatched[1].Path != "retention.wsp" {
+		t.Errorf("Mismatched = %v", diff.Mismatched)
+	}
+	if diff.Empty() {
+		t.Errorf("Empty() = true")
+	}
+}
+
+func TestDiffTreesUnreadable(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	archives := []ArchiveInfo{{0, 60, 1440}}
+	for _, path := range []string{filepath.Join(a, "corrupt.wsp"), filepath.Join(a, "ok.wsp"), filepath.Join(b, "corrupt.wsp"), filepath.Join(b, "ok.wsp")} {
+		if err := Create(path, archives, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(a, "corrupt.wsp"), []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := DiffTrees(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Unreadable) != 1 || diff.Unreadable[0].Path != "corrupt.wsp" || diff.Unreadable[0].Root != a || diff.Unreadable[0].Err == nil {
+		t.Errorf("Unreadable = %v", diff.Unreadable)
+	}
+	if len(diff.OnlyInA) != 0 || len(diff.OnlyInB) != 0 || len(diff.Mismatched) != 0 {
+		t.Errorf("unexpected differences %+v", diff)
+	}
+	if diff.Empty() {
+		t.Errorf("Empty() = true")
+	}
+}
@@ -0,0 +1,73 @@
+package whisper
+
+import "context"
+
+// Enable or disable weighting by coverage when averaging points into lower precision archives.
+//
+// When enabled and the aggregation method is average, a point propagated from an archive other than
+// the highest precision one is weighted by how many points of the highest precision archive are known
+// in its interval, instead of every point counting equally. For sparse metrics this gives rollups that
+// are less biased towards barely populated intervals. It has no effect on other aggregation methods.
+func (w *Whisper) SetWeightedAverage(enabled bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.weightedAverage = enabled
+}
+
+// Calculate the weight of each of a sorted list of points of an archive as the number of known points
+// of the highest precision archive within its interval. Returns nil if the points should be weighted
+// equally, either because they come from the highest precision archive or because it no longer covers them.
+func (w *Whisper) coverageWeights(higher ArchiveInfo, points []Point, now uint32) (weights []float64, err error) {
+	raw := w.Header.Archives[0]
+	if len(points) == 0 || higher.SecondsPerPoint == raw.SecondsPerPoint {
+		return
+	}
+
+	start := points[0].Timestamp
+	end := points[len(points)-1].Timestamp + higher.SecondsPerPoint
+	count := (end - start) / raw.SecondsPerPoint
+	if start < raw.StartTime(now) || count > raw.Points {
+		return
+	}
+
+	offset, err := w.pointOffset(raw, start)
+	if err != nil {
+		return
+	}
+	rawPoints, err := w.readPointsBetweenOffsets(context.Background(), raw, offset, raw.Offset+(offset-raw.Offset+count*pointSize)%raw.size())
+	if err != nil {
+		return
+	}
+
+	known := make(map[uint32]float64)
+	for i, point := range rawPoints {
+		if point.Timestamp == start+uint32(i)*raw.SecondsPerPoint {
+			known[quantizeTimestamp(point.Timestamp, higher.SecondsPerPoint)]++
+		}
+	}
+
+	weights = make([]float64, len(points))
+	for i, point := range points {
+		weights[i] = known[point.Timestamp]
+	}
+	return
+}
+
+// Average a list of points, weighting each by the matching entry of weights. Falls back to a plain
+// average if no weights are given or they are all zero.
+func weightedAverage(points []Point, weights []float64) float64 {
+	var sum, total float64
+	for i, point := range points {
+		if weights == nil {
+			sum += point.Value
+			total++
+		} else {
+			sum += point.Value * weights[i]
+			total += weights[i]
+		}
+	}
+	if total == 0 {
+		return weightedAverage(points, nil)
+	}
+	return sum / total
+}
@@ -0,0 +1,26 @@
+package whisper
+
+import (
+	"testing"
+)
+
+func TestWeightedAverage(t *testing.T) {
+	points := []Point{{0, 10}, {60, 20}, {120, 40}}
+
+	tests := []struct {
+		weights  []float64
+		expected float64
+	}{
+		{nil, 70.0 / 3},
+		{[]float64{0, 0, 0}, 70.0 / 3},
+		{[]float64{1, 1, 1}, 70.0 / 3},
+		{[]float64{6, 3, 1}, (60 + 60 + 40) / 10.0},
+		{[]float64{0, 2, 0}, 20},
+	}
+
+	for i, tt := range tests {
+		if avg := weightedAverage(points, tt.weights); avg != tt.expected {
+			t.Errorf("%d. weightedAverage(%v) = %v, want %v", i, tt.weights, avg, tt.expected)
+		}
+	}
+}
@@ -6,15 +6,20 @@ Package whisper implements an interface to the whisper database format used by t
 package whisper
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unsafe"
 )
 
 // Metadata holds metadata that's common to an entire whisper database
@@ -37,6 +42,14 @@ func (a ArchiveInfo) Retention() uint32 {
 	return a.SecondsPerPoint * a.Points
 }
 
+// Returns the earliest timestamp the archive can hold data for at the given time
+func (a ArchiveInfo) StartTime(now uint32) uint32 {
+	if a.Retention() > now {
+		return 0
+	}
+	return now - a.Retention()
+}
+
 // Calculates the size of the archive in bytes
 func (a ArchiveInfo) size() uint32 {
 	return a.Points * pointSize
@@ -57,10 +70,14 @@ const (
 	AGGREGATION_LAST    AggregationMethod = 3 // Aggregate using the last value
 	AGGREGATION_MAX     AggregationMethod = 4 // Aggregate using the maximum value
 	AGGREGATION_MIN     AggregationMethod = 5 // Aggregate using the minimum value
+	AGGREGATION_ABSMAX  AggregationMethod = 7 // Aggregate using the value with the largest magnitude
+	AGGREGATION_ABSMIN  AggregationMethod = 8 // Aggregate using the value with the smallest magnitude
+	AGGREGATION_FIRST   AggregationMethod = 9 // Aggregate using the first value
 )
 
-func (a *AggregationMethod) String() (s string) {
-	switch *a {
+// Returns the name of the aggregation method as used in storage-aggregation.conf, or "unknown"
+func (a AggregationMethod) String() (s string) {
+	switch a {
 	case AGGREGATION_AVERAGE:
 		s = "average"
 	case AGGREGATION_SUM:
@@ -71,28 +88,48 @@ func (a *AggregationMethod) String() (s string) {
 		s = "min"
 	case AGGREGATION_MAX:
 		s = "max"
+	case AGGREGATION_ABSMAX:
+		s = "absmax"
+	case AGGREGATION_ABSMIN:
+		s = "absmin"
+	case AGGREGATION_FIRST:
+		s = "first"
 	default:
 		s = "unknown"
 	}
 	return
 }
 
-func (a *AggregationMethod) Set(s string) error {
+// Returns the aggregation method with the given name, eg: "average" or "max"
+func ParseAggregationMethod(s string) (a AggregationMethod, err error) {
 	switch s {
 	case "average":
-		*a = AGGREGATION_AVERAGE
+		a = AGGREGATION_AVERAGE
 	case "sum":
-		*a = AGGREGATION_SUM
+		a = AGGREGATION_SUM
 	case "last":
-		*a = AGGREGATION_LAST
+		a = AGGREGATION_LAST
 	case "min":
-		*a = AGGREGATION_MIN
+		a = AGGREGATION_MIN
 	case "max":
-		*a = AGGREGATION_MAX
+		a = AGGREGATION_MAX
+	case "absmax":
+		a = AGGREGATION_ABSMAX
+	case "absmin":
+		a = AGGREGATION_ABSMIN
+	case "first":
+		a = AGGREGATION_FIRST
 	default:
-		*a = AGGREGATION_UNKNOWN
+		err = errors.New(fmt.Sprintf("unknown aggregation method %q", s))
 	}
-	return nil
+	return
+}
+
+// Set the aggregation method from its name, so an AggregationMethod can be used as a flag.Value.
+// An unknown name sets AGGREGATION_UNKNOWN and returns an error.
+func (a *AggregationMethod) Set(s string) (err error) {
+	*a, err = ParseAggregationMethod(s)
+	return
 }
 
 // Header contains all the metadata about a whisper database. 
@@ -101,6 +138,30 @@ type Header struct {
 	Archives []ArchiveInfo // Information about each of the archives in the database, in order of precision
 }
 
+// Calculates the size of the header in bytes
+func (h Header) size() uint32 {
+	return metadataSize + archiveSize*uint32(len(h.Archives))
+}
+
+// Returns the earliest timestamp any archive can hold data for at the given time
+func (h Header) earliestTime(now uint32) uint32 {
+	if h.Metadata.MaxRetention > now {
+		return 0
+	}
+	return now - h.Metadata.MaxRetention
+}
+
+// Calculates the size of the whole database in bytes
+func (h Header) end() uint32 {
+	end := h.size()
+	for _, archive := range h.Archives {
+		if archive.end() > end {
+			end = archive.end()
+		}
+	}
+	return end
+}
+
 // A Point is a single datum stored in a whisper database.
 type Point struct {
 	Timestamp uint32  // Timestamp in seconds past the epoch
@@ -114,12 +175,81 @@ type Interval struct {
 	Step           uint32 // Step size in seconds
 }
 
-// Whisper represents a handle to a whisper database.
+// A Series holds the values of a database for every interval in a time range. Values has one entry per
+// step starting at From, which is nil if there is no data for the interval.
+type Series struct {
+	From   uint32     // Start of the first interval in seconds since the epoch
+	Until  uint32     // End of the last interval in seconds since the epoch
+	Step   uint32     // Step size in seconds
+	Values []*float64 // Value of each interval, nil if it is missing
+}
+
+// Whisper represents a handle to a whisper database. A handle is safe for concurrent use by
+// multiple goroutines; reads may run concurrently while writes are serialized by an internal lock.
 type Whisper struct {
-	Header Header
-	file   *os.File
+	Header   Header
+	mutex    sync.RWMutex
+	path     string
+	storage  Storage
+	readOnly bool
+
+	nonFinite          NonFinitePolicy
+	aggregateNonFinite AggregateNonFinitePolicy
+	missingPolicy      MissingPolicy
+	duplicatePolicy    DuplicatePolicy
+	weightedAverage    bool
+	intervalFilter     *IntervalFilter
+	middleware         []Middleware
+	jitterTolerance    uint32
+	lastUpdate         time.Time
+	frozen             map[uint32]bool // Offsets of the archives frozen by FreezeArchive
+	readOnlyStorage    bool            // The filesystem holding the database is mounted read-only
+	dataSync           bool            // The file was opened with O_DSYNC by OpenDataSync
+	journaling         bool            // Updates are recorded in the journal, see SetJournaling
+	journalPending     bool            // A failed update left the journal for the next Open to replay
+
+	syncPolicy SyncPolicy
+	dirty      bool          // Updated since the last flush, for SYNC_PERIODIC
+	syncErr    error         // Error of the last background flush, returned by the next update
+	stopSync   chan struct{} // Closed to stop the background flushes
+}
+
+// ErrReadOnly is returned when a write is attempted on a database opened with OpenReadOnly
+var ErrReadOnly = errors.New("whisper database is opened read-only")
+
+// ErrReadOnlyStorage is returned when a write is attempted on a database whose filesystem is mounted
+// read-only. Such databases are opened read-only, and a handle whose filesystem becomes read-only
+// switches to read-only on its first failed write, so fetches keep working.
+var ErrReadOnlyStorage = errors.New("whisper database is on a read-only filesystem")
+
+// ErrMaintenanceInProgress is returned when a write is attempted while a maintenance
+// operation such as Resize holds the database's MaintenanceLock
+var ErrMaintenanceInProgress = errors.New("whisper database is locked for maintenance")
+
+// ErrReplaced is returned when a write is attempted through a handle whose file has been replaced
+// at the database's path, for example by Resize. Refresh reopens the handle.
+var ErrReplaced = errors.New("whisper database file has been replaced, it must be refreshed")
+
+// ErrCorruptHeader matches the errors returned when a database's header doesn't describe a valid
+// layout, so the file is damaged or isn't a whisper database. Use errors.Is to check for it.
+var ErrCorruptHeader = errors.New("whisper header is corrupt")
+
+// CorruptHeaderError describes the problem found in a corrupt header. It matches ErrCorruptHeader.
+type CorruptHeaderError struct {
+	Problem string
+}
+
+func (e *CorruptHeaderError) Error() string {
+	return "whisper header is corrupt: " + e.Problem
 }
 
+func (e *CorruptHeaderError) Is(target error) bool {
+	return target == ErrCorruptHeader
+}
+
+// Headers with more archives than this are rejected as corrupt without reading the archive table
+const maxArchiveCount = 1024
+
 // Unexported members
 
 // type for sorting a list of ArchiveInfo by the SecondsPerPoint field
@@ -147,17 +277,31 @@ func (r reverseArchive) Less(i, j int) bool { return r.archive.Less(j, i) }
 // some sizes used fo
 var pointSize, metadataSize, archiveSize uint32
 
+// Offsets of the fields of the metadata block
+const (
+	aggregationMethodOffset = 0
+	maxRetentionOffset      = 4
+	xFilesFactorOffset      = 8
+	archiveCountOffset      = 12
+)
+
+// Number of points read or written at once by operations that can be cancelled, so cancellation
+// is noticed without waiting for a whole archive of a large database
+const chunkPoints = 1 << 16
+
 // a regular expression matching a precision string such as 120y
 var precisionRegexp = regexp.MustCompile("^(\\d+)([smhdwy]?)")
 
 func init() {
 	pointSize = uint32(binary.Size(Point{}))
 	metadataSize = uint32(binary.Size(Metadata{}))
-	archiveSize = uint32(binary.Size(archive{}))
+	archiveSize = uint32(binary.Size(ArchiveInfo{}))
 }
 
-// Read the header of a whisper database
-func readHeader(buf io.ReadSeeker) (header Header, err error) {
+// ReadHeader reads the header of a whisper database from any io.ReadSeeker.
+// The header is read from the start of the stream, and the stream is returned
+// to its original position afterwards.
+func ReadHeader(buf io.ReadSeeker) (header Header, err error) {
 	currentPos, err := buf.Seek(0, 1)
 	if err != nil {
 		return
@@ -171,33 +315,94 @@ func readHeader(buf io.ReadSeeker) (header Header, err error) {
 		return
 	}()
 
+	// The archives are checked against the size of the stream
+	size, err := buf.Seek(0, 2)
+	if err != nil {
+		return
+	}
+
 	// Start at the beginning of the file
 	_, err = buf.Seek(0, 0)
 	if err != nil {
 		return
 	}
 
+	return readHeaderFrom(buf, size)
+}
+
+// OpenHeaderOnly reads just the header of a whisper database from the start of a stream,
+// such as a file being downloaded or an entry in a tar archive. Nothing past the archive
+// table is read, and the stream does not need to support seeking.
+func OpenHeaderOnly(r io.Reader) (header Header, err error) {
+	return readHeaderFrom(r, -1)
+}
+
+/*
+Read the metadata and archive table from the current position of a reader, which holds a database
+of size bytes, or of unknown size if size is negative.
+
+The header may come from an untrusted file, so it is checked before it is used and a
+*CorruptHeaderError is returned if it doesn't describe a valid layout.
+*/
+func readHeaderFrom(r io.Reader, size int64) (header Header, err error) {
 	// Read metadata
 	var metadata Metadata
-	err = binary.Read(buf, binary.BigEndian, &metadata)
+	err = binary.Read(r, binary.BigEndian, &metadata)
 	if err != nil {
 		return
 	}
 	header.Metadata = metadata
 
+	// Check the archive count before allocating the archive table
+	headerEnd := int64(metadataSize) + int64(metadata.ArchiveCount)*int64(archiveSize)
+	switch {
+	case metadata.ArchiveCount == 0:
+		return header, &CorruptHeaderError{"there are no archives"}
+	case metadata.ArchiveCount > maxArchiveCount:
+		return header, &CorruptHeaderError{fmt.Sprintf("%d archives is more than the limit of %d", metadata.ArchiveCount, maxArchiveCount)}
+	case size >= 0 && headerEnd > size:
+		return header, &CorruptHeaderError{fmt.Sprintf("%d archives need a %d byte header, but the file is %d bytes", metadata.ArchiveCount, headerEnd, size)}
+	}
+
 	// Read archive info
 	archives := make([]ArchiveInfo, metadata.ArchiveCount)
 	for i := uint32(0); i < metadata.ArchiveCount; i++ {
-		err = binary.Read(buf, binary.BigEndian, &archives[i])
+		err = binary.Read(r, binary.BigEndian, &archives[i])
 		if err != nil {
 			return
 		}
 	}
 	header.Archives = archives
 
+	err = checkArchiveTable(archives, headerEnd, size)
 	return
 }
 
+// Check that archives are ordered from the highest precision down and laid out one after the other
+// between the end of the header and the end of a file of size bytes, if the size is known
+func checkArchiveTable(archives []ArchiveInfo, headerEnd, size int64) error {
+	end := headerEnd
+	for i, archive := range archives {
+		if archive.SecondsPerPoint == 0 || archive.Points == 0 {
+			return &CorruptHeaderError{fmt.Sprintf("archive %d has %d points every %d seconds", i, archive.Points, archive.SecondsPerPoint)}
+		}
+		if i > 0 && archive.SecondsPerPoint <= archives[i-1].SecondsPerPoint {
+			return &CorruptHeaderError{fmt.Sprintf("archive %d isn't lower precision than archive %d", i, i-1)}
+		}
+		if int64(archive.Offset) < end {
+			return &CorruptHeaderError{fmt.Sprintf("archive %d starts at %d, before the end of the previous archive or header at %d", i, archive.Offset, end)}
+		}
+		end = int64(archive.Offset) + int64(archive.Points)*int64(pointSize)
+		if end > math.MaxUint32 {
+			return &CorruptHeaderError{fmt.Sprintf("archive %d ends at %d, past the largest possible offset", i, end)}
+		}
+		if size >= 0 && end > size {
+			return &CorruptHeaderError{fmt.Sprintf("archive %d ends at %d, past the end of the file at %d", i, end, size)}
+		}
+	}
+	return nil
+}
+
 /* 
 
 Validates a list of ArchiveInfos
@@ -256,13 +461,19 @@ func ValidateArchiveList(archives []ArchiveInfo) error {
 
 }
 
-// Create a new whisper database at a given file path
-func Create(path string, archives []ArchiveInfo, xFilesFactor float32, aggregationMethod AggregationMethod, sparse bool) (err error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
-	if err != nil {
-		return err
+// Check that an xFilesFactor and aggregation method can be stored in a database header
+func validateMetadata(xFilesFactor float32, aggregationMethod AggregationMethod) error {
+	if !(xFilesFactor >= 0 && xFilesFactor <= 1) {
+		return errors.New(fmt.Sprintf("invalid xFilesFactor %g, must be between 0 and 1", xFilesFactor))
+	}
+	if aggregationMethod.String() == "unknown" {
+		return errors.New(fmt.Sprintf("unknown aggregation method %d", aggregationMethod))
 	}
+	return nil
+}
 
+// Build the header of a new database, laying the archives out one after another following the header
+func newHeader(archives []ArchiveInfo, xFilesFactor float32, aggregationMethod AggregationMethod) (header Header) {
 	oldest := uint32(0)
 	for _, archive := range archives {
 		age := archive.SecondsPerPoint * archive.Points
@@ -271,343 +482,1207 @@ func Create(path string, archives []ArchiveInfo, xFilesFactor float32, aggregati
 		}
 	}
 
-	metadata := Metadata{
+	header.Metadata = Metadata{
 		AggregationMethod: aggregationMethod,
 		XFilesFactor:      xFilesFactor,
 		ArchiveCount:      uint32(len(archives)),
 		MaxRetention:      oldest,
 	}
-	err = binary.Write(file, binary.BigEndian, metadata)
+
+	header.Archives = make([]ArchiveInfo, len(archives))
+	archiveOffsetPointer := header.size()
+	for i, archive := range archives {
+		archive.Offset = archiveOffsetPointer
+		header.Archives[i] = archive
+		archiveOffsetPointer += archive.size()
+	}
+	return
+}
+
+// CreateOptions controls how CreateWithOptions writes a new database
+type CreateOptions struct {
+	Sparse      bool // Only write the header, leaving the archives as a hole in the file
+	Preallocate bool // Unless Sparse, allocate the archives with fallocate where supported instead of writing zeroes
+	Sync        bool // Flush the file to disk before returning, so a crash can't leave a truncated database
+
+	MkdirAll bool        // Create any missing parent directories of the database
+	DirMode  os.FileMode // Permissions of directories created for MkdirAll, 0755 if zero
+}
+
+// Create a new whisper database at a given file path
+func Create(path string, archives []ArchiveInfo, xFilesFactor float32, aggregationMethod AggregationMethod, sparse bool) (err error) {
+	return CreateWithOptions(path, archives, xFilesFactor, aggregationMethod, CreateOptions{Sparse: sparse})
+}
+
+// Create a new whisper database at path like Create. The archives are validated with
+// ValidateArchiveList and laid out in order of precision before anything is written. The file is
+// removed again if any part of it can't be written, so a failed Create never leaves a partial
+// database behind.
+func CreateWithOptions(path string, archives []ArchiveInfo, xFilesFactor float32, aggregationMethod AggregationMethod, opts CreateOptions) (err error) {
+	err = validateMetadata(xFilesFactor, aggregationMethod)
+	if err != nil {
+		return
+	}
+	archives = append([]ArchiveInfo(nil), archives...)
+	err = ValidateArchiveList(archives)
 	if err != nil {
 		return
 	}
 
-	headerSize := metadataSize + (archiveSize * uint32(len(archives)))
-	archiveOffsetPointer := headerSize
-
-	for _, archive := range archives {
-		archive.Offset = archiveOffsetPointer
-		err = binary.Write(file, binary.BigEndian, archive)
+	if opts.MkdirAll {
+		mode := opts.DirMode
+		if mode == 0 {
+			mode = 0755
+		}
+		err = os.MkdirAll(filepath.Dir(path), mode)
 		if err != nil {
 			return
 		}
-		archiveOffsetPointer += archive.Points * pointSize
 	}
 
-	if sparse {
-		file.Seek(int64(archiveOffsetPointer-headerSize-1), 0)
-		file.Write([]byte{0})
-	} else {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := file.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	header := newHeader(archives, xFilesFactor, aggregationMethod)
+	err = binary.Write(file, binary.BigEndian, header.Metadata)
+	if err != nil {
+		return
+	}
+	err = binary.Write(file, binary.BigEndian, header.Archives)
+	if err != nil {
+		return
+	}
+
+	headerSize := header.size()
+	archiveOffsetPointer := header.end()
+
+	allocated := false
+	if opts.Sparse {
+		err = file.Truncate(int64(archiveOffsetPointer))
+		allocated = true
+	} else if opts.Preallocate {
+		allocated, err = preallocate(file, int64(archiveOffsetPointer))
+	}
+	if err != nil {
+		return
+	}
+	if !allocated {
 		remaining := archiveOffsetPointer - headerSize
 		chunkSize := uint32(16384)
 		buf := make([]byte, chunkSize)
 		for remaining > chunkSize {
-			file.Write(buf)
+			_, err = file.Write(buf)
+			if err != nil {
+				return
+			}
 			remaining -= chunkSize
 		}
-		file.Write(buf[:remaining])
+		_, err = file.Write(buf[:remaining])
+		if err != nil {
+			return
+		}
 	}
 
+	if opts.Sync {
+		err = file.Sync()
+	}
 	return
 }
 
 // Open a whisper database
-func Open(path string) (whisper Whisper, err error) {
-	file, err := os.OpenFile(path, os.O_RDWR, 0666)
+func Open(path string) (whisper *Whisper, err error) {
+	return open(path, os.O_RDWR)
+}
+
+// Open a whisper database for reading only. Any attempt to write to the
+// returned database fails with ErrReadOnly.
+func OpenReadOnly(path string) (whisper *Whisper, err error) {
+	return open(path, os.O_RDONLY)
+}
+
+func open(path string, flag int) (whisper *Whisper, err error) {
+	return openWithHeader(path, flag, func(file *os.File) (Header, error) {
+		return ReadHeader(file)
+	})
+}
+
+/*
+Open a database file, getting its header from readHeader, and load the state kept beside it: the
+archives frozen by FreezeArchive, and the journal of an interrupted update, which is replayed unless
+the database is opened read-only. Every way of opening a database file goes through here, so none
+can skip the sidecar files.
+*/
+func openWithHeader(path string, flag int, readHeader func(file *os.File) (Header, error)) (whisper *Whisper, err error) {
+	file, readOnlyStorage, err := openFile(path, flag)
 	if err != nil {
 		return
 	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			whisper = nil
+		}
+	}()
 
 	header, err := readHeader(file)
 	if err != nil {
 		return
 	}
-	whisper = Whisper{Header: header, file: file}
+	whisper = &Whisper{
+		Header:          header,
+		path:            path,
+		storage:         file,
+		readOnly:        flag == os.O_RDONLY || readOnlyStorage,
+		readOnlyStorage: readOnlyStorage,
+		dataSync:        flag&oDSYNC != 0 && !readOnlyStorage,
+	}
+	whisper.frozen, err = readFrozen(path, header)
+	if err != nil {
+		return
+	}
+	if !whisper.readOnly {
+		err = whisper.replayJournal()
+	}
 	return
 }
 
-// Write a single datapoint to the whisper database
-func (w Whisper) Update(point Point) (err error) {
-	now := uint32(time.Now().Unix())
-	diff := now - point.Timestamp
-	if !((diff < w.Header.Metadata.MaxRetention) && diff >= 0) {
-		// TODO: Return an error
-		return
+// Close the underlying database file
+func (w *Whisper) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopSync != nil {
+		close(w.stopSync)
+		w.stopSync = nil
 	}
-
-	// Find the higher-precision archive that covers the timestamp
-	var lowerArchives []ArchiveInfo
-	var currentArchive ArchiveInfo
-	for i, currentArchive := range w.Header.Archives {
-		if currentArchive.Retention() < diff {
-			continue
-		}
-		lowerArchives = w.Header.Archives[i+1:]
+	if w.dirty {
+		w.sync()
 	}
+	return w.storage.Close()
+}
 
-	// Normalize the point's timestamp to the current archive's precision and write the point
-	point.Timestamp = point.Timestamp - (point.Timestamp % currentArchive.SecondsPerPoint)
-	err = w.writePoint(currentArchive, point)
+// Refresh checks whether the file at the database's path has been replaced since it was opened,
+// for example by Resize, and if so reopens it and reloads the header. Returns true if the database
+// was reopened.
+func (w *Whisper) Refresh() (reopened bool, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 
-	// Propagate data down to all the lower resolution archives
-	higherArchive := currentArchive
-	for _, lowerArchive := range lowerArchives {
-		result, e := w.propagate(point.Timestamp, higherArchive, lowerArchive)
-		if !result {
-			break
-		}
-		if e != nil {
-			err = e
-			return
-		}
-		higherArchive = lowerArchive
+	file, ok := w.storage.(*os.File)
+	if !ok || w.path == "" {
+		// Not backed by a file, nothing to replace it
+		return
+	}
+	current, err := os.Stat(w.path)
+	if err != nil {
+		return
+	}
+	opened, err := file.Stat()
+	if err != nil {
+		return
+	}
+	if os.SameFile(current, opened) {
+		return
 	}
 
-	return
+	flag := os.O_RDWR
+	if w.readOnly {
+		flag = os.O_RDONLY
+	} else if w.dataSync {
+		flag |= oDSYNC
+	}
+	replacement, err := open(w.path, flag)
+	if err != nil {
+		return
+	}
+	file.Close()
+	w.storage = replacement.storage
+	w.Header = replacement.Header
+	w.frozen = replacement.frozen
+	return true, nil
 }
 
-// Write a series of datapoints to the whisper database
-func (w Whisper) UpdateMany(points []Point) (err error) {
-	now := uint32(time.Now().Unix())
-
-	archiveIndex := 0
-	var currentArchive *ArchiveInfo
-	currentArchive = &w.Header.Archives[archiveIndex]
-	var currentPoints archive
-
-PointLoop:
-	for _, point := range points {
-		age := now - point.Timestamp
-
-		for currentArchive.Retention() < age {
-			if len(currentPoints) > 0 {
-				sort.Sort(reverseArchive{currentPoints})
-				w.archiveUpdateMany(*currentArchive, currentPoints)
-				currentPoints = currentPoints[:0]
-			}
-
-			archiveIndex += 1
-			if archiveIndex < len(w.Header.Archives) {
-				currentArchive = &w.Header.Archives[archiveIndex]
-			} else {
-				// Drop remaining points that don't fit in the db
-				currentArchive = nil
-				break PointLoop
-			}
-
-		}
-
-		currentPoints = append(currentPoints, point)
+// Open a database file. If it is opened for writing but its filesystem is mounted read-only, it is
+// opened for reading instead and readOnlyStorage is true.
+func openFile(path string, flag int) (file *os.File, readOnlyStorage bool, err error) {
+	file, err = os.OpenFile(path, flag, 0666)
+	if flag != os.O_RDONLY && isReadOnlyFS(err) {
+		file, err = os.OpenFile(path, os.O_RDONLY, 0666)
+		readOnlyStorage = err == nil
 	}
+	return
+}
 
-	if currentArchive != nil && len(currentPoints) > 0 {
-		sort.Sort(reverseArchive{currentPoints})
-		w.archiveUpdateMany(*currentArchive, currentPoints)
+// Returns the error for a write to a read-only database
+func (w *Whisper) errReadOnly() error {
+	if w.readOnlyStorage {
+		return ErrReadOnlyStorage
 	}
+	return ErrReadOnly
+}
 
-	return
+// Returns the path the database was opened from
+func (w *Whisper) Path() string {
+	return w.path
 }
 
-// Fetch all points since a timestamp
-func (w Whisper) Fetch(from uint32) (interval Interval, points []Point, err error) {
-	now := uint32(time.Now().Unix())
-	return w.FetchUntil(from, now)
+// Returns true if the database was opened with OpenReadOnly, or its filesystem is mounted read-only
+func (w *Whisper) ReadOnly() bool {
+	return w.readOnly
 }
 
-// Fetch all points between two timestamps
-func (w Whisper) FetchUntil(from, until uint32) (interval Interval, points []Point, err error) {
-	now := uint32(time.Now().Unix())
+// Returns the earliest timestamp any archive of the database can hold data for at the given time.
+// Fetches before this time can only return empty intervals.
+func (w *Whisper) EarliestTime(now uint32) uint32 {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.Header.earliestTime(now)
+}
 
-	// Tidy up the time ranges
-	oldest := now - w.Header.Metadata.MaxRetention
-	if from < oldest {
-		from = oldest
+/*
+Lock two handles, which may be the same, and return a function that unlocks them. a is locked for
+reading and b for reading, or for writing if write is set.
+
+The handles are locked in order of their addresses rather than their argument order, so calls
+with the same two handles in either order, such as Merge(a, b) and Diff(b, a), can't deadlock.
+*/
+func lockPair(a, b *Whisper, write bool) (unlock func()) {
+	lockB, unlockB := b.mutex.RLock, b.mutex.RUnlock
+	if write {
+		lockB, unlockB = b.mutex.Lock, b.mutex.Unlock
 	}
-	if from > until {
-		err = errors.New("from time is not less than until time")
+	if a == b {
+		lockB()
+		return unlockB
 	}
-	if until > now {
-		until = now
+	if uintptr(unsafe.Pointer(a)) < uintptr(unsafe.Pointer(b)) {
+		a.mutex.RLock()
+		lockB()
+	} else {
+		lockB()
+		a.mutex.RLock()
 	}
-
-	// Find the archive with enough retention to get be holding our data
-	var archive ArchiveInfo
-	diff := now - from
-	for _, info := range w.Header.Archives {
-		if info.Retention() >= diff {
-			archive = info
-			break
-		}
+	return func() {
+		unlockB()
+		a.mutex.RUnlock()
 	}
+}
 
-	step := archive.SecondsPerPoint
-	fromTimestamp := quantizeTimestamp(from, step) + step
-	fromOffset, err := w.pointOffset(archive, fromTimestamp)
-	if err != nil {
+// Check that the database can be written to and take a shared lock on it, so maintenance
+// operations can't start until the write is finished. Must be paired with endWrite.
+func (w *Whisper) beginWrite() (err error) {
+	if w.readOnly {
+		return w.errReadOnly()
+	}
+	file, ok := w.storage.(*os.File)
+	if !ok {
 		return
 	}
-
-	untilTimestamp := quantizeTimestamp(until, step) + step
-	untilOffset, err := w.pointOffset(archive, untilTimestamp)
+	err = lockShared(file)
 	if err != nil {
 		return
 	}
 
-	points, err = w.readPointsBetweenOffsets(archive, fromOffset, untilOffset)
-	interval = Interval{fromTimestamp, untilTimestamp, step}
+	// A maintenance operation that finished before the lock was taken may have renamed a new
+	// database over the file, and writes to the old one would be lost
+	if w.path != "" {
+		err = w.checkReplaced(file)
+		if err != nil {
+			unlockFile(file)
+		}
+	}
 	return
 }
 
-func (w Whisper) archiveUpdateMany(archiveInfo ArchiveInfo, points archive) (err error) {
-	type stampedArchive struct {
-		timestamp uint32
-		points    archive
+// Returns ErrReplaced if the file at the database's path is no longer the open file
+func (w *Whisper) checkReplaced(file *os.File) error {
+	opened, err := file.Stat()
+	if err != nil {
+		return err
 	}
-	var archives []stampedArchive
-	var currentPoints archive
-	var previousTimestamp, archiveStart uint32
+	current, err := os.Stat(w.path)
+	if os.IsNotExist(err) || err == nil && !os.SameFile(current, opened) {
+		return ErrReplaced
+	}
+	return err
+}
 
-	step := archiveInfo.SecondsPerPoint
-	points = quantizeArchive(points, step)
+// Release the lock taken by beginWrite
+func (w *Whisper) endWrite() {
+	if file, ok := w.storage.(*os.File); ok {
+		unlockFile(file)
+	}
+}
 
-	for _, point := range points {
-		if point.Timestamp == previousTimestamp {
-			// ignore values with duplicate timestamps
-			continue
+// Write a single datapoint to the whisper database
+func (w *Whisper) Update(point Point) (err error) {
+	_, err = w.chain(func(path string, points []Point) (report UpdateReport, err error) {
+		for _, point := range points {
+			written, e := w.update(point)
+			if e != nil {
+				return report, e
+			}
+			report.Accepted++
+			report.Written = append(report.Written, written)
 		}
+		return
+	})(w.path, []Point{point})
+	return
+}
 
-		if (previousTimestamp != 0) && (point.Timestamp != previousTimestamp+step) {
-			// the current point is not contiguous to the last, start a new series of points
-
-			// append the current archive to the archive list
-			archiveStart = previousTimestamp - (uint32(len(currentPoints)) * step)
-			archives = append(archives, stampedArchive{archiveStart, currentPoints})
+// Write a single datapoint, returning it as it was written before quantizing
+func (w *Whisper) update(point Point) (written Point, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 
-			// start a new archive
-			currentPoints = archive{}
+	err = w.beginWrite()
+	if err != nil {
+		return
+	}
+	defer w.endWrite()
+	defer w.afterUpdate(&err)
+
+	checked, err := w.checkNonFinite(w.snapTimestamps([]Point{point}))
+	if err != nil {
+		return
+	}
+	point = checked[0]
+	w.filterPoints(checked)
+	w.lastUpdate = time.Now()
+
+	err = w.beginJournal(checked)
+	if err != nil {
+		return
+	}
+	defer w.endJournal(&err)
+
+	now := uint32(time.Now().Unix())
+	if point.Timestamp > now || now-point.Timestamp >= w.Header.Metadata.MaxRetention {
+		err = errors.New(fmt.Sprintf("timestamp %d is not covered by any archive", point.Timestamp))
+		return
+	}
+	age := now - point.Timestamp
+
+	// Find the highest precision archive that covers the timestamp
+	var currentArchive ArchiveInfo
+	var lowerArchives []ArchiveInfo
+	for i, archive := range w.Header.Archives {
+		if archive.Retention() >= age {
+			currentArchive = archive
+			lowerArchives = w.Header.Archives[i+1:]
+			break
 		}
+	}
 
-		currentPoints = append(currentPoints, point)
-		previousTimestamp = point.Timestamp
+	// Normalize the point's timestamp to the current archive's precision and write the point
+	written = point
+	point.Timestamp = quantizeTimestamp(point.Timestamp, currentArchive.SecondsPerPoint)
+	err = w.writePoint(currentArchive, point)
+	if err != nil {
+		return
+	}
 
+	// Propagate data down to all the lower resolution archives
+	higherArchive := currentArchive
+	for _, lowerArchive := range lowerArchives {
+		result, e := w.propagate(point.Timestamp, higherArchive, lowerArchive)
+		if e != nil {
+			return written, e
+		}
+		if !result {
+			break
+		}
+		higherArchive = lowerArchive
 	}
 
-	if len(currentPoints) > 0 {
-		// If there are any more points remaining after the loop, make a new series for them as well
-		archiveStart = previousTimestamp - (uint32(len(currentPoints)) * step)
-		archives = append(archives, stampedArchive{archiveStart, currentPoints})
+	return
+}
+
+// Write a series of datapoints to the whisper database
+func (w *Whisper) UpdateMany(points []Point) (err error) {
+	return w.UpdateManyWithOptions(points, UpdateOptions{})
+}
+
+/*
+Write a series of datapoints to the whisper database like UpdateMany, giving up with ctx's error if
+it is cancelled before the update is finished. Cancellation is checked before each run of points is
+written to an archive and before each lower precision archive is propagated to, so a cancelled update
+may have written some of its points without propagating them. Call Propagate over the points' range
+to bring the lower precision archives up to date, or use SetJournaling to have it done on the next Open.
+*/
+func (w *Whisper) UpdateManyContext(ctx context.Context, points []Point) (err error) {
+	_, err = w.chain(func(path string, points []Point) (UpdateReport, error) {
+		return w.updateMany(ctx, points, UpdateOptions{})
+	})(w.path, points)
+	return
+}
+
+// Write a series of datapoints to the whisper database, as controlled by opts
+func (w *Whisper) UpdateManyWithOptions(points []Point, opts UpdateOptions) (err error) {
+	_, err = w.UpdateManyWithReport(points, opts)
+	return
+}
+
+// UpdateReport describes what became of the points written by UpdateManyWithReport
+type UpdateReport struct {
+	Accepted int     // Points written to an archive
+	Merged   int     // Accepted points combined with another in the same interval, see SetDuplicatePolicy
+	Dropped  []Point // Points not written as they are in the future or older than every archive's retention
+	Written  []Point // Accepted points as they were written, after snapping and coercion, newest first
+}
+
+// Write a series of datapoints to the whisper database, as controlled by opts, and report how many
+// were written and which were dropped. Points that middleware doesn't pass on aren't reported.
+func (w *Whisper) UpdateManyWithReport(points []Point, opts UpdateOptions) (report UpdateReport, err error) {
+	return w.chain(func(path string, points []Point) (UpdateReport, error) {
+		return w.updateMany(context.Background(), points, opts)
+	})(w.path, points)
+}
+
+func (w *Whisper) updateMany(ctx context.Context, points []Point, opts UpdateOptions) (report UpdateReport, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err = w.beginWrite()
+	if err != nil {
+		return
 	}
+	defer w.endWrite()
 
-	for _, archive := range archives {
-		err = w.writePoints(archiveInfo, archive.points)
+	points, err = w.checkNonFinite(w.snapTimestamps(points))
+	if err != nil {
+		return
+	}
+	w.lastUpdate = time.Now()
+	return w.writeJournaled(ctx, points, opts)
+}
+
+// Write a series of datapoints like writeMany, recording them in the journal if journaling is on
+// and applying the sync policy. Must be called with the write lock held, after beginWrite.
+func (w *Whisper) writeJournaled(ctx context.Context, points []Point, opts UpdateOptions) (report UpdateReport, err error) {
+	defer w.afterUpdate(&err)
+	w.filterPoints(points)
+
+	err = w.beginJournal(points)
+	if err != nil {
+		return
+	}
+	defer w.endJournal(&err)
+
+	return w.writeMany(ctx, points, opts)
+}
+
+// Write a series of datapoints to the archives that can hold them. Must be called with the
+// write lock held.
+func (w *Whisper) writeMany(ctx context.Context, points []Point, opts UpdateOptions) (report UpdateReport, err error) {
+	now := uint32(time.Now().Unix())
+
+	// Newest first, so each archive takes the points within its retention in turn
+	sorted := append(archive{}, points...)
+	sort.Stable(reverseArchive{sorted})
+
+	archiveIndex := 0
+	var currentArchive *ArchiveInfo
+	currentArchive = &w.Header.Archives[archiveIndex]
+	var currentPoints archive
+
+	// Write the points collected for the current archive
+	flush := func() (err error) {
+		written, err := w.archiveUpdateMany(ctx, *currentArchive, currentPoints, opts)
 		if err != nil {
-			return err
+			return
 		}
+		report.Accepted += len(currentPoints)
+		report.Merged += len(currentPoints) - written
+		report.Written = append(report.Written, currentPoints...)
+		currentPoints = currentPoints[:0]
+		return
 	}
 
-	higher := archiveInfo
-
-PropagateLoop:
-	for _, info := range w.Header.Archives {
-		if info.SecondsPerPoint < archiveInfo.SecondsPerPoint {
+PointLoop:
+	for i, point := range sorted {
+		if point.Timestamp > now {
+			report.Dropped = append(report.Dropped, point)
 			continue
 		}
+		age := now - point.Timestamp
 
-		quantizedPoints := quantizeArchive(points, info.SecondsPerPoint)
-		lastPoint := Point{0, 0}
-		for _, point := range quantizedPoints {
-			if point.Timestamp == lastPoint.Timestamp {
-				continue
+		for currentArchive.Retention() < age {
+			if len(currentPoints) > 0 {
+				err = flush()
+				if err != nil {
+					return
+				}
 			}
 
-			propagateFurther, err := w.propagate(point.Timestamp, higher, info)
-			if err != nil {
-				return err
-			}
-			if !propagateFurther {
-				break PropagateLoop
+			archiveIndex += 1
+			if archiveIndex < len(w.Header.Archives) {
+				currentArchive = &w.Header.Archives[archiveIndex]
+			} else {
+				// Drop remaining points that don't fit in the db
+				report.Dropped = append(report.Dropped, sorted[i:]...)
+				currentArchive = nil
+				break PointLoop
 			}
 
-			lastPoint = point
 		}
-		higher = info
+
+		currentPoints = append(currentPoints, point)
+	}
+
+	if currentArchive != nil && len(currentPoints) > 0 {
+		err = flush()
+	}
+	return
+}
+
+// Fetch all points since a timestamp
+func (w *Whisper) Fetch(from uint32) (interval Interval, points []Point, err error) {
+	now := uint32(time.Now().Unix())
+	return w.FetchUntil(from, now)
+}
+
+// Fetch all points between two timestamps. Intervals without data are represented according to
+// the handle's MissingPolicy, by default as points valued MissingNaN.
+func (w *Whisper) FetchUntil(from, until uint32) (interval Interval, points []Point, err error) {
+	return w.FetchContext(context.Background(), from, until)
+}
+
+// Fetch all points between two timestamps like FetchUntil, giving up with ctx's error if it is
+// cancelled before the archive has been read
+func (w *Whisper) FetchContext(ctx context.Context, from, until uint32) (interval Interval, points []Point, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	interval, points, err = w.fetchUntil(ctx, from, until)
+	if err != nil {
+		return
 	}
+	w.fillMissing(interval, points)
 	return
 }
 
-func (w Whisper) propagate(timestamp uint32, higher ArchiveInfo, lower ArchiveInfo) (result bool, err error) {
-	// The start of the lower resolution archive interval.
-	// Essentially a downsampling of the higher resolution timestamp.
-	lowerIntervalStart := timestamp - (timestamp % lower.SecondsPerPoint)
+// Read the slots of the best archive for a time range, as they are stored
+func (w *Whisper) fetchUntil(ctx context.Context, from, until uint32) (interval Interval, points []Point, err error) {
+	now := uint32(time.Now().Unix())
+
+	// Tidy up the time ranges
+	oldest := now - w.Header.Metadata.MaxRetention
+	if from < oldest {
+		from = oldest
+	}
+	if from > until {
+		err = errors.New("from time is not less than until time")
+	}
+	if until > now {
+		until = now
+	}
+
+	// Find the archive with enough retention to get be holding our data
+	archive := w.Header.archiveFor(from, now)
+	return w.fetchArchive(ctx, archive, from, until)
+}
+
+// Read the slots of an archive for a time range, which must be within the archive's retention
+func (w *Whisper) fetchArchive(ctx context.Context, archive ArchiveInfo, from, until uint32) (interval Interval, points []Point, err error) {
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+	step := archive.SecondsPerPoint
+	fromTimestamp := quantizeTimestamp(from, step) + step
+	untilTimestamp := quantizeTimestamp(until, step) + step
+	interval = Interval{fromTimestamp, untilTimestamp, step}
+	if w.intervalFilter != nil && fromTimestamp < untilTimestamp && !w.intervalFilter.MayContain(fromTimestamp, untilTimestamp) {
+		// Nothing was written in the range, skip reading it
+		points = make([]Point, (untilTimestamp-fromTimestamp)/step)
+		return
+	}
 
-	// The offset of the first point in the higher resolution data to be propagated down
-	higherFirstOffset, err := w.pointOffset(higher, lowerIntervalStart)
+	base, err := w.baseTimestamp(archive)
 	if err != nil {
 		return
 	}
+	if base == 0 && fromTimestamp < untilTimestamp {
+		// The archive has never been written
+		points = make([]Point, (untilTimestamp-fromTimestamp)/step)
+		return
+	}
 
-	// how many higher resolution points that go in to a lower resolution point
-	numHigherPoints := lower.SecondsPerPoint / higher.SecondsPerPoint
+	fromOffset, err := w.pointOffset(archive, fromTimestamp)
+	if err != nil {
+		return
+	}
 
-	// The total size of the higher resolution points
-	higherPointsSize := numHigherPoints * pointSize
+	untilOffset, err := w.pointOffset(archive, untilTimestamp)
+	if err != nil {
+		return
+	}
 
-	// The realtive offset of the first high res point
-	relativeFirstOffset := higherFirstOffset - higher.Offset
-	// The relative offset of the last high res point
-	relativeLastOffset := (relativeFirstOffset + higherPointsSize) % higher.size()
+	points, err = w.readPointsBetweenOffsets(ctx, archive, fromOffset, untilOffset)
+	return
+}
 
-	// The actual offset of the last high res point
-	higherLastOffset := relativeLastOffset + higher.Offset
+// Fetch the values between two timestamps as a series with one entry for every interval, in the form
+// graphite-web expects. Intervals without data are nil.
+func (w *Whisper) FetchSeries(from, until uint32) (series Series, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
 
-	points, err := w.readPointsBetweenOffsets(higher, higherFirstOffset, higherLastOffset)
+	interval, points, err := w.fetchUntil(context.Background(), from, until)
 	if err != nil {
 		return
 	}
+	return newSeries(interval, points), nil
+}
 
-	var neighborPoints []Point
-	currentInterval := lowerIntervalStart
-	for i := 0; i < len(points); i += 2 {
-		if points[i].Timestamp == currentInterval {
-			neighborPoints = append(neighborPoints, points[i])
+// Build a series from the slots read for an interval
+func newSeries(interval Interval, points []Point) (series Series) {
+	series = Series{interval.FromTimestamp, interval.UntilTimestamp, interval.Step, make([]*float64, len(points))}
+	for i, point := range points {
+		// Slots that don't hold the point for their interval are left over from an earlier cycle of the archive
+		if point.Timestamp == interval.FromTimestamp+uint32(i)*interval.Step {
+			value := point.Value
+			series.Values[i] = &value
 		}
-		currentInterval += higher.SecondsPerPoint
 	}
+	return
+}
 
-	knownPercent := float32(len(neighborPoints))/float32(len(points)) < w.Header.Metadata.XFilesFactor
-	if len(neighborPoints) == 0 || knownPercent {
-		// There's nothing to propagate
-		return false, nil
+/*
+FetchBestResolution fetches the values between two timestamps like FetchSeries, but reads every part of
+the range from the highest precision archive that still holds it, instead of reading the whole range
+from the archive that covers its start.
+
+The series has the step of the highest precision archive used. Values from lower precision archives are
+repeated for every step of the intervals they cover. If singleArchive is set the whole range is read
+from one archive, exactly like FetchSeries.
+*/
+func (w *Whisper) FetchBestResolution(from, until uint32, singleArchive bool) (series Series, err error) {
+	if singleArchive {
+		return w.FetchSeries(from, until)
 	}
 
-	aggregatePoint, err := aggregate(w.Header.Metadata.AggregationMethod, neighborPoints)
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	now := uint32(time.Now().Unix())
+	oldest := now - w.Header.Metadata.MaxRetention
+	if from < oldest {
+		from = oldest
+	}
+	if from > until {
+		return series, errors.New("from time is not less than until time")
+	}
+	if until > now {
+		until = now
+	}
+
+	step := w.Header.archiveFor(until, now).SecondsPerPoint
+	fromTimestamp := quantizeTimestamp(from, step) + step
+	untilTimestamp := quantizeTimestamp(until, step) + step
+	if untilTimestamp < fromTimestamp {
+		untilTimestamp = fromTimestamp
+	}
+	series = Series{fromTimestamp, untilTimestamp, step, make([]*float64, (untilTimestamp-fromTimestamp)/step)}
+
+	// The part of the range each archive holds, keyed by the archive's offset
+	parts := make(map[uint32]Series)
+	for i := range series.Values {
+		timestamp := fromTimestamp + uint32(i)*step
+		archive := w.Header.archiveFor(timestamp, now)
+		part, ok := parts[archive.Offset]
+		if !ok {
+			// Timestamps are visited in order, so this is the earliest one the archive is read for.
+			// Fetches start after the interval holding from, so start just before this one's interval.
+			partFrom := quantizeTimestamp(timestamp, archive.SecondsPerPoint) - 1
+			if start := archive.StartTime(now); partFrom < start {
+				partFrom = start
+			}
+			interval, points, e := w.fetchArchive(context.Background(), archive, partFrom, until)
+			if e != nil {
+				return Series{}, e
+			}
+			part = newSeries(interval, points)
+			parts[archive.Offset] = part
+		}
+
+		slot := quantizeTimestamp(timestamp, part.Step)
+		if slot >= part.From && slot < part.Until {
+			if value := part.Values[(slot-part.From)/part.Step]; value != nil {
+				// Repeated values get their own copy
+				repeated := *value
+				series.Values[i] = &repeated
+			}
+		}
+	}
+	return
+}
+
+/*
+FetchLast returns the points of the newest n intervals of the highest precision archive, up to and
+including the current one, oldest first. Intervals without data are left out, so fewer than n points
+are returned if the metric hasn't been written to in every interval.
+
+Only the n newest slots are read, which makes this much cheaper than FetchUntil for checks that
+only need the latest few samples.
+*/
+func (w *Whisper) FetchLast(n int) (points []Point, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	archive := w.Header.Archives[0]
+	if n <= 0 {
+		return
+	}
+	if uint32(n) > archive.Points {
+		n = int(archive.Points)
+	}
+	base, err := w.baseTimestamp(archive)
+	if err != nil || base == 0 {
+		return
+	}
+
+	step := archive.SecondsPerPoint
+	untilTimestamp := quantizeTimestamp(uint32(time.Now().Unix()), step)
+	fromTimestamp := untilTimestamp - uint32(n-1)*step
+	slots, err := w.readIntervals(archive, base, fromTimestamp, uint32(n))
 	if err != nil {
 		return
 	}
-	aggregatePoint.Timestamp = lowerIntervalStart
+	for i, point := range slots {
+		if point.Timestamp == fromTimestamp+uint32(i)*step {
+			points = append(points, point)
+		}
+	}
+	return
+}
 
-	err = w.writePoint(lower, aggregatePoint)
+// Look up the value at a timestamp in the highest precision archive that still holds it, reading only
+// the slot for the timestamp's interval. The point's timestamp is quantized to the archive's precision.
+// found is false if the interval has no data, or the timestamp is in the future or beyond the
+// database's retention.
+func (w *Whisper) ValueAt(timestamp uint32) (point Point, found bool, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
 
-	return true, nil
+	now := uint32(time.Now().Unix())
+	if timestamp > now || now-timestamp > w.Header.Metadata.MaxRetention {
+		return
+	}
+	archive := w.Header.archiveFor(timestamp, now)
+	base, err := w.baseTimestamp(archive)
+	if err != nil || base == 0 {
+		return
+	}
+
+	interval := quantizeTimestamp(timestamp, archive.SecondsPerPoint)
+	slot := make([]Point, 1)
+	err = w.readPoints(slotOffset(archive, base, interval), slot)
+	if err != nil || slot[0].Timestamp != interval {
+		return
+	}
+	return slot[0], true, nil
+}
+
+// Returns the most recent point written to any archive, skipping staleness markers. found is false
+// if the database holds no data.
+func (w *Whisper) Latest() (point Point, found bool, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	for _, archive := range w.Header.Archives {
+		latest, ok, e := w.latestIn(archive)
+		if e != nil {
+			return Point{}, false, e
+		}
+		if ok && (!found || latest.Timestamp > point.Timestamp) {
+			point, found = latest, true
+		}
+	}
+	return
+}
+
+// Returns the most recent point written to an archive, skipping staleness markers. found is false
+// if the archive holds no data.
+func (w *Whisper) LatestIn(archive ArchiveInfo) (point Point, found bool, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.latestIn(archive)
+}
+
+// Number of slots read at a time while looking for the latest or earliest point
+const latestChunk = 64
+
+func (w *Whisper) latestIn(archive ArchiveInfo) (point Point, found bool, err error) {
+	return w.latestMatching(archive, func(point Point) bool { return !IsStale(point.Value) })
+}
+
+// Returns the newest live point of an archive for which match returns true
+func (w *Whisper) latestMatching(archive ArchiveInfo, match func(point Point) bool) (point Point, found bool, err error) {
+	base, err := w.baseTimestamp(archive)
+	if err != nil || base == 0 {
+		return
+	}
+
+	// Look back from the current interval a chunk at a time, so a metric that is still being
+	// written only needs its newest slots read
+	step := archive.SecondsPerPoint
+	newest := quantizeTimestamp(uint32(time.Now().Unix()), step)
+	for back := uint32(0); back < archive.Points; back += latestChunk {
+		n := archive.Points - back
+		if n > latestChunk {
+			n = latestChunk
+		}
+		fromTimestamp := newest - (back+n-1)*step
+		slots, e := w.readIntervals(archive, base, fromTimestamp, n)
+		if e != nil {
+			return Point{}, false, e
+		}
+		for i := len(slots) - 1; i >= 0; i-- {
+			if slots[i].Timestamp == fromTimestamp+uint32(i)*step && match(slots[i]) {
+				return slots[i], true, nil
+			}
+		}
+	}
+	return
+}
+
+// Returns the oldest point any archive holds within its retention, skipping staleness markers. found
+// is false if the database holds no data.
+func (w *Whisper) Earliest() (point Point, found bool, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	for _, archive := range w.Header.Archives {
+		earliest, ok, e := w.earliestIn(archive)
+		if e != nil {
+			return Point{}, false, e
+		}
+		if ok && (!found || earliest.Timestamp < point.Timestamp) {
+			point, found = earliest, true
+		}
+	}
+	return
+}
+
+// Returns the oldest point an archive holds within its retention, skipping staleness markers. found
+// is false if the archive holds no data.
+func (w *Whisper) EarliestIn(archive ArchiveInfo) (point Point, found bool, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.earliestIn(archive)
+}
+
+func (w *Whisper) earliestIn(archive ArchiveInfo) (point Point, found bool, err error) {
+	base, err := w.baseTimestamp(archive)
+	if err != nil || base == 0 {
+		return
+	}
+
+	// Look forward from the oldest interval within the retention a chunk at a time
+	step := archive.SecondsPerPoint
+	oldest := quantizeTimestamp(uint32(time.Now().Unix()), step) - (archive.Points-1)*step
+	for forward := uint32(0); forward < archive.Points; forward += latestChunk {
+		n := archive.Points - forward
+		if n > latestChunk {
+			n = latestChunk
+		}
+		fromTimestamp := oldest + forward*step
+		slots, e := w.readIntervals(archive, base, fromTimestamp, n)
+		if e != nil {
+			return Point{}, false, e
+		}
+		for i, slot := range slots {
+			if slot.Timestamp == fromTimestamp+uint32(i)*step && !IsStale(slot.Value) {
+				return slot, true, nil
+			}
+		}
+	}
+	return
+}
+
+// Read the slots of n consecutive intervals of an archive starting at fromTimestamp, which must be
+// quantized to the archive's precision. n must not be more than the archive's points.
+func (w *Whisper) readIntervals(archive ArchiveInfo, base, fromTimestamp, n uint32) (slots []Point, err error) {
+	fromOffset := slotOffset(archive, base, fromTimestamp)
+	untilOffset := slotOffset(archive, base, fromTimestamp+(n-1)*archive.SecondsPerPoint) + pointSize
+	if untilOffset == archive.end() {
+		untilOffset = archive.Offset
+	}
+	return w.readPointsBetweenOffsets(context.Background(), archive, fromOffset, untilOffset)
+}
+
+// Find the highest precision archive with enough retention to hold data from a timestamp.
+// Falls back to the lowest precision archive if none of them reach back far enough.
+func (h Header) archiveFor(from, now uint32) ArchiveInfo {
+	var diff uint32
+	if from < now {
+		diff = now - from
+	}
+	for _, info := range h.Archives {
+		if info.Retention() >= diff {
+			return info
+		}
+	}
+	return h.Archives[len(h.Archives)-1]
+}
+
+/*
+SuggestStep returns the resolution, in seconds per point, that a fetch of the interval between from and until
+would be served at.
+
+The step is that of the archive FetchUntil reads from. If maxDataPoints is greater than zero and the
+interval would return more points than that, the step is multiplied the same way graphite consolidates
+a series down to maxDataPoints.
+*/
+func (w *Whisper) SuggestStep(from, until uint32, maxDataPoints int) (step uint32) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	now := uint32(time.Now().Unix())
+	step = w.Header.archiveFor(from, now).SecondsPerPoint
+	if maxDataPoints <= 0 || until <= from {
+		return
+	}
 
+	points := (until - from) / step
+	if points > uint32(maxDataPoints) {
+		valuesPerPoint := (points + uint32(maxDataPoints) - 1) / uint32(maxDataPoints)
+		step *= valuesPerPoint
+	}
+	return
+}
+
+// Write points to an archive and propagate them to the lower precision archives. Returns the number
+// of intervals written.
+func (w *Whisper) archiveUpdateMany(ctx context.Context, archiveInfo ArchiveInfo, points archive, opts UpdateOptions) (written int, err error) {
+	step := archiveInfo.SecondsPerPoint
+	unique, err := w.mergeDuplicates(points, step)
+	if err != nil {
+		return
+	}
+	written = len(unique)
+
+	// Write each run of consecutive intervals at once
+	for run := unique; len(run) > 0; {
+		n := 1
+		for n < len(run) && uint32(n) < archiveInfo.Points && run[n].Timestamp == run[n-1].Timestamp+step {
+			n++
+		}
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+		err = w.writePoints(archiveInfo, run[:n])
+		if err != nil {
+			return
+		}
+		run = run[n:]
+	}
+	if opts.SkipPropagation {
+		return
+	}
+
+	// Propagate the written intervals down through the lower precision archives, each archive
+	// passing on the intervals it was written in
+	timestamps := make([]uint32, len(unique))
+	for i, point := range unique {
+		timestamps[i] = point.Timestamp
+	}
+	higher := archiveInfo
+	for _, lower := range w.Header.Archives {
+		if lower.SecondsPerPoint <= archiveInfo.SecondsPerPoint {
+			continue
+		}
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+		timestamps, err = w.propagateMany(timestamps, higher, lower)
+		if err != nil || len(timestamps) == 0 {
+			return
+		}
+		higher = lower
+	}
+	return
+}
+
+// Propagate the interval of higher holding timestamp down to lower. Returns true if lower was written.
+func (w *Whisper) propagate(timestamp uint32, higher ArchiveInfo, lower ArchiveInfo) (result bool, err error) {
+	written, err := w.propagateMany([]uint32{timestamp}, higher, lower)
+	return len(written) > 0, err
+}
+
+/*
+Propagate the intervals of lower holding any of timestamps from the data of higher. Each run of
+consecutive intervals is read from higher at once, aggregated in memory and written to lower in
+contiguous runs. Intervals without enough known points for the xFilesFactor are left alone.
+
+Returns the start of every interval of lower that was written, for propagating further down.
+*/
+func (w *Whisper) propagateMany(timestamps []uint32, higher, lower ArchiveInfo) (written []uint32, err error) {
+	step := lower.SecondsPerPoint
+	var intervals []uint32
+	seen := make(map[uint32]bool)
+	for _, timestamp := range timestamps {
+		interval := quantizeTimestamp(timestamp, step)
+		if !seen[interval] {
+			seen[interval] = true
+			intervals = append(intervals, interval)
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+
+	base, err := w.baseTimestamp(higher)
+	if err != nil || base == 0 {
+		return
+	}
+
+	// Number of higher points in each lower interval, and the most intervals read at once
+	perInterval := step / higher.SecondsPerPoint
+	maxRun := higher.Points / perInterval
+	if maxRun == 0 {
+		maxRun = 1
+		perInterval = higher.Points
+	}
+
+	var aggregates archive
+	for i := 0; i < len(intervals); {
+		j := i + 1
+		for j < len(intervals) && uint32(j-i) < maxRun && intervals[j]-intervals[j-1] <= step {
+			j++
+		}
+		run := intervals[i:j]
+		i = j
+
+		first := run[0]
+		count := (run[len(run)-1]-first)/step + 1
+		slots, e := w.readIntervals(higher, base, first, count*perInterval)
+		if e != nil {
+			return nil, e
+		}
+		for k := uint32(0); k < count; k++ {
+			start := first + k*step
+			point, ok, e := w.aggregateInterval(higher, start, slots[k*perInterval:(k+1)*perInterval])
+			if e != nil {
+				return nil, e
+			}
+			if ok {
+				aggregates = append(aggregates, point)
+			}
+		}
+	}
+
+	// Write the aggregates in runs of consecutive intervals
+	for len(aggregates) > 0 {
+		n := 1
+		for n < len(aggregates) && uint32(n) < lower.Points && aggregates[n].Timestamp == aggregates[n-1].Timestamp+step {
+			n++
+		}
+		err = w.writePoints(lower, aggregates[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, point := range aggregates[:n] {
+			written = append(written, point.Timestamp)
+		}
+		aggregates = aggregates[n:]
+	}
+	return
+}
+
+// Aggregate the slots of higher covering the lower interval starting at start. Returns false if the
+// interval doesn't have enough known points for the xFilesFactor.
+func (w *Whisper) aggregateInterval(higher ArchiveInfo, start uint32, slots []Point) (point Point, ok bool, err error) {
+	var known []Point
+	for i, slot := range slots {
+		if slot.Timestamp == start+uint32(i)*higher.SecondsPerPoint {
+			known = append(known, slot)
+		}
+	}
+	known, poisoned := filterNonFinite(w.aggregateNonFinite, known)
+	if len(known) == 0 || float32(len(known))/float32(len(slots)) < w.Header.Metadata.XFilesFactor {
+		return
+	}
+
+	point, err = aggregate(w.Header.Metadata.AggregationMethod, known)
+	if err != nil {
+		return
+	}
+	if w.weightedAverage && w.Header.Metadata.AggregationMethod == AGGREGATION_AVERAGE {
+		weights, e := w.coverageWeights(higher, known, uint32(time.Now().Unix()))
+		if e != nil {
+			return point, false, e
+		}
+		point.Value = weightedAverage(known, weights)
+	}
+	if poisoned {
+		point.Value = math.NaN()
+	}
+	point.Timestamp = start
+	return point, true, nil
 }
 
 // Set the aggregation method for the database
-func (w Whisper) SetAggregationMethod(aggregationMethod AggregationMethod) (err error) {
-	//TODO: Validate the value of aggregationMethod
+func (w *Whisper) SetAggregationMethod(aggregationMethod AggregationMethod) (err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 
+	if aggregationMethod.String() == "unknown" {
+		return errors.New(fmt.Sprintf("unknown aggregation method %d", aggregationMethod))
+	}
+
+	err = w.beginWrite()
+	if err != nil {
+		return
+	}
+	defer w.endWrite()
+
+	err = w.writeAt(aggregationMethodOffset, aggregationMethod)
+	if err != nil {
+		return
+	}
 	w.Header.Metadata.AggregationMethod = aggregationMethod
-	_, err = w.file.Seek(0, 0)
+	return
+}
+
+// Set the xFilesFactor for the database, the fraction of points of a higher precision archive
+// that must be known for them to be propagated to a lower precision archive. Only the xFilesFactor
+// field of the header is written, so other handles' changes to the header aren't overwritten.
+func (w *Whisper) SetXFilesFactor(xFilesFactor float32) (err error) {
+	if !(xFilesFactor >= 0 && xFilesFactor <= 1) {
+		return errors.New(fmt.Sprintf("invalid xFilesFactor %g, must be between 0 and 1", xFilesFactor))
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err = w.beginWrite()
 	if err != nil {
 		return
 	}
+	defer w.endWrite()
 
-	err = binary.Write(w.file, binary.BigEndian, w.Header.Metadata)
+	err = w.writeAt(xFilesFactorOffset, xFilesFactor)
+	if err != nil {
+		return
+	}
+	w.Header.Metadata.XFilesFactor = xFilesFactor
 	return
 }
 
 // Read a single point from an offset in the database
-func (w Whisper) readPoint(offset uint32) (point Point, err error) {
+func (w *Whisper) readPoint(offset uint32) (point Point, err error) {
 	points := make([]Point, 1)
 	err = w.readPoints(offset, points)
 	point = points[0]
@@ -615,22 +1690,113 @@ func (w Whisper) readPoint(offset uint32) (point Point, err error) {
 }
 
 // Read a slice of points from an offset in the database
-func (w Whisper) readPoints(offset uint32, points []Point) (err error) {
-	_, err = w.file.Seek(int64(offset), 0)
+func (w *Whisper) readPoints(offset uint32, points []Point) (err error) {
+	return w.readAt(offset, points)
+}
+
+// Read points starting at offset a chunk at a time, giving up if ctx is cancelled between chunks
+func (w *Whisper) readPointsContext(ctx context.Context, offset uint32, points []Point) (err error) {
+	for len(points) > 0 {
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+		n := len(points)
+		if n > chunkPoints {
+			n = chunkPoints
+		}
+		err = w.readPoints(offset, points[:n])
+		if err != nil {
+			return
+		}
+		offset += uint32(n) * pointSize
+		points = points[n:]
+	}
+	return
+}
+
+// Read big endian encoded data from an offset in the database, without moving the file offset
+func (w *Whisper) readAt(offset uint32, data interface{}) (err error) {
+	return readFrom(w.storage, int64(offset), data)
+}
+
+// Write big endian encoded data at an offset in the database, without moving the file offset
+func (w *Whisper) writeAt(offset uint32, data interface{}) (err error) {
+	buf, err := binary.Append(nil, binary.BigEndian, data)
 	if err != nil {
 		return
 	}
-	err = binary.Read(w.file, binary.BigEndian, points)
+	_, err = w.storage.WriteAt(buf, int64(offset))
+	if isReadOnlyFS(err) {
+		// The filesystem was remounted read-only, stop trying to write to it
+		w.readOnly = true
+		w.readOnlyStorage = true
+		err = ErrReadOnlyStorage
+	}
 	return
 }
 
-func (w Whisper) readPointsBetweenOffsets(archive ArchiveInfo, startOffset, endOffset uint32) (points []Point, err error) {
+// Read every slot of an archive in the order they are stored, including slots that have never
+// been written, which hold a zero point. Useful for inspecting the raw contents of a database.
+func (w *Whisper) ReadSlots(info ArchiveInfo) (points []Point, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.readArchive(info)
+}
+
+// Read every slot of an archive in the order they are stored
+func (w *Whisper) readArchive(info ArchiveInfo) (points archive, err error) {
+	return w.readArchiveContext(context.Background(), info)
+}
+
+// Read every slot of an archive, giving up if ctx is cancelled between chunks
+func (w *Whisper) readArchiveContext(ctx context.Context, info ArchiveInfo) (points archive, err error) {
+	points = make(archive, info.Points)
+	err = w.readPointsContext(ctx, info.Offset, points)
+	return
+}
+
+// Replace the contents of an archive with a list of points. The points must be sorted,
+// quantized to the archive's precision and span no more than the archive's retention.
+// The oldest point is written to the first slot and becomes the archive's base point.
+func (w *Whisper) writeArchive(info ArchiveInfo, points archive) (err error) {
+	return w.writeArchiveContext(context.Background(), info, points)
+}
+
+// Replace the contents of an archive, giving up if ctx is cancelled between chunks
+func (w *Whisper) writeArchiveContext(ctx context.Context, info ArchiveInfo, points archive) (err error) {
+	slots := make(archive, info.Points)
+	if len(points) > 0 {
+		base := points[0].Timestamp
+		for _, point := range points {
+			slots[((point.Timestamp-base)/info.SecondsPerPoint)%info.Points] = point
+		}
+	}
+
+	for i := 0; i < len(slots); i += chunkPoints {
+		err = ctx.Err()
+		if err != nil {
+			return
+		}
+		end := i + chunkPoints
+		if end > len(slots) {
+			end = len(slots)
+		}
+		err = w.writeAt(info.Offset+uint32(i)*pointSize, slots[i:end])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (w *Whisper) readPointsBetweenOffsets(ctx context.Context, archive ArchiveInfo, startOffset, endOffset uint32) (points []Point, err error) {
 	archiveStart := archive.Offset
 	archiveEnd := archive.end()
 	if startOffset < endOffset {
 		// The selection is in the middle of the archive. eg: --####---
 		points = make([]Point, (endOffset-startOffset)/pointSize)
-		err = w.readPoints(startOffset, points)
+		err = w.readPointsContext(ctx, startOffset, points)
 		if err != nil {
 			return
 		}
@@ -640,11 +1806,11 @@ func (w Whisper) readPointsBetweenOffsets(archive ArchiveInfo, startOffset, endO
 		numBeginPoints := (endOffset - archiveStart) / pointSize
 		points = make([]Point, numBeginPoints+numEndPoints)
 
-		err = w.readPoints(startOffset, points[:numEndPoints])
+		err = w.readPointsContext(ctx, startOffset, points[:numEndPoints])
 		if err != nil {
 			return
 		}
-		err = w.readPoints(archiveStart, points[numEndPoints:])
+		err = w.readPointsContext(ctx, archiveStart, points[numEndPoints:])
 		if err != nil {
 			return
 		}
@@ -653,7 +1819,7 @@ func (w Whisper) readPointsBetweenOffsets(archive ArchiveInfo, startOffset, endO
 }
 
 // Write a point to an archive
-func (w Whisper) writePoint(archive ArchiveInfo, point Point) (err error) {
+func (w *Whisper) writePoint(archive ArchiveInfo, point Point) (err error) {
 	points := []Point{point}
 	err = w.writePoints(archive, points)
 	return
@@ -661,7 +1827,12 @@ func (w Whisper) writePoint(archive ArchiveInfo, point Point) (err error) {
 
 // Write a list of points to an archive in the order given
 // The offset is determined by the first point
-func (w Whisper) writePoints(archive ArchiveInfo, points []Point) (err error) {
+func (w *Whisper) writePoints(archive ArchiveInfo, points []Point) (err error) {
+	if w.frozen[archive.Offset] {
+		// Frozen archives only change through maintenance operations
+		return
+	}
+
 	nPoints := uint32(len(points))
 
 	// Sanity check
@@ -676,54 +1847,75 @@ func (w Whisper) writePoints(archive ArchiveInfo, points []Point) (err error) {
 		return
 	}
 
-	_, err = w.file.Seek(int64(offset), 0)
-	if err != nil {
-		return
-	}
-
 	maxPointsFromOffset := (archive.end() - offset) / pointSize
 	if nPoints > maxPointsFromOffset {
 		// Points span the beginning and end of the archive, eg: ##----###
-		err = binary.Write(w.file, binary.BigEndian, points[:maxPointsFromOffset])
+		err = w.writeAt(offset, points[:maxPointsFromOffset])
 		if err != nil {
 			return
 		}
 
-		_, err = w.file.Seek(int64(archive.Offset), 0)
-		if err != nil {
-			return
-		}
-
-		err = binary.Write(w.file, binary.BigEndian, points[maxPointsFromOffset:])
+		err = w.writeAt(archive.Offset, points[maxPointsFromOffset:])
 		if err != nil {
 			return
 		}
 	} else {
 		// Points are in the middle of the archive, eg: --####---
-		binary.Write(w.file, binary.BigEndian, points)
+		err = w.writeAt(offset, points)
 	}
 
 	return
 }
 
-// Get the offset of a timestamp within an archive
-func (w Whisper) pointOffset(archive ArchiveInfo, timestamp uint32) (offset uint32, err error) {
-	basePoint, err := w.readPoint(0)
+/*
+BaseTimestamp returns the timestamp of the point in the first slot of an archive. The slots of an
+archive are a ring buffer aligned to this point: the slot holding a timestamp is found by counting
+intervals from the base timestamp. Returns 0 if the archive has never been written.
+*/
+func (w *Whisper) BaseTimestamp(archive ArchiveInfo) (timestamp uint32, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.baseTimestamp(archive)
+}
+
+func (w *Whisper) baseTimestamp(archive ArchiveInfo) (timestamp uint32, err error) {
+	basePoint, err := w.readPoint(archive.Offset)
 	if err != nil {
 		return
 	}
-	if basePoint.Timestamp == 0 {
-		// The archive has never been written, this will be the new base point
-		offset = archive.Offset
-	} else {
-		timeDistance := timestamp - basePoint.Timestamp
-		pointDistance := timeDistance / archive.SecondsPerPoint
-		byteDistance := pointDistance * pointSize
-		offset = archive.Offset + (byteDistance % archive.size())
+	return basePoint.Timestamp, nil
+}
+
+// Get the offset of a timestamp within an archive
+func (w *Whisper) pointOffset(archive ArchiveInfo, timestamp uint32) (offset uint32, err error) {
+	base, err := w.baseTimestamp(archive)
+	if err != nil {
+		return
 	}
+	offset = slotOffset(archive, base, timestamp)
 	return
 }
 
+// Calculate the offset of the slot holding a timestamp in an archive whose first slot holds
+// the base timestamp. A base of 0 means the archive has never been written, and the timestamp
+// will become the new base point.
+func slotOffset(archive ArchiveInfo, base, timestamp uint32) uint32 {
+	if base == 0 {
+		return archive.Offset
+	}
+	timeDistance := int64(timestamp) - int64(base)
+	pointDistance := timeDistance / int64(archive.SecondsPerPoint)
+	if timeDistance < 0 && timeDistance%int64(archive.SecondsPerPoint) != 0 {
+		// Round towards the earlier slot
+		pointDistance--
+	}
+	slot := pointDistance % int64(archive.Points)
+	if slot < 0 {
+		slot += int64(archive.Points)
+	}
+	return archive.Offset + uint32(slot)*pointSize
+}
+
 /* 
 ParseArchiveInfo returns an ArchiveInfo represented by the string.
 
@@ -790,6 +1982,32 @@ func ParseArchiveInfo(archiveString string) (a ArchiveInfo, err error) {
 	return
 }
 
+/*
+ParseRetentionDefs returns the list of archives described by a comma separated list of
+"precision:retention" pairs, such as "10s:6h,1m:7d,10m:5y", in the format used by Graphite's
+storage-schemas.conf. Each pair is parsed by ParseArchiveInfo. The archives are sorted by
+precision and validated with ValidateArchiveList.
+*/
+func ParseRetentionDefs(retentionDefs string) (archives []ArchiveInfo, err error) {
+	for _, def := range strings.Split(retentionDefs, ",") {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+		archive, e := ParseArchiveInfo(def)
+		if e != nil {
+			return nil, e
+		}
+		archives = append(archives, archive)
+	}
+
+	err = ValidateArchiveList(archives)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
 func quantizeArchive(points archive, resolution uint32) archive {
 	result := archive{}
 	for _, point := range points {
@@ -798,6 +2016,16 @@ func quantizeArchive(points archive, resolution uint32) archive {
 	return result
 }
 
+// Returns the points in a map of interval to point, sorted by timestamp
+func sortedPoints(buckets map[uint32]Point) archive {
+	points := make(archive, 0, len(buckets))
+	for _, point := range buckets {
+		points = append(points, point)
+	}
+	sort.Sort(points)
+	return points
+}
+
 func quantizeTimestamp(timestamp uint32, resolution uint32) (quantized uint32) {
 	return timestamp - (timestamp % resolution)
 }
@@ -829,6 +2057,22 @@ func aggregate(aggregationMethod AggregationMethod, points []Point) (point Point
 				point.Value = p.Value
 			}
 		}
+	case AGGREGATION_ABSMAX:
+		point.Value = points[0].Value
+		for _, p := range points {
+			if math.Abs(p.Value) > math.Abs(point.Value) {
+				point.Value = p.Value
+			}
+		}
+	case AGGREGATION_ABSMIN:
+		point.Value = points[0].Value
+		for _, p := range points {
+			if math.Abs(p.Value) < math.Abs(point.Value) {
+				point.Value = p.Value
+			}
+		}
+	case AGGREGATION_FIRST:
+		point.Value = points[0].Value
 	default:
 		err = errors.New("unknown aggregation function")
 	}
@@ -1,7 +1,18 @@
 package whisper
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestQuantizeArchive(t *testing.T) {
@@ -28,7 +39,7 @@ func TestQuantizePoint(t *testing.T) {
 	for i, tt := range pointTests {
 		q := quantizeTimestamp(tt.in, tt.resolution)
 		if q != tt.out {
-			t.Errorf("%d. quantizePoint(%q, %q) => %q, want %q", i, tt.in, tt.resolution, q, tt.out)
+			t.Errorf("%d. quantizePoint(%d, %d) => %d, want %d", i, tt.in, tt.resolution, q, tt.out)
 		}
 	}
 }
@@ -60,6 +71,22 @@ func TestAggregate(t *testing.T) {
 		t.Errorf("Min failed to aggregate to %v, got %v: %v", expected, p, err)
 	}
 
+	signed := archive{Point{0, 3}, Point{0, -5}, Point{0, -1}, Point{0, 2}}
+	expected = Point{0, -5}
+	if p, err := aggregate(AGGREGATION_ABSMAX, signed); (p != expected) || (err != nil) {
+		t.Errorf("AbsMax failed to aggregate to %v, got %v: %v", expected, p, err)
+	}
+
+	expected = Point{0, -1}
+	if p, err := aggregate(AGGREGATION_ABSMIN, signed); (p != expected) || (err != nil) {
+		t.Errorf("AbsMin failed to aggregate to %v, got %v: %v", expected, p, err)
+	}
+
+	expected = Point{0, 3}
+	if p, err := aggregate(AGGREGATION_FIRST, signed); (p != expected) || (err != nil) {
+		t.Errorf("First failed to aggregate to %v, got %v: %v", expected, p, err)
+	}
+
 	if _, err := aggregate(1000, points); err == nil {
 		t.Errorf("No error for invalid aggregation")
 	}
@@ -80,3 +107,992 @@ func TestParseArchiveInfo(t *testing.T) {
 	}
 
 }
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	archives := []ArchiveInfo{{0, 60, 1440}}
+	if err := Create(path, archives, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if !w.ReadOnly() {
+		t.Errorf("ReadOnly() = false, want true")
+	}
+	if err := w.Update(Point{0, 1}); err != ErrReadOnly {
+		t.Errorf("Update: got %v, want %v", err, ErrReadOnly)
+	}
+	if err := w.UpdateMany([]Point{{0, 1}}); err != ErrReadOnly {
+		t.Errorf("UpdateMany: got %v, want %v", err, ErrReadOnly)
+	}
+	if err := w.SetAggregationMethod(AGGREGATION_SUM); err != ErrReadOnly {
+		t.Errorf("SetAggregationMethod: got %v, want %v", err, ErrReadOnly)
+	}
+}
+
+func TestReadHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	archives := []ArchiveInfo{{0, 60, 1440}, {0, 3600, 168}}
+	if err := Create(path, archives, 0.5, AGGREGATION_MAX, false); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+	r.Seek(10, 0)
+
+	header, err := ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos, _ := r.Seek(0, 1); pos != 10 {
+		t.Errorf("reader left at position %d, want 10", pos)
+	}
+
+	expected := Metadata{AGGREGATION_MAX, 168 * 3600, 0.5, 2}
+	if header.Metadata != expected {
+		t.Errorf("Metadata = %+v, want %+v", header.Metadata, expected)
+	}
+	if len(header.Archives) != 2 {
+		t.Fatalf("got %d archives, want 2", len(header.Archives))
+	}
+	for i, a := range header.Archives {
+		if a.SecondsPerPoint != archives[i].SecondsPerPoint || a.Points != archives[i].Points {
+			t.Errorf("archive %d = %+v, want %+v", i, a, archives[i])
+		}
+	}
+	// 16 bytes of metadata followed by two 12 byte archive infos
+	if header.Archives[0].Offset != 40 {
+		t.Errorf("archive 0 offset = %d, want 40", header.Archives[0].Offset)
+	}
+	if header.Archives[1].Offset != 40+1440*12 {
+		t.Errorf("archive 1 offset = %d, want %d", header.Archives[1].Offset, 40+1440*12)
+	}
+}
+
+func TestEarliestTime(t *testing.T) {
+	w := Whisper{Header: Header{
+		Metadata: Metadata{MaxRetention: 3600},
+		Archives: []ArchiveInfo{{0, 10, 60}, {0, 60, 60}},
+	}}
+
+	if start := w.Header.Archives[0].StartTime(10000); start != 9400 {
+		t.Errorf("StartTime(10000) = %d, want 9400", start)
+	}
+	if start := w.Header.Archives[1].StartTime(100); start != 0 {
+		t.Errorf("StartTime(100) = %d, want 0", start)
+	}
+	if earliest := w.EarliestTime(10000); earliest != 6400 {
+		t.Errorf("EarliestTime(10000) = %d, want 6400", earliest)
+	}
+	if earliest := w.EarliestTime(100); earliest != 0 {
+		t.Errorf("EarliestTime(100) = %d, want 0", earliest)
+	}
+}
+
+func TestSuggestStep(t *testing.T) {
+	w := Whisper{Header: Header{
+		Metadata: Metadata{MaxRetention: 86400 * 7},
+		Archives: []ArchiveInfo{{0, 10, 360}, {0, 60, 1440}, {0, 3600, 168}},
+	}}
+	now := uint32(time.Now().Unix())
+
+	tests := []struct {
+		from, until   uint32
+		maxDataPoints int
+		step          uint32
+	}{
+		{now - 600, now, 0, 10},
+		{now - 7200, now, 0, 60},
+		{now - 86400*2, now, 0, 3600},
+		{now - 86400*30, now, 0, 3600},
+		{now - 600, now, 30, 20},
+		{now - 3000, now, 10, 300},
+		{now - 600, now, 1000, 10},
+	}
+
+	for i, tt := range tests {
+		if step := w.SuggestStep(tt.from, tt.until, tt.maxDataPoints); step != tt.step {
+			t.Errorf("%d. SuggestStep(now-%d, now, %d) = %d, want %d", i, now-tt.from, tt.maxDataPoints, step, tt.step)
+		}
+	}
+}
+
+func TestParseRetentionDefs(t *testing.T) {
+	archives, err := ParseRetentionDefs("1m:7d, 10s:6h,10m:5y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ArchiveInfo{{0, 10, 2160}, {0, 60, 10080}, {0, 600, 262080}}
+	if len(archives) != len(expected) {
+		t.Fatalf("got %v, want %v", archives, expected)
+	}
+	for i := range expected {
+		if archives[i] != expected[i] {
+			t.Errorf("archive %d = %v, want %v", i, archives[i], expected[i])
+		}
+	}
+
+	for _, defs := range []string{"", "10s:6h,10s:1d", "10s:6h,15s:7d", "10s:6h,bogus"} {
+		if _, err := ParseRetentionDefs(defs); err == nil {
+			t.Errorf("%q: no error", defs)
+		}
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 1440}}, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Each writer owns 10 intervals, half of the writers use Update and the others UpdateMany,
+	// while readers fetch the whole range
+	const writers, perWriter = 8, 10
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 60)
+	newest := now - 60
+	oldest := newest - (writers*perWriter-1)*60
+	var wg sync.WaitGroup
+	for g := 0; g < writers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			var points []Point
+			for j := 0; j < perWriter; j++ {
+				i := g*perWriter + j
+				points = append(points, Point{newest - uint32(i)*60, float64(i)})
+			}
+			if g%2 == 0 {
+				if err := w.UpdateMany(points); err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			for _, point := range points {
+				if err := w.Update(point); err != nil {
+					t.Error(err)
+				}
+			}
+		}(g)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				_, points, err := w.FetchUntil(oldest-60, newest)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(points) != writers*perWriter {
+					t.Errorf("fetched %d points, want %d", len(points), writers*perWriter)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	reopened, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	_, points, err := reopened.FetchUntil(oldest-60, newest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != writers*perWriter {
+		t.Fatalf("fetched %d points, want %d", len(points), writers*perWriter)
+	}
+	for _, point := range points {
+		expected := Point{point.Timestamp, float64((newest - point.Timestamp) / 60)}
+		if point != expected {
+			t.Errorf("point %v, want %v", point, expected)
+		}
+	}
+}
+
+func TestOpenHeaderOnly(t *testing.T) {
+	archives := []ArchiveInfo{{0, 60, 1440}, {0, 3600, 168}}
+	w, err := NewMemory(archives, 0.5, AGGREGATION_LAST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := w.storage.(*MemoryStorage).Bytes()
+
+	// Only the header is available, as if the rest hadn't been downloaded yet
+	header, err := OpenHeaderOnly(bytes.NewBuffer(data[:w.Header.size()]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Metadata != w.Header.Metadata {
+		t.Errorf("Metadata = %+v, want %+v", header.Metadata, w.Header.Metadata)
+	}
+	for i := range archives {
+		if header.Archives[i] != w.Header.Archives[i] {
+			t.Errorf("archive %d = %+v, want %+v", i, header.Archives[i], w.Header.Archives[i])
+		}
+	}
+
+	if _, err := OpenHeaderOnly(bytes.NewBuffer(data[:30])); err == nil {
+		t.Errorf("no error for a truncated header")
+	}
+}
+
+func TestCorruptHeader(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 10}, {0, 600, 10}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := w.storage.(*MemoryStorage).Bytes()
+	archiveTable := metadataSize
+
+	for _, tt := range []struct {
+		name   string
+		damage func(data []byte) []byte
+	}{
+		{"no archives", func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[archiveCountOffset:], 0)
+			return data
+		}},
+		{"huge archive count", func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[archiveCountOffset:], math.MaxUint32)
+			return data
+		}},
+		{"archive table past the end", func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[archiveCountOffset:], 40)
+			return data
+		}},
+		{"zero step", func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[archiveTable+4:], 0)
+			return data
+		}},
+		{"unsorted archives", func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[archiveTable+4:], 6000)
+			return data
+		}},
+		{"archive inside the header", func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[archiveTable:], 0)
+			return data
+		}},
+		{"overlapping archives", func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[archiveTable+archiveSize:], w.Header.Archives[0].Offset+pointSize)
+			return data
+		}},
+		{"archive past the end", func(data []byte) []byte {
+			return data[:len(data)-1]
+		}},
+		{"archive past the largest offset", func(data []byte) []byte {
+			binary.BigEndian.PutUint32(data[archiveTable+archiveSize:], math.MaxUint32-pointSize)
+			return data
+		}},
+	} {
+		data := tt.damage(append([]byte(nil), valid...))
+		_, err := OpenStorage(NewMemoryStorage(data), true)
+		if !errors.Is(err, ErrCorruptHeader) {
+			t.Errorf("%s: OpenStorage error = %v, want ErrCorruptHeader", tt.name, err)
+		}
+		if _, ok := err.(*CorruptHeaderError); !ok {
+			t.Errorf("%s: OpenStorage error is %T, want *CorruptHeaderError", tt.name, err)
+		}
+	}
+
+	// Without a size only the archive table itself can be checked
+	if _, err := OpenHeaderOnly(bytes.NewReader(valid[:w.Header.size()])); err != nil {
+		t.Errorf("OpenHeaderOnly: %v", err)
+	}
+}
+
+func TestBaseTimestamp(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 10}, {0, 600, 10}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, low := w.Header.Archives[0], w.Header.Archives[1]
+	if err := w.writeArchive(high, archive{{1200, 1}, {1260, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeArchive(low, archive{{6000, 3}, {6600, 4}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		info   ArchiveInfo
+		base   uint32
+		stamp  uint32
+		offset uint32
+	}{
+		{high, 1200, 1260, high.Offset + pointSize},
+		{low, 6000, 6600, low.Offset + pointSize},
+		{low, 6000, 5400, low.Offset + 9*pointSize},
+	} {
+		base, err := w.BaseTimestamp(tt.info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if base != tt.base {
+			t.Errorf("BaseTimestamp(%+v) = %d, want %d", tt.info, base, tt.base)
+		}
+		offset, err := w.pointOffset(tt.info, tt.stamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != tt.offset {
+			t.Errorf("pointOffset(%+v, %d) = %d, want %d", tt.info, tt.stamp, offset, tt.offset)
+		}
+	}
+}
+
+func TestFetchUnwrittenArchive(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 10, 360}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	interval, points, err := w.FetchUntil(now-60, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint32(len(points)) != (interval.UntilTimestamp-interval.FromTimestamp)/interval.Step {
+		t.Errorf("got %d points for %+v", len(points), interval)
+	}
+}
+
+func TestFetchSeries(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 10, 360}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	if err := w.writeArchive(w.Header.Archives[0], archive{{now - 50, 1}, {now - 30, 3}, {now - 20, 4}}); err != nil {
+		t.Fatal(err)
+	}
+
+	series, err := w.FetchSeries(now-60, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.From != now-50 || series.Until != now+10 || series.Step != 10 {
+		t.Errorf("series covers %d-%d step %d, want %d-%d step 10", series.From, series.Until, series.Step, now-50, now+10)
+	}
+	expected := []float64{1, -1, 3, 4, -1, -1} // -1 marks a missing value
+	if len(series.Values) != len(expected) {
+		t.Fatalf("got %d values, want %d", len(series.Values), len(expected))
+	}
+	for i, value := range series.Values {
+		if (value == nil) != (expected[i] == -1) || (value != nil && *value != expected[i]) {
+			t.Errorf("value %d = %v, want %g", i, value, expected[i])
+		}
+	}
+}
+
+func TestFetchBestResolution(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 10, 6}, {0, 60, 10}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	fine := quantizeTimestamp(now, 10)
+	coarse := quantizeTimestamp(now, 60)
+	var finePoints, coarsePoints archive
+	for ts := fine - 50; ts <= fine; ts += 10 {
+		finePoints = append(finePoints, Point{ts, float64(ts)})
+	}
+	for ts := coarse - 540; ts <= coarse; ts += 60 {
+		coarsePoints = append(coarsePoints, Point{ts, -float64(ts)})
+	}
+	if err := w.writeArchive(w.Header.Archives[0], finePoints); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeArchive(w.Header.Archives[1], coarsePoints); err != nil {
+		t.Fatal(err)
+	}
+
+	series, err := w.FetchBestResolution(now-300, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.Step != 10 || len(series.Values) != 30 {
+		t.Fatalf("got %d values with step %d, want 30 with step 10", len(series.Values), series.Step)
+	}
+	for i, value := range series.Values {
+		timestamp := series.From + uint32(i)*series.Step
+		var expected float64
+		switch {
+		case timestamp+70 <= now:
+			// Only the coarse archive holds this interval
+			expected = -float64(quantizeTimestamp(timestamp, 60))
+		case timestamp+50 >= now:
+			expected = float64(timestamp)
+		default:
+			// Too close to the edge of the fine archive's retention to be sure which archive is used
+			continue
+		}
+		if value == nil || *value != expected {
+			t.Errorf("value at %d = %v, want %g", timestamp, value, expected)
+		}
+	}
+
+	single, err := w.FetchBestResolution(now-300, now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.Step != 60 {
+		t.Errorf("single archive fetch has step %d, want 60", single.Step)
+	}
+}
+
+func TestValueAt(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 10, 6}, {0, 60, 10}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	fine := quantizeTimestamp(now, 10)
+	coarse := quantizeTimestamp(now, 60)
+	if err := w.writeArchive(w.Header.Archives[0], archive{{fine - 30, 1}, {fine - 20, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeArchive(w.Header.Archives[1], archive{{coarse - 300, 5}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		timestamp uint32
+		point     Point
+		found     bool
+	}{
+		{fine - 25, Point{fine - 30, 1}, true},
+		{fine - 20, Point{fine - 20, 2}, true},
+		{fine - 10, Point{}, false},
+		{coarse - 270, Point{coarse - 300, 5}, true},
+		{coarse - 240, Point{}, false},
+		{now + 60, Point{}, false},
+		{now - 700, Point{}, false},
+	} {
+		point, found, err := w.ValueAt(test.timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if point != test.point || found != test.found {
+			t.Errorf("ValueAt(now%+d) = %v, %t, want %v, %t", int64(test.timestamp)-int64(now), point, found, test.point, test.found)
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 10, 100}, {0, 60, 100}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := w.Latest(); err != nil || found {
+		t.Errorf("Latest of an empty database found a point, %v", err)
+	}
+
+	now := uint32(time.Now().Unix())
+	fine := quantizeTimestamp(now, 10)
+	coarse := quantizeTimestamp(now, 60)
+	// More than one chunk back, followed by a staleness marker which doesn't count
+	if err := w.writeArchive(w.Header.Archives[0], archive{{fine - 800, 1}, {fine - 10, StaleNaN}}); err != nil {
+		t.Fatal(err)
+	}
+	point, found, err := w.LatestIn(w.Header.Archives[0])
+	if err != nil || !found || point != (Point{fine - 800, 1}) {
+		t.Errorf("LatestIn(archive 0) = %v, %t, %v", point, found, err)
+	}
+
+	if err := w.writeArchive(w.Header.Archives[1], archive{{coarse - 3000, 2}, {coarse - 60, 3}}); err != nil {
+		t.Fatal(err)
+	}
+	point, found, err = w.Latest()
+	if err != nil || !found || point != (Point{coarse - 60, 3}) {
+		t.Errorf("Latest() = %v, %t, %v, want the newer point of archive 1", point, found, err)
+	}
+}
+
+func TestEarliest(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 10, 100}, {0, 60, 100}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := w.Earliest(); err != nil || found {
+		t.Errorf("Earliest of an empty database found a point, %v", err)
+	}
+
+	now := uint32(time.Now().Unix())
+	fine := quantizeTimestamp(now, 10)
+	coarse := quantizeTimestamp(now, 60)
+	// A staleness marker which doesn't count, followed by a point more than one chunk in
+	if err := w.writeArchive(w.Header.Archives[0], archive{{fine - 900, StaleNaN}, {fine - 200, 1}, {fine - 10, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	point, found, err := w.EarliestIn(w.Header.Archives[0])
+	if err != nil || !found || point != (Point{fine - 200, 1}) {
+		t.Errorf("EarliestIn(archive 0) = %v, %t, %v", point, found, err)
+	}
+
+	if err := w.writeArchive(w.Header.Archives[1], archive{{coarse - 3000, 3}, {coarse - 60, 4}}); err != nil {
+		t.Fatal(err)
+	}
+	point, found, err = w.Earliest()
+	if err != nil || !found || point != (Point{coarse - 3000, 3}) {
+		t.Errorf("Earliest() = %v, %t, %v, want the older point of archive 1", point, found, err)
+	}
+}
+
+func TestSetXFilesFactor(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 10}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetXFilesFactor(1.5); err == nil {
+		t.Error("accepted an xFilesFactor above 1")
+	}
+	if err := w.SetXFilesFactor(0.25); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenStorage(w.storage, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Header.Metadata.XFilesFactor != 0.25 {
+		t.Errorf("xFilesFactor on disk = %g, want 0.25", reopened.Header.Metadata.XFilesFactor)
+	}
+}
+
+func TestSetMetadataFieldsIndependently(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 10}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second handle with a stale copy of the header must not undo the first handle's change
+	stale, err := OpenStorage(w.storage, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetXFilesFactor(0.25); err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.SetAggregationMethod(AGGREGATION_MAX); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenStorage(w.storage, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := reopened.Header.Metadata
+	if metadata.XFilesFactor != 0.25 || metadata.AggregationMethod != AGGREGATION_MAX {
+		t.Errorf("metadata on disk = %+v, want xFilesFactor 0.25 and max aggregation", metadata)
+	}
+}
+
+func TestParseAggregationMethod(t *testing.T) {
+	for _, method := range []AggregationMethod{AGGREGATION_AVERAGE, AGGREGATION_SUM, AGGREGATION_LAST, AGGREGATION_MAX, AGGREGATION_MIN,
+		AGGREGATION_ABSMAX, AGGREGATION_ABSMIN, AGGREGATION_FIRST} {
+		parsed, err := ParseAggregationMethod(method.String())
+		if err != nil || parsed != method {
+			t.Errorf("ParseAggregationMethod(%q) = %d, %v, want %d", method.String(), parsed, err, method)
+		}
+	}
+	if _, err := ParseAggregationMethod("median"); err == nil {
+		t.Error("expected an error for an unknown aggregation method")
+	}
+	if s := fmt.Sprint(AGGREGATION_MAX); s != "max" {
+		t.Errorf("AGGREGATION_MAX formats as %q", s)
+	}
+}
+
+func TestFetchLast(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 10, 6}}, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if points, err := w.FetchLast(3); err != nil || len(points) != 0 {
+		t.Errorf("FetchLast on an empty archive = %v, %v", points, err)
+	}
+
+	// Place the base so the newest points wrap around the end of the ring buffer
+	now := quantizeTimestamp(uint32(time.Now().Unix()), 10)
+	info := w.Header.Archives[0]
+	slots := archive{{now - 30, 3}, {now - 20, 4}, {now - 10, 5}, {now, 6}, {now - 50, 1}, {now - 40, 2}}
+	if err := w.writeAt(info.Offset, slots); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		n        int
+		expected []Point
+	}{
+		{1, []Point{{now, 6}}},
+		{3, []Point{{now - 20, 4}, {now - 10, 5}, {now, 6}}},
+		{100, []Point{{now - 50, 1}, {now - 40, 2}, {now - 30, 3}, {now - 20, 4}, {now - 10, 5}, {now, 6}}},
+	} {
+		points, err := w.FetchLast(tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(points) != len(tt.expected) {
+			t.Errorf("FetchLast(%d) = %v, want %v", tt.n, points, tt.expected)
+			continue
+		}
+		for i := range points {
+			if points[i] != tt.expected[i] {
+				t.Errorf("FetchLast(%d) = %v, want %v", tt.n, points, tt.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestCreateWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	archives := []ArchiveInfo{{0, 60, 10000}, {0, 3600, 240}}
+	for i, opts := range []CreateOptions{{Sync: true}, {Sparse: true}, {Preallocate: true}} {
+		path := filepath.Join(dir, fmt.Sprintf("%d.wsp", i))
+		if err := CreateWithOptions(path, archives, 0.5, AGGREGATION_AVERAGE, opts); err != nil {
+			t.Fatal(err)
+		}
+		w, err := OpenReadOnly(path)
+		if err != nil {
+			t.Fatalf("%+v: %s", opts, err)
+		}
+		slots, err := w.ReadSlots(w.Header.Archives[1])
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, slot := range slots {
+			if slot != (Point{}) {
+				t.Errorf("%+v: archive isn't zeroed", opts)
+				break
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(w.Header.end()) {
+			t.Errorf("%+v: file is %d bytes, want %d", opts, info.Size(), w.Header.end())
+		}
+	}
+	path := filepath.Join(dir, "0.wsp")
+
+	// Failing because the file exists must leave it alone
+	if err := Create(path, archives, 0.5, AGGREGATION_AVERAGE, false); err == nil {
+		t.Error("Create replaced an existing database")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("existing database was removed: %v", err)
+	}
+}
+
+func TestCreateMkdirAll(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "servers", "web1", "cpu.wsp")
+	archives := []ArchiveInfo{{0, 60, 10}}
+	if err := Create(path, archives, 0.5, AGGREGATION_AVERAGE, false); !os.IsNotExist(err) {
+		t.Errorf("Create without MkdirAll returned %v, want a not exist error", err)
+	}
+	if err := CreateWithOptions(path, archives, 0.5, AGGREGATION_AVERAGE, CreateOptions{MkdirAll: true, DirMode: 0700}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("directory mode = %v, want 0700", info.Mode().Perm())
+	}
+}
+
+func TestCreateValidatesArchives(t *testing.T) {
+	dir := t.TempDir()
+	for _, archives := range [][]ArchiveInfo{
+		{},
+		{{0, 60, 10}, {0, 60, 20}},
+		{{0, 60, 10080}, {0, 3600, 24}},
+		{{0, 60, 100}, {0, 90, 100}},
+	} {
+		path := filepath.Join(dir, "invalid.wsp")
+		if err := Create(path, archives, 0.5, AGGREGATION_AVERAGE, false); err == nil {
+			t.Errorf("Create accepted archives %v", archives)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Create left a file behind for archives %v", archives)
+		}
+	}
+
+	// Archives given out of order are laid out in order of precision, without changing the caller's list
+	path := filepath.Join(dir, "unordered.wsp")
+	archives := []ArchiveInfo{{0, 60, 10080}, {0, 10, 2160}}
+	if err := Create(path, archives, 0.5, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	if archives[0].SecondsPerPoint != 60 {
+		t.Errorf("Create reordered the given archives to %v", archives)
+	}
+	w, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Header.Archives[0].SecondsPerPoint != 10 || w.Header.Archives[1].SecondsPerPoint != 60 {
+		t.Errorf("created archives %v", w.Header.Archives)
+	}
+}
+
+func TestCreateValidatesMetadata(t *testing.T) {
+	dir := t.TempDir()
+	archives := []ArchiveInfo{{0, 60, 10}}
+	for _, tt := range []struct {
+		xFilesFactor      float32
+		aggregationMethod AggregationMethod
+	}{
+		{0.5, AGGREGATION_UNKNOWN},
+		{0.5, 100},
+		{7.5, AGGREGATION_AVERAGE},
+		{-1, AGGREGATION_AVERAGE},
+	} {
+		path := filepath.Join(dir, "invalid.wsp")
+		if err := Create(path, archives, tt.xFilesFactor, tt.aggregationMethod, false); err == nil {
+			t.Errorf("Create accepted xFilesFactor %g and aggregation method %d", tt.xFilesFactor, tt.aggregationMethod)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Create left a file behind for invalid metadata")
+		}
+	}
+
+	w, err := NewMemory(archives, 0.5, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetAggregationMethod(AGGREGATION_UNKNOWN); err == nil {
+		t.Error("SetAggregationMethod accepted an unknown method")
+	}
+	if w.Header.Metadata.AggregationMethod != AGGREGATION_AVERAGE {
+		t.Errorf("aggregation method changed to %d", w.Header.Metadata.AggregationMethod)
+	}
+}
+
+// A MemoryStorage counting its reads
+type readCounter struct {
+	*MemoryStorage
+	reads int
+}
+
+func (r *readCounter) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	return r.MemoryStorage.ReadAt(p, off)
+}
+
+func TestBatchPropagation(t *testing.T) {
+	memory, err := NewMemory([]ArchiveInfo{{0, 60, 60}, {0, 300, 24}, {0, 900, 12}}, 0, AGGREGATION_SUM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := &readCounter{MemoryStorage: memory.storage.(*MemoryStorage)}
+	w, err := OpenStorage(storage, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := uint32(time.Now().Unix())
+	start := now - now%900 - 1800
+	var points []Point
+	for timestamp := start; timestamp < start+1800; timestamp += 60 {
+		points = append(points, Point{timestamp, 1})
+	}
+	storage.reads = 0
+	if err := w.UpdateMany(points); err != nil {
+		t.Fatal(err)
+	}
+	// The base of the first archive, then for each lower archive the base and the run of the archive
+	// above and its own base, rather than reads for every point
+	if storage.reads > 7 {
+		t.Errorf("update read the database %d times", storage.reads)
+	}
+
+	dump, err := w.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []struct {
+		intervals int
+		sum       float64
+	}{{30, 1}, {6, 5}, {2, 15}} {
+		data := dump.Archives[i].Data
+		if len(data) != expected.intervals {
+			t.Errorf("archive %d holds %d points, want %d", i, len(data), expected.intervals)
+		}
+		for _, point := range data {
+			if point.Value != expected.sum {
+				t.Errorf("archive %d holds %v, want a sum of %g", i, point, expected.sum)
+				break
+			}
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 10}, {0, 300, 10}}, 0, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	now := uint32(time.Now().Unix())
+	recent := Point{now - 30, 1.5}
+	old := Point{now - 2000, 2}
+	for _, point := range []Point{recent, old} {
+		if err := w.Update(point); err != nil {
+			t.Fatalf("Update(%v): %s", point, err)
+		}
+	}
+
+	// The recent point is written to the first archive at its precision
+	interval, points, err := w.FetchUntil(now-300, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interval.Step != 60 {
+		t.Errorf("fetched from the archive with step %d, want 60", interval.Step)
+	}
+	var fetched bool
+	for _, point := range points {
+		if point == (Point{quantizeTimestamp(recent.Timestamp, 60), recent.Value}) {
+			fetched = true
+		}
+	}
+	if !fetched {
+		t.Errorf("fetched %v, which doesn't hold %v", points, recent)
+	}
+
+	// It was propagated to the second archive, which the old point was written to directly
+	point, found, err := w.LatestIn(w.Header.Archives[1])
+	if err != nil || !found || point != (Point{quantizeTimestamp(recent.Timestamp, 300), recent.Value}) {
+		t.Errorf("got %v, %v, %v for the propagated point", point, found, err)
+	}
+	point, found, err = w.ValueAt(old.Timestamp)
+	if err != nil || !found || point != (Point{quantizeTimestamp(old.Timestamp, 300), old.Value}) {
+		t.Errorf("got %v, %v, %v for the old point", point, found, err)
+	}
+
+	// Points no archive covers are rejected
+	for _, point := range []Point{{now + 60, 1}, {now - 4000, 1}} {
+		if err := w.Update(point); err == nil {
+			t.Errorf("Update(%v) succeeded", point)
+		}
+	}
+}
+
+func TestUpdateManyWithReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wsp")
+	if err := Create(path, []ArchiveInfo{{0, 60, 10}, {0, 300, 10}}, 0, AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	now := uint32(time.Now().Unix())
+	recent := now - now%60 - 60
+	tooOld := Point{now - 4000, 1}
+	future := Point{now + 600, 1}
+	old := Point{now - 2000, 2}
+	// Out of order, so the points too old or in the future come before ones that can be written
+	points := []Point{tooOld, {recent, 3}, future, old, {recent + 1, 4}}
+
+	report, err := w.UpdateManyWithReport(points, UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accepted != 3 || report.Merged != 1 {
+		t.Errorf("accepted %d and merged %d, want 3 and 1", report.Accepted, report.Merged)
+	}
+	if len(report.Dropped) != 2 || report.Dropped[0] != future || report.Dropped[1] != tooOld {
+		t.Errorf("dropped %v, want %v", report.Dropped, []Point{future, tooOld})
+	}
+
+	// The point older than the first archive's retention went to the second
+	point, found, err := w.ValueAt(old.Timestamp)
+	if err != nil || !found || point.Value != 2 {
+		t.Errorf("got %v, %v, %v for the old point", point, found, err)
+	}
+	point, found, err = w.ValueAt(recent)
+	if err != nil || !found || point.Value != 4 {
+		t.Errorf("got %v, %v, %v for the recent point", point, found, err)
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	w, err := NewMemory([]ArchiveInfo{{0, 60, 10}, {0, 300, 10}}, 0, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := w.UpdateManyContext(ctx, []Point{{now - 60, 1}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("update got %v, want %v", err, context.Canceled)
+	}
+	if _, found, _ := w.Latest(); found {
+		t.Error("cancelled update wrote a point")
+	}
+	if _, _, err := w.FetchContext(ctx, now-600, now); !errors.Is(err, context.Canceled) {
+		t.Errorf("fetch got %v, want %v", err, context.Canceled)
+	}
+
+	if err := w.UpdateManyContext(context.Background(), []Point{{now - 60, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := w.FetchContext(context.Background(), now-600, now); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChunkedArchive(t *testing.T) {
+	info := ArchiveInfo{0, 1, chunkPoints + 10}
+	w, err := NewMemory([]ArchiveInfo{info}, 0, AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info = w.Header.Archives[0]
+
+	// Points on either side of the boundary between the chunks
+	base := uint32(1000000)
+	points := archive{{base, 1}, {base + chunkPoints - 1, 2}, {base + chunkPoints, 3}, {base + chunkPoints + 9, 4}}
+	if err := w.writeArchive(info, points); err != nil {
+		t.Fatal(err)
+	}
+	slots, err := w.readArchive(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, point := range points {
+		if slot := slots[point.Timestamp-base]; slot != point {
+			t.Errorf("slot %d holds %v, want %v", point.Timestamp-base, slot, point)
+		}
+	}
+}
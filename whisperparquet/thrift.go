@@ -0,0 +1,87 @@
+package whisperparquet
+
+import "encoding/binary"
+
+// Types of the thrift compact protocol, which parquet encodes its metadata with
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// A thriftWriter encodes structs with the thrift compact protocol. Fields must be written in the
+// order of their ids, as each field's id is encoded relative to the previous one.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // Id of the last field written to each struct being written, innermost last
+}
+
+// Start a struct that is an element of a list, or the top level struct
+func (t *thriftWriter) beginStruct() {
+	t.last = append(t.last, 0)
+}
+
+// Start a struct that is a field of the current struct
+func (t *thriftWriter) beginStructField(id int16) {
+	t.fieldHeader(id, compactStruct)
+	t.beginStruct()
+}
+
+// End the current struct
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) i32(id int16, value int32) {
+	t.fieldHeader(id, compactI32)
+	t.buf = binary.AppendUvarint(t.buf, uint64(uint32(value<<1^value>>31)))
+}
+
+func (t *thriftWriter) i64(id int16, value int64) {
+	t.fieldHeader(id, compactI64)
+	t.buf = binary.AppendUvarint(t.buf, uint64(value<<1^value>>63))
+}
+
+func (t *thriftWriter) string(id int16, value string) {
+	t.fieldHeader(id, compactBinary)
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(value)))
+	t.buf = append(t.buf, value...)
+}
+
+// Start a list field of size elements of a type. Struct elements are each written between
+// beginStruct and endStruct, other elements with the list element functions.
+func (t *thriftWriter) list(id int16, elementType byte, size int) {
+	t.fieldHeader(id, compactList)
+	if size < 15 {
+		t.buf = append(t.buf, byte(size)<<4|elementType)
+	} else {
+		t.buf = append(t.buf, 0xf0|elementType)
+		t.buf = binary.AppendUvarint(t.buf, uint64(size))
+	}
+}
+
+// Write an i32 element of a list
+func (t *thriftWriter) i32Element(value int32) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(uint32(value<<1^value>>31)))
+}
+
+// Write a string element of a list
+func (t *thriftWriter) stringElement(value string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(value)))
+	t.buf = append(t.buf, value...)
+}
+
+// Write the header of a field of the current struct
+func (t *thriftWriter) fieldHeader(id int16, fieldType byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|fieldType)
+	} else {
+		t.buf = append(t.buf, fieldType)
+		t.buf = binary.AppendUvarint(t.buf, uint64(uint16(id<<1^id>>15)))
+	}
+	*last = id
+}
@@ -0,0 +1,281 @@
+/*
+Package whisperparquet exports whisper data to Apache Parquet files, so the history of a tree can be
+queried with analytics tools such as Spark or DuckDB.
+
+Every row is a point, with the columns:
+
+	metric     string, the dotted metric name
+	timestamp  int64, the start of the point's interval in seconds since the epoch
+	value      double
+
+Each archive of a database is written as a row group. An archive only contributes the points older
+than those the archive above it holds, so the rows of a database are its best available history
+without an interval appearing twice, and the resolution of a row can be told by its timestamp.
+
+Files are written with the standard library alone: columns are stored uncompressed with the plain
+encoding, which every Parquet reader supports.
+*/
+package whisperparquet
+
+import (
+	"encoding/binary"
+	"github.com/kisielk/whisper-go/whisper"
+	"github.com/kisielk/whisper-go/whisperwalk"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// Marks the start and end of a parquet file
+const magic = "PAR1"
+
+// Most rows written to a single page of a column
+const pageRows = 1 << 16
+
+// Parquet physical types, repetitions, encodings and logical types used by the export
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	repetitionRequired = 0
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	convertedUTF8 = 0
+)
+
+// A column of the export
+type column struct {
+	name       string
+	physical   int32
+	utf8       bool
+	encodePage func(rows []row) []byte // Encode the values of the column for some rows with the plain encoding
+}
+
+type row struct {
+	metric string
+	point  whisper.Point
+}
+
+var columns = []column{
+	{"metric", typeByteArray, true, func(rows []row) (page []byte) {
+		for _, r := range rows {
+			page = binary.LittleEndian.AppendUint32(page, uint32(len(r.metric)))
+			page = append(page, r.metric...)
+		}
+		return
+	}},
+	{"timestamp", typeInt64, false, func(rows []row) (page []byte) {
+		for _, r := range rows {
+			page = binary.LittleEndian.AppendUint64(page, uint64(r.point.Timestamp))
+		}
+		return
+	}},
+	{"value", typeDouble, false, func(rows []row) (page []byte) {
+		for _, r := range rows {
+			page = binary.LittleEndian.AppendUint64(page, math.Float64bits(r.point.Value))
+		}
+		return
+	}},
+}
+
+// Where a column chunk of a row group was written
+type columnChunk struct {
+	offset int64 // Offset of the first page
+	size   int64 // Size of the pages, including their headers
+	values int64
+}
+
+type rowGroup struct {
+	rows    int64
+	columns []columnChunk
+}
+
+// A Writer writes the points of whisper databases to a Parquet file
+type Writer struct {
+	out       io.Writer
+	offset    int64
+	rowGroups []rowGroup
+	err       error
+}
+
+// Start a Parquet file written to out. Close must be called to finish it.
+func NewWriter(out io.Writer) *Writer {
+	p := &Writer{out: out}
+	p.write([]byte(magic))
+	return p
+}
+
+// Write the points of a database as rows for metric, one row group per archive that has points
+func (p *Writer) WriteDatabase(metric string, w *whisper.Whisper) (err error) {
+	if p.err != nil {
+		return p.err
+	}
+
+	now := uint32(time.Now().Unix())
+	var covered uint32 = math.MaxUint32 // Points after this timestamp are held by a higher archive
+	for _, info := range w.Header.Archives {
+		slots, e := w.ReadSlots(info)
+		if e != nil {
+			return e
+		}
+		start := info.StartTime(now)
+		var rows []row
+		for _, slot := range slots {
+			if slot.Timestamp > start && slot.Timestamp <= now && slot.Timestamp <= covered {
+				rows = append(rows, row{metric, slot})
+			}
+		}
+		if start < covered {
+			covered = start
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].point.Timestamp < rows[j].point.Timestamp })
+		p.writeRowGroup(rows)
+		if p.err != nil {
+			return p.err
+		}
+	}
+	return
+}
+
+// Write the pages of each column for a row group
+func (p *Writer) writeRowGroup(rows []row) {
+	group := rowGroup{rows: int64(len(rows))}
+	for _, c := range columns {
+		chunk := columnChunk{offset: p.offset, values: int64(len(rows))}
+		for start := 0; start < len(rows); start += pageRows {
+			end := start + pageRows
+			if end > len(rows) {
+				end = len(rows)
+			}
+			page := c.encodePage(rows[start:end])
+			header := pageHeader(end-start, len(page))
+			p.write(header)
+			p.write(page)
+			chunk.size += int64(len(header) + len(page))
+		}
+		group.columns = append(group.columns, chunk)
+	}
+	p.rowGroups = append(p.rowGroups, group)
+}
+
+// Encode the header of a data page
+func pageHeader(values, size int) []byte {
+	t := &thriftWriter{}
+	t.beginStruct()
+	t.i32(1, 0) // DATA_PAGE
+	t.i32(2, int32(size))
+	t.i32(3, int32(size))
+	t.beginStructField(5)
+	t.i32(1, int32(values))
+	t.i32(2, encodingPlain)
+	t.i32(3, encodingRLE)
+	t.i32(4, encodingRLE)
+	t.endStruct()
+	t.endStruct()
+	return t.buf
+}
+
+// Finish the file by writing its metadata. Must be called once, after the last WriteDatabase. The
+// underlying writer isn't closed.
+func (p *Writer) Close() error {
+	metadata := p.fileMetadata()
+	p.write(metadata)
+	p.write(binary.LittleEndian.AppendUint32(nil, uint32(len(metadata))))
+	p.write([]byte(magic))
+	return p.err
+}
+
+// Encode the FileMetaData footer describing the schema and every row group
+func (p *Writer) fileMetadata() []byte {
+	var rows int64
+	for _, group := range p.rowGroups {
+		rows += group.rows
+	}
+
+	t := &thriftWriter{}
+	t.beginStruct()
+	t.i32(1, 1)
+	t.list(2, compactStruct, len(columns)+1)
+	t.beginStruct()
+	t.string(4, "schema")
+	t.i32(5, int32(len(columns)))
+	t.endStruct()
+	for _, c := range columns {
+		t.beginStruct()
+		t.i32(1, c.physical)
+		t.i32(3, repetitionRequired)
+		t.string(4, c.name)
+		if c.utf8 {
+			t.i32(6, convertedUTF8)
+		}
+		t.endStruct()
+	}
+	t.i64(3, rows)
+
+	t.list(4, compactStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		t.beginStruct()
+		t.list(1, compactStruct, len(group.columns))
+		var size int64
+		for i, chunk := range group.columns {
+			size += chunk.size
+			t.beginStruct()
+			t.i64(2, chunk.offset)
+			t.beginStructField(3)
+			t.i32(1, columns[i].physical)
+			t.list(2, compactI32, 1)
+			t.i32Element(encodingPlain)
+			t.list(3, compactBinary, 1)
+			t.stringElement(columns[i].name)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, size)
+		t.i64(3, group.rows)
+		t.endStruct()
+	}
+	t.string(6, "whisper-go")
+	t.endStruct()
+	return t.buf
+}
+
+// Write to the file, keeping the first error
+func (p *Writer) write(data []byte) {
+	if p.err != nil {
+		return
+	}
+	var n int
+	n, p.err = p.out.Write(data)
+	p.offset += int64(n)
+}
+
+// Export every database under root to a Parquet file written to out, naming metrics using the
+// tree's layout
+func ExportTree(root string, out io.Writer) (err error) {
+	p := NewWriter(out)
+	// A single worker writes the databases one at a time, in the order they are found
+	err = whisperwalk.Walk(root, 1, func(file whisperwalk.File) error {
+		w, err := whisper.OpenReadOnly(file.Path)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		return p.WriteDatabase(file.Metric, w)
+	})
+	if err != nil {
+		return
+	}
+	return p.Close()
+}
@@ -0,0 +1,208 @@
+package whisperparquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/kisielk/whisper-go/whisper"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A minimal thrift compact protocol decoder, reading structs into maps of field ids to values
+type thriftReader struct {
+	buf []byte
+}
+
+func (r *thriftReader) varint() uint64 {
+	value, n := binary.Uvarint(r.buf)
+	r.buf = r.buf[n:]
+	return value
+}
+
+func (r *thriftReader) zigzag() int64 {
+	value := r.varint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (r *thriftReader) value(fieldType byte) interface{} {
+	switch fieldType {
+	case compactI32, compactI64:
+		return r.zigzag()
+	case compactBinary:
+		n := r.varint()
+		value := string(r.buf[:n])
+		r.buf = r.buf[n:]
+		return value
+	case compactList:
+		header := r.buf[0]
+		r.buf = r.buf[1:]
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		var list []interface{}
+		for i := 0; i < size; i++ {
+			list = append(list, r.value(header&0x0f))
+		}
+		return list
+	case compactStruct:
+		return r.structure()
+	}
+	panic(errors.New("unsupported thrift type"))
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var id int16
+	for {
+		header := r.buf[0]
+		r.buf = r.buf[1:]
+		if header == 0 {
+			return fields
+		}
+		if delta := header >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(header & 0x0f)
+	}
+}
+
+// Read the rows of a file written by Writer
+func readRows(t *testing.T, data []byte) (metrics []string, points []whisper.Point, rowGroups int) {
+	if string(data[:4]) != magic || string(data[len(data)-4:]) != magic {
+		t.Fatal("file isn't framed by PAR1")
+	}
+	size := binary.LittleEndian.Uint32(data[len(data)-8:])
+	footer := &thriftReader{data[len(data)-8-int(size) : len(data)-8]}
+	metadata := footer.structure()
+
+	schema := metadata[2].([]interface{})
+	if len(schema) != 4 || schema[0].(map[int16]interface{})[5] != int64(3) {
+		t.Fatalf("unexpected schema %v", schema)
+	}
+	for i, name := range []string{"metric", "timestamp", "value"} {
+		if element := schema[i+1].(map[int16]interface{}); element[4] != name || element[3] != int64(repetitionRequired) {
+			t.Errorf("column %d is %v", i, element)
+		}
+	}
+
+	for _, group := range metadata[4].([]interface{}) {
+		rowGroups++
+		chunks := group.(map[int16]interface{})[1].([]interface{})
+		rows := int(group.(map[int16]interface{})[3].(int64))
+		var values [3][]byte
+		for i, chunk := range chunks {
+			meta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			offset := meta[9].(int64)
+			end := offset + meta[6].(int64)
+			for offset < end {
+				reader := &thriftReader{data[offset:end]}
+				header := reader.structure()
+				pageSize := int(header[3].(int64))
+				page := reader.buf[:pageSize]
+				values[i] = append(values[i], page...)
+				offset = end - int64(len(reader.buf)) + int64(pageSize)
+			}
+		}
+		for i := 0; i < rows; i++ {
+			n := binary.LittleEndian.Uint32(values[0])
+			metrics = append(metrics, string(values[0][4:4+n]))
+			values[0] = values[0][4+n:]
+			timestamp := binary.LittleEndian.Uint64(values[1][8*i:])
+			value := math.Float64frombits(binary.LittleEndian.Uint64(values[2][8*i:]))
+			points = append(points, whisper.Point{Timestamp: uint32(timestamp), Value: value})
+		}
+	}
+	if metadata[3] != int64(len(points)) {
+		t.Errorf("file has %v rows, read %d", metadata[3], len(points))
+	}
+	return
+}
+
+func TestExportTree(t *testing.T) {
+	root := t.TempDir()
+	now := uint32(time.Now().Unix())
+	recent := now - now%60 - 60
+	old := now - now%600 - 3000
+	databases := map[string][]whisper.Point{
+		"a.wsp":             {{Timestamp: recent, Value: 1}, {Timestamp: recent - 60, Value: 2}},
+		"servers/b/cpu.wsp": {{Timestamp: recent, Value: 3}, {Timestamp: old, Value: 4}},
+	}
+	for rel, points := range databases {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := whisper.Create(path, []whisper.ArchiveInfo{{SecondsPerPoint: 60, Points: 30}, {SecondsPerPoint: 600, Points: 10}}, 0, whisper.AGGREGATION_SUM, false); err != nil {
+			t.Fatal(err)
+		}
+		w, err := whisper.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.UpdateMany(points); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+
+	var out bytes.Buffer
+	if err := ExportTree(root, &out); err != nil {
+		t.Fatal(err)
+	}
+	metrics, points, rowGroups := readRows(t, out.Bytes())
+
+	// The second archive only contributes the point older than the first archive's retention
+	expectedMetrics := []string{"a", "a", "servers.b.cpu", "servers.b.cpu"}
+	expectedPoints := []whisper.Point{{Timestamp: recent - 60, Value: 2}, {Timestamp: recent, Value: 1}, {Timestamp: recent, Value: 3}, {Timestamp: old, Value: 4}}
+	if rowGroups != 3 {
+		t.Errorf("%d row groups, want 3", rowGroups)
+	}
+	if len(points) != len(expectedPoints) {
+		t.Fatalf("exported %v %v, want %v %v", metrics, points, expectedMetrics, expectedPoints)
+	}
+	for i := range points {
+		if metrics[i] != expectedMetrics[i] || points[i] != expectedPoints[i] {
+			t.Errorf("row %d is %s %v, want %s %v", i, metrics[i], points[i], expectedMetrics[i], expectedPoints[i])
+		}
+	}
+}
+
+func TestPagedColumns(t *testing.T) {
+	w, err := whisper.NewMemory([]whisper.ArchiveInfo{{SecondsPerPoint: 1, Points: pageRows + 100}}, 0, whisper.AGGREGATION_AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint32(time.Now().Unix())
+	var points []whisper.Point
+	for i := uint32(1); i <= pageRows+50; i++ {
+		points = append(points, whisper.Point{Timestamp: now - i, Value: float64(i)})
+	}
+	if err := w.UpdateMany(points); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	p := NewWriter(&out)
+	if err := p.WriteDatabase("paged", w); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, read, _ := readRows(t, out.Bytes())
+	if len(read) != len(points) {
+		t.Fatalf("read %d rows, want %d", len(read), len(points))
+	}
+	for i, point := range read {
+		if expected := points[len(points)-1-i]; point != expected {
+			t.Fatalf("row %d is %v, want %v", i, point, expected)
+		}
+	}
+}
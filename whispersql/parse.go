@@ -0,0 +1,203 @@
+package whispersql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A time in a query, either a literal or a placeholder for an argument
+type term struct {
+	value uint32
+	arg   int // Index of the argument, or -1 for a literal
+}
+
+// A parsed query
+type query struct {
+	metric   string
+	from     *term // Start of the range, nil for the default range
+	until    *term
+	step     uint32 // Size of the GROUP BY time buckets, 0 if the values aren't grouped
+	method   whisper.AggregationMethod
+	numInput int // Number of placeholders
+}
+
+// Splits a query into tokens. Double quoted names are returned with their quotes.
+func tokenize(text string) (tokens []string, err error) {
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			end := strings.IndexByte(text[i+1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated quoted name")
+			}
+			tokens = append(tokens, text[i:i+end+2])
+			i += end + 2
+		case strings.ContainsRune(",()?;*", c):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			start := i
+			for i < len(text) && (text[i] == '_' || unicode.IsLetter(rune(text[i])) || unicode.IsDigit(rune(text[i]))) {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		default:
+			return nil, errors.New(fmt.Sprintf("unexpected character %q", c))
+		}
+	}
+	return
+}
+
+// A parser over the tokens of a query
+type parser struct {
+	tokens []string
+	query  query
+}
+
+// Returns the next token without consuming it, or "" at the end of the query
+func (p *parser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+// Consumes the next token if it's the given keyword or symbol
+func (p *parser) accept(token string) bool {
+	if strings.EqualFold(p.peek(), token) {
+		p.tokens = p.tokens[1:]
+		return true
+	}
+	return false
+}
+
+// Consumes a sequence of keywords or symbols, failing if the query doesn't continue with them
+func (p *parser) expect(tokens ...string) error {
+	for _, token := range tokens {
+		if !p.accept(token) {
+			if p.peek() == "" {
+				return errors.New(fmt.Sprintf("expected %s at the end of the query", token))
+			}
+			return errors.New(fmt.Sprintf("expected %s, found %s", token, p.peek()))
+		}
+	}
+	return nil
+}
+
+// Consumes a number of seconds or a placeholder
+func (p *parser) term() (t *term, err error) {
+	if p.accept("?") {
+		t = &term{arg: p.query.numInput}
+		p.query.numInput++
+		return
+	}
+	value, err := strconv.ParseUint(p.peek(), 10, 32)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("expected a unix timestamp, found %s", p.peek()))
+	}
+	p.tokens = p.tokens[1:]
+	return &term{uint32(value), -1}, nil
+}
+
+/*
+Parses a query of the form:
+
+	SELECT time, value FROM "metric" [WHERE time BETWEEN from AND until] [GROUP BY time(seconds)]
+
+The value column may be wrapped in an aggregation function, such as max(value), which sets the
+method used by GROUP BY. Times are unix timestamps or ? placeholders.
+*/
+func parse(text string) (q query, err error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return
+	}
+	p := &parser{tokens: tokens, query: query{method: whisper.AGGREGATION_AVERAGE}}
+
+	err = p.expect("SELECT", "time", ",")
+	if err != nil {
+		return
+	}
+	grouped := false
+	if !p.accept("value") {
+		function := p.peek()
+		if function == "" {
+			return q, errors.New("expected value at the end of the query")
+		}
+		p.tokens = p.tokens[1:]
+		if strings.EqualFold(function, "avg") {
+			function = "average"
+		}
+		p.query.method, err = whisper.ParseAggregationMethod(strings.ToLower(function))
+		if err != nil {
+			return
+		}
+		err = p.expect("(", "value", ")")
+		if err != nil {
+			return
+		}
+		grouped = true
+	}
+
+	err = p.expect("FROM")
+	if err != nil {
+		return
+	}
+	metric := p.peek()
+	if len(metric) < 2 || metric[0] != '"' {
+		return q, errors.New(fmt.Sprintf("expected a quoted metric name, found %s", metric))
+	}
+	p.query.metric = metric[1 : len(metric)-1]
+	p.tokens = p.tokens[1:]
+
+	if p.accept("WHERE") {
+		err = p.expect("time", "BETWEEN")
+		if err != nil {
+			return
+		}
+		p.query.from, err = p.term()
+		if err != nil {
+			return
+		}
+		err = p.expect("AND")
+		if err != nil {
+			return
+		}
+		p.query.until, err = p.term()
+		if err != nil {
+			return
+		}
+	}
+
+	if p.accept("GROUP") {
+		err = p.expect("BY", "time", "(")
+		if err != nil {
+			return
+		}
+		step, e := strconv.ParseUint(p.peek(), 10, 32)
+		if e != nil || step == 0 {
+			return q, errors.New(fmt.Sprintf("expected a bucket size in seconds, found %s", p.peek()))
+		}
+		p.tokens = p.tokens[1:]
+		p.query.step = uint32(step)
+		err = p.expect(")")
+		if err != nil {
+			return
+		}
+	} else if grouped {
+		return q, errors.New("aggregation functions need a GROUP BY time clause")
+	}
+
+	p.accept(";")
+	if p.peek() != "" {
+		return q, errors.New(fmt.Sprintf("unexpected %s at the end of the query", p.peek()))
+	}
+	return p.query, nil
+}
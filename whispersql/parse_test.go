@@ -0,0 +1,43 @@
+package whispersql
+
+import (
+	"github.com/kisielk/whisper-go/whisper"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	q, err := parse(`select time, MAX(value) from "servers.web1.cpu" where time between 100 and ? group by time(60);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.metric != "servers.web1.cpu" || q.step != 60 || q.method != whisper.AGGREGATION_MAX || q.numInput != 1 {
+		t.Errorf("parsed %+v", q)
+	}
+	if *q.from != (term{100, -1}) || *q.until != (term{0, 0}) {
+		t.Errorf("range parsed as %+v to %+v", *q.from, *q.until)
+	}
+
+	q, err = parse(`SELECT time, value FROM "a.b"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.from != nil || q.step != 0 {
+		t.Errorf("parsed %+v", q)
+	}
+
+	for _, text := range []string{
+		`SELECT value FROM "a.b"`,
+		`SELECT time, value FROM a.b`,
+		`SELECT time, value FROM "a.b`,
+		`SELECT time, median(value) FROM "a.b" GROUP BY time(60)`,
+		`SELECT time, sum(value) FROM "a.b"`,
+		`SELECT time, value FROM "a.b" WHERE time BETWEEN 1 AND`,
+		`SELECT time, value FROM "a.b" GROUP BY time(0)`,
+		`SELECT time, value FROM "a.b" LIMIT 5`,
+		`SELECT time,`,
+	} {
+		if _, err := parse(text); err == nil {
+			t.Errorf("parsed %s without an error", text)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Package whispersql is a read-only database/sql driver for whisper trees, so tools that speak SQL can
+query whisper data directly.
+
+The driver is registered as "whisper" and the data source name is the root of the tree. Metrics are
+found using the tree's layout, see whisperwalk.ReadLayout. Queries select the intervals of one metric
+that have a value:
+
+	db, err := sql.Open("whisper", "/opt/graphite/storage/whisper")
+	rows, err := db.Query(`SELECT time, max(value) FROM "servers.web1.cpu"
+		WHERE time BETWEEN ? AND ? GROUP BY time(3600)`, from, until)
+
+time is a unix timestamp in seconds and value is a float. Without a WHERE clause the last 24 hours
+are returned. GROUP BY time(seconds) aggregates the values into buckets of that size, averaging them
+unless the value is wrapped in another aggregation method, such as sum, min, max or last.
+*/
+package whispersql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/kisielk/whisper-go/whisper"
+	"github.com/kisielk/whisper-go/whisperwalk"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+func init() {
+	sql.Register("whisper", Driver{})
+}
+
+// Driver is the database/sql driver for whisper trees
+type Driver struct{}
+
+// Open a connection to the whisper tree under root
+func (Driver) Open(root string) (driver.Conn, error) {
+	layout, err := whisperwalk.ReadLayout(root)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{root, layout}, nil
+}
+
+// ErrReadOnly is returned for statements that would change a tree
+var ErrReadOnly = errors.New("whispersql: whisper trees are read-only")
+
+type conn struct {
+	root   string
+	layout whisperwalk.Layout
+}
+
+func (c *conn) Prepare(text string) (driver.Stmt, error) {
+	q, err := parse(text)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("whispersql: %s", err))
+	}
+	return &stmt{c, q}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, ErrReadOnly
+}
+
+type stmt struct {
+	conn  *conn
+	query query
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.query.numInput
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	now := uint32(time.Now().Unix())
+	from, until := now-24*60*60, now
+	if s.query.from != nil {
+		var err error
+		from, err = resolve(s.query.from, args)
+		if err != nil {
+			return nil, err
+		}
+		until, err = resolve(s.query.until, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := whisper.OpenReadOnly(filepath.Join(s.conn.root, s.conn.layout.Path(s.query.metric)))
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	series, err := w.FetchSeries(from, until)
+	if err != nil {
+		return nil, err
+	}
+	if s.query.step != 0 {
+		series, err = series.GroupBy(s.query.step, s.query.method)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &rows{series: series}, nil
+}
+
+// Returns the timestamp a term stands for
+func resolve(t *term, args []driver.Value) (uint32, error) {
+	if t.arg < 0 {
+		return t.value, nil
+	}
+	switch arg := args[t.arg].(type) {
+	case int64:
+		if arg < 0 {
+			return 0, errors.New(fmt.Sprintf("whispersql: negative timestamp %d", arg))
+		}
+		return uint32(arg), nil
+	case time.Time:
+		return uint32(arg.Unix()), nil
+	}
+	return 0, errors.New(fmt.Sprintf("whispersql: argument %d must be a unix timestamp or a time, not %T", t.arg+1, args[t.arg]))
+}
+
+// The intervals of a series that have a value
+type rows struct {
+	series whisper.Series
+	next   int
+}
+
+func (r *rows) Columns() []string {
+	return []string{"time", "value"}
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	for ; r.next < len(r.series.Values); r.next++ {
+		if value := r.series.Values[r.next]; value != nil {
+			dest[0] = int64(r.series.From + uint32(r.next)*r.series.Step)
+			dest[1] = *value
+			r.next++
+			return nil
+		}
+	}
+	return io.EOF
+}
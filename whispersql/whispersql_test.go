@@ -0,0 +1,84 @@
+package whispersql
+
+import (
+	"database/sql"
+	"github.com/kisielk/whisper-go/whisper"
+	"github.com/kisielk/whisper-go/whisperwalk"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	root := t.TempDir()
+	path := whisperwalk.MetricPath(root, "servers.web1.cpu")
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := whisper.Create(path, []whisper.ArchiveInfo{{SecondsPerPoint: 10, Points: 360}}, 0.5, whisper.AGGREGATION_AVERAGE, false); err != nil {
+		t.Fatal(err)
+	}
+	w, err := whisper.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minute := uint32(time.Now().Unix()) / 60 * 60
+	start := minute - 120
+	var points []whisper.Point
+	for ts := start; ts < minute; ts += 10 {
+		if ts != start+20 {
+			points = append(points, whisper.Point{Timestamp: ts, Value: float64(ts - start)})
+		}
+	}
+	err = w.UpdateMany(points)
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("whisper", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	collect := func(query string, args ...interface{}) (times []int64, values []float64) {
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var ts int64
+			var value float64
+			if err := rows.Scan(&ts, &value); err != nil {
+				t.Fatal(err)
+			}
+			times = append(times, ts)
+			values = append(values, value)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// The interval without a value isn't returned
+	times, values := collect(`SELECT time, value FROM "servers.web1.cpu" WHERE time BETWEEN ? AND ?`, int64(start-1), int64(minute-1))
+	if len(times) != 11 || times[0] != int64(start) || values[2] != 30 {
+		t.Errorf("got times %v values %v", times, values)
+	}
+
+	times, values = collect(`SELECT time, max(value) FROM "servers.web1.cpu" WHERE time BETWEEN ? AND ? GROUP BY time(60)`, int64(start-1), time.Unix(int64(minute-1), 0))
+	if len(times) != 2 || times[0] != int64(start) || values[0] != 50 || values[1] != 110 {
+		t.Errorf("got times %v values %v", times, values)
+	}
+
+	if _, err := db.Exec(`SELECT time, value FROM "servers.web1.cpu"`); err == nil {
+		t.Error("Exec succeeded on a read-only tree")
+	}
+	if _, err := db.Query(`SELECT time, value FROM "servers.web2.cpu"`); err == nil {
+		t.Error("queried a metric that doesn't exist")
+	}
+}
@@ -0,0 +1,38 @@
+package whisperwalk
+
+import (
+	"github.com/kisielk/whisper-go/whisper"
+	"os"
+)
+
+// ChangeFunc is called by ChangesSince with the new points of a database
+type ChangeFunc func(file File, points []whisper.Point) error
+
+/*
+ChangesSince calls fn with the points after since of every database under root that has any, running
+up to workers calls concurrently, so incremental exporters can process only what changed since their
+last run. Databases whose files haven't been modified since are skipped without being read. See
+Whisper.ChangesSince for how new points are found. Errors are handled like Walk.
+*/
+func ChangesSince(root string, since uint32, workers int, fn ChangeFunc) error {
+	return Walk(root, workers, func(file File) error {
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Unix() < int64(since) {
+			return nil
+		}
+
+		w, err := whisper.OpenReadOnly(file.Path)
+		if err != nil {
+			return err
+		}
+		points, err := w.ChangesSince(since)
+		w.Close()
+		if err != nil || len(points) == 0 {
+			return err
+		}
+		return fn(file, points)
+	})
+}
@@ -0,0 +1,57 @@
+package whisperwalk
+
+import (
+	"github.com/kisielk/whisper-go/whisper"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChangesSince(t *testing.T) {
+	root := t.TempDir()
+	now := uint32(time.Now().Unix())
+	now -= now % 60
+	since := now - 120
+
+	for name, points := range map[string][]whisper.Point{
+		"new.wsp":     {{Timestamp: now - 180, Value: 1}, {Timestamp: now - 60, Value: 2}},
+		"old.wsp":     {{Timestamp: now - 180, Value: 3}},
+		"skipped.wsp": {{Timestamp: now - 60, Value: 4}},
+	} {
+		path := filepath.Join(root, name)
+		if err := whisper.Create(path, []whisper.ArchiveInfo{{SecondsPerPoint: 60, Points: 10}}, 0.5, whisper.AGGREGATION_AVERAGE, false); err != nil {
+			t.Fatal(err)
+		}
+		w, err := whisper.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.UpdateMany(points)
+		w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A file that hasn't been modified since isn't read
+	old := time.Unix(int64(since)-3600, 0)
+	if err := os.Chtimes(filepath.Join(root, "skipped.wsp"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	changes := make(map[string][]whisper.Point)
+	err := ChangesSince(root, since, 2, func(file File, points []whisper.Point) error {
+		mutex.Lock()
+		changes[file.Metric] = points
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || len(changes["new"]) != 1 || changes["new"][0] != (whisper.Point{Timestamp: now - 60, Value: 2}) {
+		t.Errorf("changes = %v", changes)
+	}
+}
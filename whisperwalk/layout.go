@@ -0,0 +1,91 @@
+package whisperwalk
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Layout maps metric names to the paths of their databases within a tree, and back
+type Layout interface {
+	// Returns the path of the database storing a metric, relative to the root of the tree
+	Path(metric string) string
+	// Returns the metric stored at a path relative to the root of the tree
+	Metric(rel string) string
+}
+
+// FlatLayout is graphite's standard layout, where every component of a metric name is a
+// directory, eg: servers.web1.cpu is stored at servers/web1/cpu.wsp
+type FlatLayout struct{}
+
+func (FlatLayout) Path(metric string) string {
+	return filepath.FromSlash(strings.Replace(metric, ".", "/", -1)) + ".wsp"
+}
+
+func (FlatLayout) Metric(rel string) string {
+	rel = strings.TrimSuffix(filepath.ToSlash(rel), ".wsp")
+	return strings.Replace(rel, "/", ".", -1)
+}
+
+/*
+HashedLayout spreads databases over a fixed fan-out of directories named after a hash of the metric
+name, so no directory grows to hundreds of thousands of files. The database is named after the full
+metric name, so the metric can be recovered from the path, eg: with 2 levels of width 2,
+servers.web1.cpu is stored at 3f/a2/servers.web1.cpu.wsp.
+*/
+type HashedLayout struct {
+	Levels int // Number of directory levels
+	Width  int // Number of hex digits of the hash in each directory name
+}
+
+func (l HashedLayout) Path(metric string) string {
+	sum := sha1.Sum([]byte(metric))
+	digits := hex.EncodeToString(sum[:])
+	var dirs []string
+	for i := 0; i < l.Levels; i++ {
+		dirs = append(dirs, digits[i*l.Width:(i+1)*l.Width])
+	}
+	return filepath.Join(append(dirs, metric+".wsp")...)
+}
+
+func (l HashedLayout) Metric(rel string) string {
+	return strings.TrimSuffix(filepath.Base(rel), ".wsp")
+}
+
+// Returns the path of the file configuring the layout of the tree under root
+func LayoutPath(root string) string {
+	return filepath.Join(root, ".layout")
+}
+
+/*
+ReadLayout returns the layout of the tree under root, configured in a .layout file at its top. The
+file contains either "flat", or "hashed LEVELS WIDTH" for a HashedLayout. Trees without a .layout
+file use the FlatLayout.
+*/
+func ReadLayout(root string) (layout Layout, err error) {
+	data, err := os.ReadFile(LayoutPath(root))
+	if os.IsNotExist(err) {
+		return FlatLayout{}, nil
+	}
+	if err != nil {
+		return
+	}
+
+	fields := strings.Fields(string(data))
+	switch {
+	case len(fields) == 1 && fields[0] == "flat":
+		return FlatLayout{}, nil
+	case len(fields) == 3 && fields[0] == "hashed":
+		levels, e1 := strconv.Atoi(fields[1])
+		width, e2 := strconv.Atoi(fields[2])
+		if e1 == nil && e2 == nil && levels > 0 && width > 0 && levels*width <= sha1.Size*2 {
+			return HashedLayout{levels, width}, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("%s: invalid layout %q", LayoutPath(root), strings.TrimSpace(string(data))))
+}
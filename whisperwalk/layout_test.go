@@ -0,0 +1,65 @@
+package whisperwalk
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashedLayout(t *testing.T) {
+	layout := HashedLayout{Levels: 2, Width: 2}
+	path := layout.Path("servers.web1.cpu")
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	if len(parts) != 3 || len(parts[0]) != 2 || len(parts[1]) != 2 || parts[2] != "servers.web1.cpu.wsp" {
+		t.Errorf("Path() = %q", path)
+	}
+	if metric := layout.Metric(path); metric != "servers.web1.cpu" {
+		t.Errorf("Metric(%q) = %q", path, metric)
+	}
+}
+
+func TestWalkHashedLayout(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(LayoutPath(root), []byte("hashed 1 3\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	layout, err := ReadLayout(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, layout.Path("servers.web1.cpu"))
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var metrics []string
+	err = Walk(root, 1, func(file File) error {
+		metrics = append(metrics, file.Metric)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0] != "servers.web1.cpu" {
+		t.Errorf("walked %v", metrics)
+	}
+}
+
+func TestReadLayout(t *testing.T) {
+	root := t.TempDir()
+	if layout, err := ReadLayout(root); err != nil || layout != (FlatLayout{}) {
+		t.Errorf("ReadLayout() = %v, %v without a .layout file", layout, err)
+	}
+	for _, invalid := range []string{"hashed", "hashed 0 2", "hashed 30 2", "sharded 2 2"} {
+		if err := os.WriteFile(LayoutPath(root), []byte(invalid), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadLayout(root); err == nil {
+			t.Errorf("no error for layout %q", invalid)
+		}
+	}
+}
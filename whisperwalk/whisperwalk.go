@@ -0,0 +1,110 @@
+/*
+Package whisperwalk finds the whisper databases in a directory tree and runs a function on each of
+them concurrently, the starting point of most bulk maintenance jobs.
+*/
+package whisperwalk
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// File is a whisper database found in a tree
+type File struct {
+	Path   string // Path of the database, including the root
+	Rel    string // Path of the database relative to the root
+	Metric string // The dotted metric name the database stores, eg: servers.web1.cpu
+}
+
+// WalkFunc is called for every database found by Walk
+type WalkFunc func(file File) error
+
+/*
+Walk calls fn for every .wsp file under root, running up to workers calls concurrently. Metric
+names are derived using the layout configured for root by its .layout file.
+
+If fn returns an error no further calls are started, and Walk returns the first error once the
+calls in progress have finished. Errors reading the tree are returned the same way.
+*/
+func Walk(root string, workers int, fn WalkFunc) (err error) {
+	layout, err := ReadLayout(root)
+	if err != nil {
+		return
+	}
+	return WalkLayout(root, layout, workers, fn)
+}
+
+// WalkLayout is like Walk, but derives metric names using the given layout
+func WalkLayout(root string, layout Layout, workers int, fn WalkFunc) (err error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	files := make(chan File)
+	stop := make(chan struct{})
+	var once sync.Once
+	var firstErr error
+	fail := func(e error) {
+		once.Do(func() {
+			firstErr = e
+			close(stop)
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				if e := fn(file); e != nil {
+					fail(e)
+				}
+			}
+		}()
+	}
+
+	walkErr := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".wsp") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return filepath.SkipAll
+		default:
+		}
+		select {
+		case files <- File{path, rel, layout.Metric(rel)}:
+			return nil
+		case <-stop:
+			return filepath.SkipAll
+		}
+	})
+	close(files)
+	wg.Wait()
+
+	if walkErr != nil {
+		fail(walkErr)
+	}
+	return firstErr
+}
+
+// Returns the metric name stored at a path relative to the root of a tree with the FlatLayout,
+// eg: servers/web1/cpu.wsp is servers.web1.cpu
+func MetricName(rel string) string {
+	return FlatLayout{}.Metric(rel)
+}
+
+// Returns the path of the database storing a metric in the tree under root with the FlatLayout
+func MetricPath(root, metric string) string {
+	return filepath.Join(root, FlatLayout{}.Path(metric))
+}
@@ -0,0 +1,62 @@
+package whisperwalk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.wsp", "servers/web1/cpu.wsp", "servers/web1/cpu.wsp.bak", "servers/web2/load.wsp"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mutex sync.Mutex
+	var metrics []string
+	err := Walk(root, 3, func(file File) error {
+		if file.Path != MetricPath(root, file.Metric) {
+			t.Errorf("MetricPath(%q) = %q, want %q", file.Metric, MetricPath(root, file.Metric), file.Path)
+		}
+		mutex.Lock()
+		metrics = append(metrics, file.Metric)
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(metrics)
+	expected := []string{"a", "servers.web1.cpu", "servers.web2.load"}
+	if len(metrics) != len(expected) {
+		t.Fatalf("walked %v, want %v", metrics, expected)
+	}
+	for i := range expected {
+		if metrics[i] != expected[i] {
+			t.Errorf("walked %v, want %v", metrics, expected)
+		}
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = Walk(root, 1, func(file File) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls > 2 {
+		t.Errorf("Walk returned %v after %d calls", err, calls)
+	}
+
+	if err := Walk(filepath.Join(root, "missing"), 1, func(File) error { return nil }); err == nil {
+		t.Error("expected an error walking a missing directory")
+	}
+}